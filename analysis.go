@@ -0,0 +1,571 @@
+/*
+ * File: analysis.go
+ *
+ * Description: Accumulates the data gathered from the access and error log
+ *              entries.
+ *
+ * Author: Robert Bisewski <contact@ibiscybernetics.com>
+ */
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/rbisewski/ndefence/ndefenceDetect"
+	"github.com/rbisewski/ndefence/ndefenceLog"
+	"github.com/rbisewski/ndefence/ndefenceStats"
+	"github.com/rbisewski/ndefence/ndefenceUtils"
+)
+
+//
+// Globals
+//
+var (
+
+	// Name of the file, in the state directory, holding the analysis
+	analysisStateFile = "analysis.state"
+)
+
+//
+// Analysis object definition
+//
+type analysis struct {
+
+	// Time of the newest entry seen; every window ends at this time, so
+	// that older logs can be analyzed just the same as live ones
+	latestTime time.Time
+
+	// Longest span of time that any of the windows cover
+	window time.Duration
+
+	// Request counts of every IP address, by site, over time
+	counters map[string]map[string]*ndefenceStats.SlidingCounter
+
+	// Redirects issued, oldest first, along with the classifier telling
+	// them apart
+	redirects          []redirectEntry
+	redirectClassifier *ndefenceLog.RedirectClassifier
+
+	// Error counts of every IP address, by category, over time
+	errors map[string]map[string]*ndefenceStats.SlidingCounter
+
+	// Request counts of every IP address, by class of user agent, over
+	// time, along with the classifier deciding the classes
+	agentCounters map[string]map[string]*ndefenceStats.SlidingCounter
+	agents        *ndefenceLog.AgentClassifier
+
+	// Detectors deciding which addresses to block, and the threat scores
+	// their findings add up to
+	detectors *ndefenceDetect.Engine
+	threats   *ndefenceDetect.ThreatScores
+
+	// Number of entries, since the analysis was assembled, that the
+	// detectors wanted evaluated right away
+	urgentSeen int
+}
+
+//
+// Single redirect issued to a client
+//
+type redirectEntry struct {
+	IP       string    `json:"ip"`
+	Site     string    `json:"site"`
+	Status   int       `json:"status"`
+	Kind     string    `json:"kind"`
+	Location string    `json:"location"`
+	Time     time.Time `json:"time"`
+}
+
+//
+// Persisted form of an analysis
+//
+type analysisSnapshot struct {
+	LatestTime time.Time                                           `json:"latest_time"`
+	Counters   map[string]map[string]*ndefenceStats.SlidingCounter `json:"site_counters"`
+	Redirects  []redirectEntry                                     `json:"redirects"`
+
+	Errors map[string]map[string]*ndefenceStats.SlidingCounter `json:"errors"`
+	Agents map[string]map[string]*ndefenceStats.SlidingCounter `json:"agents"`
+
+	Detectors map[string]json.RawMessage `json:"detectors"`
+	Threats   json.RawMessage            `json:"threats"`
+}
+
+//! Assemble an empty analysis.
+/*
+ * @param     duration               longest span of time the windows cover
+ * @param     *Engine                detectors deciding which addresses to
+ *                                   block
+ * @param     *AgentClassifier       classifier of the user agents
+ * @param     *RedirectClassifier    classifier of the redirects
+ * @param     *ThreatScores          threat scores of the addresses
+ *
+ * @return    *analysis              new analysis
+ */
+func newAnalysis(window time.Duration, detectors *ndefenceDetect.Engine,
+	agents *ndefenceLog.AgentClassifier,
+	redirects *ndefenceLog.RedirectClassifier,
+	threats *ndefenceDetect.ThreatScores) *analysis {
+	return &analysis{
+		window:        window,
+		counters:      make(map[string]map[string]*ndefenceStats.SlidingCounter),
+		redirects:     make([]redirectEntry, 0),
+		errors:        make(map[string]map[string]*ndefenceStats.SlidingCounter),
+		agentCounters: make(map[string]map[string]*ndefenceStats.SlidingCounter),
+		agents:        agents,
+		detectors:     detectors,
+		threats:       threats,
+
+		redirectClassifier: redirects,
+	}
+}
+
+//! Add a single entry to the analysis.
+/*
+ * @param     LogEntry    parsed entry
+ */
+func (a *analysis) add(entry ndefenceLog.LogEntry) {
+
+	// grab the client IP address, in its canonical form so that every
+	// spelling of an IPv6 address is counted as the same client
+	ip, err := ndefenceUtils.CanonicalIPAddress(entry.ClientIP)
+	if err != nil {
+		return
+	}
+
+	// skip anything that already fell out of the window
+	if entry.Time.Before(a.latestTime.Add(-a.window)) {
+		return
+	}
+	if entry.Time.After(a.latestTime) {
+		a.latestTime = entry.Time
+	}
+
+	// since the ip address is valid, go ahead and count it, by site and
+	// by class of user agent
+	entry.ClientIP = ip
+	entry.Agent = a.agents.Classify(entry.UserAgent)
+	entry.Redirect = a.redirectClassifier.Classify(entry)
+	countEvent(a.counters, ip, entry.Site, entry.Time, a.window)
+	countEvent(a.agentCounters, ip, entry.Agent, entry.Time, a.window)
+
+	// pass the entry on to the detectors
+	if a.detectors.Observe(entry) {
+		a.urgentSeen++
+	}
+
+	// note every redirect, along with where it went, if that was logged
+	if entry.Redirect == "" {
+		return
+	}
+
+	location := entry.Location
+	if len(location) < 1 {
+		location = "-"
+	}
+
+	// several logs are read one after the other, so the redirects are kept
+	// sorted by time as they arrive, for the windows to find their start
+	redirect := redirectEntry{IP: ip, Site: entry.Site,
+		Status: entry.Status, Kind: entry.Redirect, Location: location,
+		Time: entry.Time}
+	i := sort.Search(len(a.redirects), func(i int) bool {
+		return a.redirects[i].Time.After(redirect.Time)
+	})
+
+	a.redirects = append(a.redirects, redirectEntry{})
+	copy(a.redirects[i+1:], a.redirects[i:])
+	a.redirects[i] = redirect
+}
+
+//! Add a single error log entry to the analysis.
+/*
+ * @param     ErrorEntry    parsed entry
+ */
+func (a *analysis) addError(entry ndefenceLog.ErrorEntry) {
+
+	// errors not caused by a client, e.g. at startup, are of no interest
+	ip, err := ndefenceUtils.CanonicalIPAddress(entry.ClientIP)
+	if err != nil {
+		return
+	}
+
+	// skip anything that already fell out of the window
+	if entry.Time.Before(a.latestTime.Add(-a.window)) {
+		return
+	}
+	if entry.Time.After(a.latestTime) {
+		a.latestTime = entry.Time
+	}
+
+	countEvent(a.errors, ip, entry.Category, entry.Time, a.window)
+
+	// pass the entry on to the detectors, using the canonical address
+	entry.ClientIP = ip
+	if a.detectors.ObserveError(entry) {
+		a.urgentSeen++
+	}
+}
+
+//! Request counts of every IP address within the given span of time.
+/*
+ * @param     duration    span of time, ending at the newest entry
+ *
+ * @return    map         map[IP address] = count
+ */
+func (a *analysis) ipCounts(span time.Duration) map[string]int {
+
+	counts := make(map[string]int)
+	for ip, sites := range a.counters {
+		for _, counter := range sites {
+			counts[ip] += counter.CountWithin(a.latestTime, span)
+		}
+		if counts[ip] < 1 {
+			delete(counts, ip)
+		}
+	}
+
+	return counts
+}
+
+//! Request counts of every IP address, by site, within the given span of
+//! time.
+/*
+ * @param     duration    span of time, ending at the newest entry
+ *
+ * @return    map         map[IP address][site] = count
+ */
+func (a *analysis) siteCounts(span time.Duration) map[string]map[string]int {
+	return countsWithin(a.counters, a.latestTime, span)
+}
+
+//! Request counts of every IP address, by class of user agent, within the
+//! given span of time.
+/*
+ * @param     duration    span of time, ending at the newest entry
+ *
+ * @return    map         map[IP address][class] = count
+ */
+func (a *analysis) agentCounts(span time.Duration) map[string]map[string]int {
+	return countsWithin(a.agentCounters, a.latestTime, span)
+}
+
+//! Redirects issued within the given span of time, oldest first; the
+//! redirects are sorted by time.
+/*
+ * @param     duration           span of time, ending at the newest entry
+ *
+ * @return    redirectEntry[]    redirects
+ */
+func (a *analysis) redirectsWithin(span time.Duration) []redirectEntry {
+
+	since := a.latestTime.Add(-span)
+	i := sort.Search(len(a.redirects), func(i int) bool {
+		return !a.redirects[i].Time.Before(since)
+	})
+
+	return a.redirects[i:]
+}
+
+//! Error counts of every IP address, by category, within the given span
+//! of time.
+/*
+ * @param     duration    span of time, ending at the newest entry
+ *
+ * @return    map         map[IP address][category] = count
+ */
+func (a *analysis) errorCounts(span time.Duration) map[string]map[string]int {
+	return countsWithin(a.errors, a.latestTime, span)
+}
+
+//! Discard everything that fell out of the longest window.
+func (a *analysis) prune() {
+
+	pruneCounters(a.counters, a.latestTime)
+	pruneCounters(a.errors, a.latestTime)
+	pruneCounters(a.agentCounters, a.latestTime)
+	a.redirectClassifier.Prune(a.latestTime)
+	a.detectors.Prune(a.latestTime)
+	a.threats.Prune(a.latestTime)
+
+	a.redirects = append([]redirectEntry{},
+		a.redirectsWithin(a.window)...)
+}
+
+//! Persist the analysis, so that the next run continues the windows.
+/*
+ * @param     string    /path/to/state/directory
+ *
+ * @return    error     error message, if any
+ */
+func (a *analysis) save(stateDir string) error {
+
+	a.prune()
+
+	detectorStates, err := a.detectors.Save()
+	if err != nil {
+		return err
+	}
+	threats, err := a.threats.Save()
+	if err != nil {
+		return err
+	}
+
+	contents, err := json.Marshal(analysisSnapshot{
+		LatestTime: a.latestTime,
+		Counters:   a.counters,
+		Redirects:  a.redirects,
+		Errors:     a.errors,
+		Agents:     a.agentCounters,
+		Detectors:  detectorStates,
+		Threats:    threats,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return err
+	}
+
+	path := filepath.Join(stateDir, analysisStateFile)
+	if err := ioutil.WriteFile(path+".tmp", contents, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+//! Restore a persisted analysis; a missing or corrupt one is ignored.
+/*
+ * @param     string    /path/to/state/directory
+ */
+func (a *analysis) load(stateDir string) {
+
+	contents, err := ioutil.ReadFile(filepath.Join(stateDir,
+		analysisStateFile))
+	if err != nil {
+		return
+	}
+
+	snapshot := analysisSnapshot{}
+	if err := json.Unmarshal(contents, &snapshot); err != nil {
+		return
+	}
+
+	a.latestTime = snapshot.LatestTime
+	a.redirects = snapshot.Redirects
+
+	// redirects saved before they were classified are of an unknown class
+	for i := range a.redirects {
+		if a.redirects[i].Kind == "" {
+			a.redirects[i].Kind = ndefenceLog.RedirectUnknown
+		}
+	}
+
+	// redirects saved before they were kept sorted may be in the order of
+	// the logs they were read from
+	sort.SliceStable(a.redirects, func(i, j int) bool {
+		return a.redirects[i].Time.Before(a.redirects[j].Time)
+	})
+
+	restoreCounters(a.counters, snapshot.Counters, a.window)
+	restoreCounters(a.errors, snapshot.Errors, a.window)
+	restoreCounters(a.agentCounters, snapshot.Agents, a.window)
+	a.detectors.Restore(snapshot.Detectors)
+	if len(snapshot.Threats) > 0 {
+		if err := a.threats.Restore(snapshot.Threats); err != nil {
+			fmt.Println("Warning: discarding the saved threat scores: " +
+				err.Error())
+		}
+	}
+
+	a.prune()
+}
+
+//! Count a single event of an IP address under a given key, e.g. a request
+//! to a site.
+/*
+ * @param     map          map[IP address][key] = counter
+ * @param     string       IP address
+ * @param     string       key, e.g. site or error category
+ * @param     time.Time    time of the event
+ * @param     duration     window of any counter assembled
+ */
+func countEvent(counters map[string]map[string]*ndefenceStats.SlidingCounter,
+	ip string, key string, t time.Time, window time.Duration) {
+
+	keyed, ok := counters[ip]
+	if !ok {
+		keyed = make(map[string]*ndefenceStats.SlidingCounter)
+		counters[ip] = keyed
+	}
+
+	counter, ok := keyed[key]
+	if !ok {
+		counter = ndefenceStats.NewSlidingCounter(window, windowResolution)
+		keyed[key] = counter
+	}
+	counter.Add(t, 1)
+}
+
+//! Counts of every IP address, by key, within the given span of time.
+/*
+ * @param     map          map[IP address][key] = counter
+ * @param     time.Time    end of the span
+ * @param     duration     span of time
+ *
+ * @return    map          map[IP address][key] = count
+ */
+func countsWithin(counters map[string]map[string]*ndefenceStats.SlidingCounter,
+	now time.Time, span time.Duration) map[string]map[string]int {
+
+	counts := make(map[string]map[string]int)
+	for ip, keyed := range counters {
+		for key, counter := range keyed {
+
+			count := counter.CountWithin(now, span)
+			if count < 1 {
+				continue
+			}
+
+			if _, ok := counts[ip]; !ok {
+				counts[ip] = make(map[string]int)
+			}
+			counts[ip][key] = count
+		}
+	}
+
+	return counts
+}
+
+//! Discard the counts that fell out of the window of each counter, along
+//! with any counters left empty.
+/*
+ * @param     map          map[IP address][key] = counter
+ * @param     time.Time    end of the windows
+ */
+func pruneCounters(counters map[string]map[string]*ndefenceStats.SlidingCounter,
+	now time.Time) {
+
+	for ip, keyed := range counters {
+		for key, counter := range keyed {
+			counter.Prune(now)
+			if counter.Empty() {
+				delete(keyed, key)
+			}
+		}
+		if len(keyed) == 0 {
+			delete(counters, ip)
+		}
+	}
+}
+
+//! Copy persisted counters into an analysis, skipping any that are missing.
+/*
+ * @param     map         map[IP address][key] = counter, to copy into
+ * @param     map         map[IP address][key] = counter, as persisted
+ * @param     duration    window of the analysis
+ */
+func restoreCounters(counters map[string]map[string]*ndefenceStats.SlidingCounter,
+	persisted map[string]map[string]*ndefenceStats.SlidingCounter,
+	window time.Duration) {
+
+	for ip, keyed := range persisted {
+		for key, counter := range keyed {
+			if counter == nil {
+				delete(keyed, key)
+				continue
+			}
+			counter.Window = window
+		}
+		if len(keyed) > 0 {
+			counters[ip] = keyed
+		}
+	}
+}
+
+//! Describe the window ending at the given time, for use in log headers.
+/*
+ * @param     time.Time    end of the window
+ * @param     duration     length of the window
+ *
+ * @return    string       e.g. "16/Oct/2026 08:30 to 17/Oct/2026 08:30
+ *                         (last 24h)"
+ */
+func describeWindow(end time.Time, span time.Duration) string {
+
+	layout := "02/Jan/2006 15:04"
+	return end.Add(-span).Format(layout) + " to " + end.Format(layout) +
+		" (last " + ndefenceUtils.FormatDuration(span) + ")"
+}
+
+//! Describe the error counts of every IP address, one address per line,
+//! most errors first.
+/*
+ * @param     map       map[IP address][category] = count
+ *
+ * @return    string    e.g. "1.2.3.4         | 12 | not-found: 10, ..."
+ */
+func describeClientErrors(counts map[string]map[string]int) string {
+
+	// safety check, ensure there is something to describe
+	if len(counts) < 1 {
+		return "No client errors listed at this time."
+	}
+
+	ips := make([]string, 0, len(counts))
+	for ip := range counts {
+		ips = append(ips, ip)
+	}
+	sort.Slice(ips, func(i, j int) bool {
+		ti, tj := sumCounts(counts[ips[i]]), sumCounts(counts[ips[j]])
+		if ti != tj {
+			return ti > tj
+		}
+		return ips[i] < ips[j]
+	})
+
+	contents := ""
+	for _, ip := range ips {
+
+		spaceFormattedIPAddress, err := ndefenceUtils.SpaceFormatIP(ip)
+		if err != nil {
+			continue
+		}
+
+		contents += fmt.Sprintf("%s | %d | %s\n", spaceFormattedIPAddress,
+			sumCounts(counts[ip]), ndefenceUtils.FormatCounts(counts[ip]))
+	}
+
+	return contents
+}
+
+//! Sum the counts of a map.
+/*
+ * @param     map    map[key] = count
+ *
+ * @return    int    sum
+ */
+func sumCounts(counts map[string]int) int {
+
+	sum := 0
+	for _, count := range counts {
+		sum += count
+	}
+
+	return sum
+}
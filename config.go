@@ -0,0 +1,284 @@
+/*
+ * File: config.go
+ *
+ * Description: Binds the settings of ndefence to the config file, the
+ *              environment and the command line flags.
+ *
+ * Author: Robert Bisewski <contact@ibiscybernetics.com>
+ */
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rbisewski/ndefence/ndefenceConfig"
+	"github.com/rbisewski/ndefence/ndefenceHostname"
+	"github.com/rbisewski/ndefence/ndefenceUtils"
+)
+
+//
+// Globals
+//
+var (
+
+	// Location of the config file; empty for none
+	configPath = ""
+)
+
+// settings ... every setting of the config file, along with its flag, if
+// any, and the variable it is held in
+/*
+ * @return    Setting[]    settings
+ */
+func settings() []ndefenceConfig.Setting {
+	return []ndefenceConfig.Setting{
+		{Key: "server_type", Flag: "server-type", Target: &serverType},
+		{Key: "state_dir", Flag: "state-dir", Target: &stateDirectory},
+
+		{Key: "logs.directory", Target: &logDirectory},
+		{Key: "logs.access_log", Target: &accessLog},
+		{Key: "logs.error_log", Target: &errorLog},
+		{Key: "logs.sources", Flag: "sources", Target: &logSources},
+		{Key: "logs.format", Flag: "log-format", Target: &logFormat},
+		{Key: "logs.json_keys", Flag: "json-keys", Target: &jsonKeys},
+		{Key: "logs.lookback", Flag: "lookback", Target: &lookback},
+		{Key: "logs.max_line_length", Flag: "max-line-length",
+			Target: &maxLineLength},
+
+		{Key: "reports.directory", Target: &webLocation},
+		{Key: "reports.ip_log", Target: &ipLog},
+		{Key: "reports.whois_log", Target: &whoisLog},
+		{Key: "reports.redirect_log", Target: &redirectLog},
+		{Key: "reports.blocked_log", Target: &blockedLog},
+		{Key: "reports.errors_log", Target: &clientErrorLog},
+		{Key: "reports.window", Flag: "report-window",
+			Target: &reportWindow},
+
+		{Key: "blocking.window", Flag: "block-window",
+			Target: &blockWindow},
+		{Key: "blocking.request_threshold", Flag: "request-threshold",
+			Target: &requestThreshold},
+		{Key: "blocking.site_thresholds", Flag: "site-thresholds",
+			Target: &siteThresholds},
+		{Key: "blocking.error_threshold", Flag: "error-threshold",
+			Target: &errorThreshold},
+		{Key: "blocking.expiry", Flag: "block-expiry",
+			Target: &blockExpiry},
+		{Key: "blocking.score_half_life", Flag: "score-half-life",
+			Target: &scoreHalfLife},
+		{Key: "blocking.block_score", Flag: "block-score",
+			Target: &blockScore},
+		{Key: "blocking.escalate_score", Flag: "escalate-score",
+			Target: &escalateScore},
+		{Key: "blocking.escalate_expiry", Flag: "escalate-expiry",
+			Target: &escalateExpiry},
+		{Key: "blocking.permanent_score", Flag: "permanent-score",
+			Target: &permanentScore},
+		{Key: "blocking.blocked_config", Flag: "blocked-config",
+			Target: &defaultBlockedIPsConfigPath},
+		{Key: "blocking.site_config", Flag: "site-config",
+			Target: &defaultSiteConfigPath},
+
+		{Key: "countries.mode", Flag: "country-mode",
+			Target: &countryMode},
+		{Key: "countries.list", Flag: "countries", Target: &countries},
+		{Key: "countries.thresholds", Flag: "country-thresholds",
+			Target: &countryThresholds},
+
+		{Key: "agents.file", Flag: "agents-file", Target: &agentsFile},
+
+		{Key: "redirects.internal_hosts", Flag: "internal-hosts",
+			Target: &internalHosts},
+
+		{Key: "detectors", Target: &detectorSettings},
+		{Key: "rules", Target: &ruleSettings},
+
+		{Key: "proxies.trusted", Flag: "trusted-proxies",
+			Target: &trustedProxies},
+		{Key: "proxies.client_ip_field", Flag: "client-ip-field",
+			Target: &clientIPField},
+
+		{Key: "daemon.enabled", Flag: "daemon-mode", Target: &daemonMode},
+		{Key: "daemon.poll_interval", Flag: "poll-interval",
+			Target: &pollInterval},
+		{Key: "daemon.report_interval", Flag: "report-interval",
+			Target: &reportInterval},
+	}
+}
+
+// loadConfiguration ... layer the config file, then the environment, then
+// the flags given explicitly, over the defaults; afterwards validate the
+// result
+/*
+ * @return    error    error message naming the offending key, if any
+ */
+func loadConfiguration() error {
+
+	// the config file itself may also be given via the environment
+	if configPath == "" {
+		configPath = os.Getenv(ndefenceConfig.EnvPrefix + "CONFIG")
+	}
+
+	// read the config file, if any
+	var values map[string]ndefenceConfig.Value
+	if configPath != "" {
+		var err error
+		values, err = ndefenceConfig.ReadConfigFile(configPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	// note the flags given explicitly, since those take precedence
+	explicitFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicitFlags[f.Name] = true
+	})
+
+	err := ndefenceConfig.Apply(settings(), values, os.Environ(),
+		explicitFlags)
+	if err != nil {
+		return err
+	}
+
+	// the directories are used as prefixes, so ensure they end in a slash
+	if logDirectory != "" && !strings.HasSuffix(logDirectory, "/") {
+		logDirectory += "/"
+	}
+	if webLocation != "" && !strings.HasSuffix(webLocation, "/") {
+		webLocation += "/"
+	}
+
+	serverType = strings.ToLower(serverType)
+
+	if err := validateConfiguration(); err != nil {
+		return err
+	}
+
+	// assemble the country policy, normalizing the country codes
+	countryPolicy, err = ndefenceHostname.NewCountryPolicy(countryMode,
+		countries)
+	if err != nil {
+		return fmt.Errorf("loadConfiguration() --> countries.list: %s",
+			err)
+	}
+
+	err = countryPolicy.SetThresholds(countryThresholds)
+	if err != nil {
+		return fmt.Errorf("loadConfiguration() --> countries.thresholds: "+
+			"%s", err)
+	}
+
+	return nil
+}
+
+// validateConfiguration ... ensure every setting has a usable value
+/*
+ * @return    error    error message naming the offending key, if any
+ */
+func validateConfiguration() error {
+
+	// variable declaration
+	invalid := func(key string, format string, args ...interface{}) error {
+		return fmt.Errorf("validateConfiguration() --> "+key+": "+format,
+			args...)
+	}
+
+	if !ndefenceUtils.IsStringInArray(serverType, validServerTypes) {
+		return invalid("server_type", "must be one of %s, got: %s",
+			strings.Join(validServerTypes, ", "), serverType)
+	}
+
+	if logDirectory == "" {
+		return invalid("logs.directory", "must not be empty")
+	}
+	if webLocation == "" {
+		return invalid("reports.directory", "must not be empty")
+	}
+
+	// the file names are appended to the directories above
+	names := map[string]string{
+		"logs.access_log":      accessLog,
+		"logs.error_log":       errorLog,
+		"reports.ip_log":       ipLog,
+		"reports.whois_log":    whoisLog,
+		"reports.redirect_log": redirectLog,
+		"reports.blocked_log":  blockedLog,
+		"reports.errors_log":   clientErrorLog,
+	}
+	for key, name := range names {
+		if name == "" || strings.Contains(name, "/") {
+			return invalid(key, "must be a file name, got: %q", name)
+		}
+	}
+
+	if lookback < 0 {
+		return invalid("logs.lookback", "must not be negative")
+	}
+	if maxLineLength < 1 {
+		return invalid("logs.max_line_length", "must be at least 1")
+	}
+
+	if reportWindow < windowResolution {
+		return invalid("reports.window", "must be at least %s",
+			windowResolution)
+	}
+	if blockWindow < windowResolution {
+		return invalid("blocking.window", "must be at least %s",
+			windowResolution)
+	}
+
+	if requestThreshold < 0 {
+		return invalid("blocking.request_threshold", "must not be "+
+			"negative")
+	}
+	if errorThreshold < 0 {
+		return invalid("blocking.error_threshold", "must not be negative")
+	}
+	if blockExpiry < 0 {
+		return invalid("blocking.expiry", "must not be negative")
+	}
+	if scoreHalfLife < 0 {
+		return invalid("blocking.score_half_life", "must not be negative")
+	}
+	if blockScore <= 0 {
+		return invalid("blocking.block_score", "must be positive")
+	}
+	if escalateScore != 0 && escalateScore < blockScore {
+		return invalid("blocking.escalate_score", "must be 0 or at least "+
+			"the block score")
+	}
+	if escalateExpiry <= 0 {
+		return invalid("blocking.escalate_expiry", "must be positive")
+	}
+	if permanentScore != 0 && permanentScore < blockScore {
+		return invalid("blocking.permanent_score", "must be 0 or at "+
+			"least the block score")
+	}
+	mode := strings.ToLower(countryMode)
+	if mode != ndefenceHostname.CountryAllowlist &&
+		mode != ndefenceHostname.CountryDenylist {
+		return invalid("countries.mode", "must be %s or %s, got: %s",
+			ndefenceHostname.CountryAllowlist,
+			ndefenceHostname.CountryDenylist, countryMode)
+	}
+
+	if pollInterval <= 0 {
+		return invalid("daemon.poll_interval", "must be positive")
+	}
+	if reportInterval < 0 {
+		return invalid("daemon.report_interval", "must not be negative")
+	}
+
+	return nil
+}
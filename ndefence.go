@@ -19,13 +19,14 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/rbisewski/ndefence/ndefenceDetect"
 	"github.com/rbisewski/ndefence/ndefenceHostname"
 	"github.com/rbisewski/ndefence/ndefenceIO"
+	"github.com/rbisewski/ndefence/ndefenceLog"
 	"github.com/rbisewski/ndefence/ndefenceUtils"
 )
 
@@ -56,6 +57,9 @@ var (
 	// Name of the blocked log file on the webserver.
 	blockedLog = "blocked.log"
 
+	// Name of the client error log file on the webserver.
+	clientErrorLog = "errors.log"
+
 	// Parameter for the server type
 	serverType = ""
 
@@ -66,9 +70,6 @@ var (
 	defaultSiteConfigPath       = ""
 	defaultBlockedIPsConfigPath = ""
 
-	// Boolean to flag whether a given server is valid or not
-	serverIsValid = false
-
 	// Whether or not to print the current version of the program
 	printVersion = false
 
@@ -77,11 +78,101 @@ var (
 
 	// Argument for enabling daemon mode
 	daemonMode = false
+
+	// Custom nginx log_format or apache LogFormat of the access log
+	logFormat = ""
+
+	// JSON keys to read, for access logs written one JSON object per line
+	jsonKeys = ""
+
+	// How far back to read rotated access logs
+	lookback = 24 * time.Hour
+
+	// Lines longer than this, in bytes, are skipped
+	maxLineLength = ndefenceIO.DefaultMaxLineLength
+
+	// Directory to remember how far each log was read; empty to disable
+	stateDirectory = "/var/lib/ndefence/"
+
+	// How often daemon mode checks for new lines, and writes the logs
+	pollInterval   = time.Second
+	reportInterval = 10 * time.Second
+
+	// Span of time covered by the logs, and by the blocking decisions
+	reportWindow = 24 * time.Hour
+	blockWindow  = 24 * time.Hour
+
+	// Precision of the windows above
+	windowResolution = time.Minute
+
+	// Number of error.log events, within the block window, at which an
+	// address is blocked; zero to only report them
+	errorThreshold = 20
+
+	// Access logs to analyze, as site=path pairs; defaults to the
+	// access.log of the server
+	logSources = ""
+
+	// Number of requests to a site, within the block window, at which an
+	// address from outside the trusted countries is blocked, along with
+	// per-site overrides as site=count pairs
+	requestThreshold = 5
+	siteThresholds   = ""
+	siteThresholdMap = make(map[string]int)
+
+	// Addresses and networks of the trusted proxies, and the header or log
+	// variable holding the clients they forward requests for
+	trustedProxies = ""
+	clientIPField  = ""
+	proxies        *ndefenceLog.ProxyResolver
+
+	// Country policy; in allowlist mode the listed countries are never
+	// blocked for their request counts alone, in denylist mode only the
+	// listed countries are, along with per-country thresholds as
+	// country=count pairs
+	countryMode       = ndefenceHostname.CountryAllowlist
+	countries         = "US,CA,GB,FR,DE,NL"
+	countryThresholds = ""
+	countryPolicy     *ndefenceHostname.CountryPolicy
+
+	// File of further user agent patterns, ahead of the built-in ones, and
+	// the classifier using them
+	agentsFile = ""
+	agents     *ndefenceLog.AgentClassifier
+
+	// Hosts counted as the site itself when telling internal redirects
+	// from external ones, besides the host requested
+	internalHosts = ""
+
+	// Detectors deciding which addresses to block, along with the options
+	// of the detectors and the rules as given in the config file
+	detectorSettings = make(map[string]string)
+	ruleSettings     = make(map[string]string)
+	detectors        *ndefenceDetect.Engine
+
+	// How long an address stays in the blocked IP config; zero for ever
+	blockExpiry = 48 * time.Hour
+
+	// Threat scores of the addresses; the points of every finding halve
+	// over the half-life, and an address is blocked once they add up to
+	// the block score, for the escalated expiry once they add up to the
+	// escalate score, and for ever once they add up to the permanent score
+	scoreHalfLife  = ndefenceDetect.DefaultThreatHalfLife
+	blockScore     = ndefenceDetect.BlockScore
+	escalateScore  = ndefenceDetect.DefaultEscalateScore
+	escalateExpiry = ndefenceDetect.DefaultEscalateExpiry
+	permanentScore = ndefenceDetect.DefaultPermanentScore
 )
 
 // Initialize the argument input flags.
 func init() {
 
+	// Config file flag
+	flag.StringVar(&configPath, "config", "",
+		"Location of the config file; settings given there are "+
+			"overridden by NDEFENCE_* environment variables, which are "+
+			"overridden by flags.")
+
 	// Server type flag
 	flag.StringVar(&serverType, "server-type", "nginx",
 		"Currently active server; e.g. 'nginx' ")
@@ -90,6 +181,116 @@ func init() {
 	flag.BoolVar(&daemonMode, "daemon-mode", false,
 		"Whether or not to run this program as a background service.")
 
+	// Log format flag
+	flag.StringVar(&logFormat, "log-format", "",
+		"Custom log_format (nginx) or LogFormat (apache) of the access "+
+			"log; e.g. '$remote_addr [$time_local] \"$request\" $status' "+
+			"or 'json' for one JSON object per line")
+
+	// JSON keys flag
+	flag.StringVar(&jsonKeys, "json-keys", "",
+		"Keys of JSON access logs, as variable=key pairs; e.g. "+
+			"'remote_addr=client,time_iso8601=@timestamp'")
+
+	// Look-back window flag
+	flag.DurationVar(&lookback, "lookback", lookback,
+		"How far back to read rotated access logs; e.g. '48h' or '0' "+
+			"for no limit")
+
+	// Maximum line length flag
+	flag.IntVar(&maxLineLength, "max-line-length", maxLineLength,
+		"Lines of the access log longer than this, in bytes, are skipped.")
+
+	// State directory flag
+	flag.StringVar(&stateDirectory, "state-dir", stateDirectory,
+		"Directory to remember how far each log was read, so that every "+
+			"run only analyzes new lines; empty to re-read everything.")
+
+	// Poll and report interval flags
+	flag.DurationVar(&pollInterval, "poll-interval", pollInterval,
+		"How often daemon mode checks the access log for new lines.")
+	flag.DurationVar(&reportInterval, "report-interval", reportInterval,
+		"How often daemon mode rewrites the logs, unless a new address "+
+			"needs to be blocked right away.")
+
+	// Window flags
+	flag.DurationVar(&reportWindow, "report-window", reportWindow,
+		"Span of time, ending at the newest entry, covered by the logs; "+
+			"e.g. '15m', '1h' or '24h'")
+	flag.DurationVar(&blockWindow, "block-window", blockWindow,
+		"Span of time, ending at the newest entry, over which requests "+
+			"are counted when deciding to block an address.")
+
+	// Error threshold flag
+	flag.IntVar(&errorThreshold, "error-threshold", errorThreshold,
+		"Number of error log events caused by an address, within the "+
+			"block window, at which it is blocked; 0 to only report them.")
+
+	// Log source flag
+	flag.StringVar(&logSources, "sources", "",
+		"Access logs to analyze together, as site=path pairs where the "+
+			"path may be a glob; e.g. 'shop=/var/log/nginx/shop.access.log,"+
+			"/var/log/nginx/*.access.log' where untagged logs are named "+
+			"after the file.")
+
+	// Request threshold flags
+	flag.IntVar(&requestThreshold, "request-threshold", requestThreshold,
+		"Number of requests to a site, within the block window, at which "+
+			"an address from outside the trusted countries is blocked; 0 "+
+			"to disable.")
+	flag.StringVar(&siteThresholds, "site-thresholds", "",
+		"Per-site request thresholds, as site=count pairs; e.g. "+
+			"'shop=50,blog=10'")
+
+	// Trusted proxy flags
+	flag.StringVar(&trustedProxies, "trusted-proxies", "",
+		"Addresses and networks of the proxies in front of the server, "+
+			"whose forwarding headers are believed; e.g. "+
+			"'127.0.0.1,10.0.0.0/8,::1'")
+	flag.StringVar(&clientIPField, "client-ip-field", "",
+		"Header or log variable holding the clients forwarded by the "+
+			"trusted proxies; e.g. 'X-Real-IP' or '$http_cf_connecting_ip'; "+
+			"defaults to X-Forwarded-For")
+
+	// User agent flags
+	flag.StringVar(&agentsFile, "agents-file", "",
+		"File of further user agent patterns, as 'class pattern' lines; "+
+			"e.g. '/etc/ndefence/agents.txt'")
+
+	// Redirect flags
+	flag.StringVar(&internalHosts, "internal-hosts", "",
+		"Hosts counted as the site itself when telling internal redirects "+
+			"from external ones; e.g. 'example.org,www.example.org'")
+
+	// Blocking flags
+	flag.StringVar(&countryMode, "country-mode", countryMode,
+		"Country policy: 'allowlist' never blocks the listed countries "+
+			"for their request counts alone, 'denylist' only blocks those.")
+	flag.StringVar(&countries, "countries", countries,
+		"Countries listed by the country policy, as ISO 3166 codes.")
+	flag.StringVar(&countryThresholds, "country-thresholds", "",
+		"Per-country request thresholds; e.g. 'CN=2,RU=3'")
+	flag.DurationVar(&blockExpiry, "block-expiry", blockExpiry,
+		"How long an address stays in the blocked IP config; 0 for ever.")
+	flag.DurationVar(&scoreHalfLife, "score-half-life", scoreHalfLife,
+		"Span of time over which the points of a finding halve; 0 for "+
+			"no decay.")
+	flag.Float64Var(&blockScore, "block-score", blockScore,
+		"Threat score at which an address is blocked.")
+	flag.Float64Var(&escalateScore, "escalate-score", escalateScore,
+		"Threat score at which a block lasts for the escalated expiry; "+
+			"0 to disable.")
+	flag.DurationVar(&escalateExpiry, "escalate-expiry", escalateExpiry,
+		"How long an escalated block lasts.")
+	flag.Float64Var(&permanentScore, "permanent-score", permanentScore,
+		"Threat score at which an address is blocked for ever; 0 to "+
+			"disable.")
+	flag.StringVar(&defaultBlockedIPsConfigPath, "blocked-config", "",
+		"Location of the blocked IP config to update, e.g. "+
+			"'/etc/nginx/blocked_ips.conf'; empty to only write the logs.")
+	flag.StringVar(&defaultSiteConfigPath, "site-config", "",
+		"Location of the default site config.")
+
 	// Version mode flag
 	flag.BoolVar(&printVersion, "version", false,
 		"Print the current version of this program and exit.")
@@ -103,15 +304,6 @@ func main() {
 	// String variable to hold eventual output, as well error variable.
 	var err error
 
-	// Variables to hold the extracted IP addresses and to hold ip
-	// addresses to consider blocking, if enough data is gathered
-	var ipAddresses = make(map[string]int)
-	var blockedIPAddresses = []string{}
-
-	// Variable to hold a generic log header format
-	var logHeaderFmt = "Generated on: %s\n\nLog Data for %s\n" +
-		"-------------------------\n\n"
-
 	// Parse the flags, if any.
 	flag.Parse()
 
@@ -122,22 +314,13 @@ func main() {
 		os.Exit(0)
 	}
 
-	// Lower case the serverType variable value.
-	serverType = strings.ToLower(serverType)
+	// Layer the config file and environment beneath the flags, then
+	// ensure every setting is usable.
+	err = loadConfiguration()
 
-	// Print the usage message if not nginx or apache.
-	for _, t := range validServerTypes {
-
-		// check if a given server is valid or not
-		if t == serverType {
-			serverIsValid = true
-			break
-		}
-	}
-
-	// Print the usage message if the server is an unknown type.
-	if !serverIsValid {
-		flag.Usage()
+	// ensure no error occurred
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(1)
 	}
 
@@ -151,419 +334,854 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Assemble the access.log file location.
+	// Read the JSON key mapping, if any was given.
+	jsonKeyMap, err := ndefenceLog.ParseJSONKeys(jsonKeys)
+
+	// ensure no error occurred
+	if err != nil {
+		fmt.Println("logs.json_keys:", err)
+		os.Exit(1)
+	}
+
+	// Compile the log format, so that any line layout can be analyzed.
+	parser, err := ndefenceLog.NewParser(serverType, logFormat, jsonKeyMap)
+
+	// ensure no error occurred
+	if err != nil {
+		fmt.Println("logs.format:", err)
+		os.Exit(1)
+	}
+
+	// Determine the clients behind the trusted proxies, if any, rather
+	// than counting (and blocking) the proxies themselves.
+	proxies, err = ndefenceLog.NewProxyResolver(trustedProxies,
+		clientIPField)
+
+	// ensure no error occurred
+	if err != nil {
+		fmt.Println("proxies.trusted:", err)
+		os.Exit(1)
+	}
+	parser = proxies.Wrap(parser)
+
+	// Read the per-site request thresholds, if any were given.
+	siteThresholdMap, err = ndefenceIO.ParseSiteThresholds(siteThresholds)
+
+	// ensure no error occurred
+	if err != nil {
+		fmt.Println("blocking.site_thresholds:", err)
+		os.Exit(1)
+	}
+
+	// Compile the user agent patterns, so that the class of every client
+	// can be reported and matched by the rules.
+	agents, err = ndefenceLog.NewAgentClassifier(agentsFile)
+
+	// ensure no error occurred
+	if err != nil {
+		fmt.Println("agents.file:", err)
+		os.Exit(1)
+	}
+
+	// Assemble the detectors, which fall back on the general settings
+	// above.
+	detectors, err = ndefenceDetect.NewEngine(detectorSettings,
+		ruleSettings, ndefenceDetect.Defaults{
+			Window:           blockWindow,
+			Resolution:       windowResolution,
+			RequestThreshold: requestThreshold,
+			SiteThresholds:   siteThresholdMap,
+			Countries:        countryPolicy,
+			ErrorThreshold:   errorThreshold,
+		})
+
+	// ensure no error occurred
+	if err != nil {
+		fmt.Println("detectors:", err)
+		os.Exit(1)
+	}
+
+	// Assemble the access.log file location, which is the only log source
+	// unless others were given.
 	accessLogLocation := logDirectory + serverType + "/" + accessLog
+	sources := []ndefenceIO.LogSource{{Site: ndefenceIO.DefaultSiteName,
+		Path: accessLogLocation}}
+	if logSources != "" {
+		sources, err = ndefenceIO.ParseLogSources(logSources)
+	}
 
-	// main infinite loop...
-	for {
+	// ensure no error occurred
+	if err != nil {
+		fmt.Println("logs.sources:", err)
+		os.Exit(1)
+	}
 
-		// Attempt to break up the file into an array of strings a demarked by
-		// the newline character.
-		lines, err := ndefenceIO.TokenizeFile(accessLogLocation, "\n")
+	// Expand the globs of the log sources into the logs present.
+	sources, err = ndefenceIO.ExpandLogSources(sources)
 
-		// if an error occurred, print it out and terminate the program
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(0)
-		}
+	// ensure no error occurred, and that there is something to analyze
+	if err != nil {
+		fmt.Println("logs.sources:", err)
+		os.Exit(1)
+	}
+	if len(sources) < 1 {
+		fmt.Println("No access logs match the given sources: ",
+			logSources)
+		os.Exit(0)
+	}
 
-		// determine the last valid line
-		lastLineNum := len(lines) - 2
+	// Assemble the error.log file location.
+	errorLogLocation := logDirectory + serverType + "/" + errorLog
 
-		// safety check, ensure the value is at least zero
-		if lastLineNum < 0 {
-			lastLineNum = 0
-		}
+	// Analyze everything written to the logs since the last run.
+	results, accessStates, errorState := analyzeLogs(parser, sources,
+		errorLogLocation)
 
-		// obtain the contents of the last line
-		lastLine := lines[lastLineNum]
+	// if daemon mode is enabled, keep following the logs so that entries
+	// are analyzed within seconds of being written
+	if daemonMode {
+		followLogs(parser, results, sources, accessStates, errorState)
+	}
 
-		// extract the date of the last line, this is so that the program can
-		// gather data concerning only the latest entries
-		latestDateInLog, err := ndefenceIO.ObtainLatestDate(lastLine)
+	// If all is well, we can return quietly here.
+	os.Exit(0)
+}
 
-		// check if an error occurred
+// analyzeLogs ... analyze the part of the access logs and error.log, and
+// their rotated copies, not yet analyzed by a previous run, then write the
+// logs
+/*
+ * @param     Parser         parser of the access log lines
+ * @param     LogSource[]    access logs, tagged by site
+ * @param     string         /path/to/error.log
+ *
+ * @return    *analysis      gathered data
+ * @return    LogState[]     position of every access log once read
+ * @return    LogState       position of the error.log once read
+ */
+func analyzeLogs(parser ndefenceLog.Parser, sources []ndefenceIO.LogSource,
+	errorLogLocation string) (*analysis, []ndefenceIO.LogState,
+	ndefenceIO.LogState) {
+
+	// continue the windows of the previous run, if any
+	results := newAnalysis(analysisWindow(), detectors, agents,
+		ndefenceLog.NewRedirectClassifier(internalHosts),
+		ndefenceDetect.NewThreatScores(ndefenceDetect.ThreatPolicy{
+			HalfLife: scoreHalfLife, BlockScore: blockScore,
+			EscalateScore: escalateScore, PermanentScore: permanentScore,
+			EscalateExpiry: escalateExpiry}))
+	if stateDirectory != "" {
+		results.load(stateDirectory)
+	}
+
+	// variable declaration
+	accessStates := make([]ndefenceIO.LogState, len(sources))
+	statesRead := make([]ndefenceIO.LogState, 0, len(sources)+1)
+	accessLogLocations := make([]string, len(sources))
+	newEntries := 0
+
+	// stream every line of the access logs thru the parser, skipping the
+	// ones that do not appear to match the log format
+	for i, source := range sources {
+
+		site := source.Site
+		accessLogLocations[i] = source.Path
+		state, count, err := readLog(source.Path,
+			func(line string, isNew func(time.Time) bool) {
+
+				entry, err := parser.Parse(line)
+				if err != nil || !isNew(entry.Time) {
+					return
+				}
+
+				entry.Site = site
+				results.add(entry)
+			})
+
+		// if an error occurred, print it out and carry on with the
+		// remaining logs
 		if err != nil {
 			fmt.Println(err)
-			os.Exit(1)
+			continue
 		}
 
-		// attempt to grab the current day/month/year
-		datetime := time.Now().Format(time.UnixDate)
-
-		//
-		// safety check, ensure this actually got a meaningful string
-		//
-		// * should be at least len("DD/MM/YYYY"), so at least 10
-		//
-		if len(datetime) < 10 {
-			fmt.Println("Warning: Improper system date-time value" +
-				"detected!\n")
-			os.Exit(1)
-		}
+		accessStates[i] = state
+		statesRead = append(statesRead, state)
+		newEntries += count
+	}
 
-		// assemble the generic log header used by all of the logs
-		genericLogHeader := fmt.Sprintf(logHeaderFmt, datetime,
-			latestDateInLog)
+	// if none of the access logs could be read, there is nothing to do
+	if len(statesRead) < 1 {
+		os.Exit(0)
+	}
 
-		// set the title and append the header to the redirectLogContents
-		redirectLogContents := "Redirection Entry Data\n\n"
-		redirectLogContents += genericLogHeader
+	// likewise for the error.log, which is optional since not every
+	// server is configured to write one
+	errorState, newErrors, err := readLog(errorLogLocation,
+		func(line string, isNew func(time.Time) bool) {
 
-		// compile a regex to search for 302 found-redirections
-		redirectCapture := "\" 302 [0-9]{1,15} \"(.{2,64})\" "
-		redirectRegex := regexp.MustCompile(redirectCapture)
+			entry, err := parseErrorLine(line)
+			if err != nil || !isNew(entry.Time) {
+				return
+			}
+
+			results.addError(entry)
+		})
 
-		// turn the latest data string into a regex
-		re := regexp.MustCompile(latestDateInLog)
+	// if an error occurred, mention it and carry on without the error.log
+	if err != nil {
+		fmt.Println("Warning: unable to read the error log: ", err)
+	} else {
+		statesRead = append(statesRead, errorState)
+	}
 
-		// for every line...
-		linesAddedToRedirect := 0
-		for _, line := range lines {
+	// write the logs, unless there was nothing new to analyze
+	if newEntries < 1 && newErrors < 1 {
+		fmt.Println("No new entries were found in: ",
+			strings.Join(accessLogLocations, ", "))
+	} else {
+		writeReports(results)
+	}
 
-			// verify that a match could be found
-			verify := re.FindString(line)
+	// remember how far the logs were read; the reports are written by now,
+	// so if the state directory is not writable, e.g. when not run as root,
+	// merely mention it, since the next run then re-reads the logs
+	if err = saveState(results, statesRead); err != nil {
+		fmt.Println("Warning: unable to save the state: ", err)
+	}
 
-			// skip a line if the entry is not the latest date
-			if len(verify) < 1 {
-				continue
-			}
+	return results, accessStates, errorState
+}
 
-			// attempt to split that line via spaces
-			elements := strings.Split(line, " ")
+// readLog ... read the part of a log, and its rotated copies, not yet read
+// by a previous run; every line is passed to the given function, along with
+// a function that tells whether an entry of the given time is new
+/*
+ * @param     string      /path/to/log
+ * @param     func        function to call with every line
+ *
+ * @return    LogState    position of the log once read
+ * @return    int         number of new entries
+ * @return    error       error message, if any
+ */
+func readLog(location string,
+	handle func(line string, isNew func(time.Time) bool)) (
+	ndefenceIO.LogState, int, error) {
 
-			// safety check, ensure that element actually has a length
-			// of at least 1
-			if len(elements) < 1 {
-				continue
-			}
+	// Gather the log along with its rotated copies, so that the analysis
+	// still covers the whole period right after logrotate.
+	state := ndefenceIO.LogState{Path: location}
+	logFiles, err := ndefenceIO.ObtainRotatedLogFiles(location, lookback,
+		time.Now())
 
-			// grab the first element, that is the IP address
-			ip := elements[0]
+	// if an error occurred, pass it back
+	if err != nil {
+		return state, 0, err
+	}
 
-			// determine if this is a valid IPv4 address
-			if !ndefenceUtils.IsValidIPv4Address(ip) {
-				continue
-			}
+	// Determine which part of the logs was not analyzed by a previous
+	// run, so that entries are never counted twice.
+	// If the state is unreadable, e.g. when not run as root, the log is
+	// read as if for the first time.
+	if stateDirectory != "" {
+		previous, err := ndefenceIO.ReadLogState(stateDirectory, location)
+		if err != nil {
+			fmt.Println("Warning: unable to read the state: ", err)
+		} else {
+			state = previous
+		}
+	}
+	segments, resumed := ndefenceIO.PlanIncrementalRead(logFiles, state)
 
-			// since the ip address is valid, go ahead and add it to the
-			// global array of ip addresses.
-			ipAddresses[ip]++
+	// identify the log before reading it, since a rotation may happen at
+	// any time
+	inode, device, _, identityErr := ndefenceIO.ObtainFileIdentity(location)
+	readsLog := segments[len(segments)-1].Path == location
 
-			// check if the line contains the 302 pattern
-			redirectChunk := redirectRegex.FindString(line)
+	// entries older than the look-back window are not of interest
+	windowStart := time.Now().Add(-lookback)
+	latest := state.LastTimestamp
+	newEntries := 0
 
-			// skip a line if the entry is not the latest date
-			if len(redirectChunk) < 1 {
-				continue
-			}
+	isNew := func(t time.Time) bool {
 
-			// breakup the (potential) redirection section into pieces
-			redirectPieces := strings.Split(redirectChunk, " ")
+		if lookback > 0 && t.Before(windowStart) {
+			return false
+		}
 
-			// safety check, ensure that there are at least 4 pieces
-			if len(redirectPieces) < 4 {
-				continue
-			}
+		// after a rotation the previous position is lost, in which case
+		// anything already analyzed is skipped by time
+		if !resumed && !t.After(state.LastTimestamp) {
+			return false
+		}
 
-			// attempt to obtain the HTML response code
-			htmlCode := redirectPieces[1]
+		if t.After(latest) {
+			latest = t
+		}
+		newEntries++
+		return true
+	}
 
-			// if no value is present...
-			if len(htmlCode) < 1 {
+	skipped, offset, err := ndefenceIO.ReadLogSegments(segments,
+		maxLineLength, stateDirectory != "" || daemonMode,
+		func(line string) {
+			handle(line, isNew)
+		})
 
-				// ... skip to the next line
-				continue
-			}
+	// if an error occurred, pass it back
+	if err != nil {
+		return state, newEntries, err
+	}
 
-			// since the value *is* present, check if it is a '302'
-			// which refers to a `Found` redirect code
-			if htmlCode != "302" {
+	// mention any lines that were too long to be analyzed
+	if skipped > 0 {
+		fmt.Println("Warning: lines skipped for exceeding the " +
+			"maximum length in " + location + ": " + strconv.Itoa(skipped))
+	}
 
-				// ... else skip to the next line
-				continue
-			}
+	// remember how far the log was read
+	if identityErr == nil && readsLog {
+		state.Inode, state.Device, state.Offset = inode, device, offset
+	}
+	state.LastTimestamp = latest
 
-			// attempt to obtain the intended redirect location of choice
-			redirectLocation := redirectPieces[3]
+	return state, newEntries, nil
+}
 
-			// safety check, ensure the value is at least 1 character long
-			if len(redirectLocation) < 1 {
-				continue
-			}
+// followLogs ... follow the access logs and error.log as they are written
+// to, adding new entries to the analysis and rewriting the logs as needed;
+// newly blocked addresses cause the logs to be written right away, anything
+// else is written at most once per report interval
+/*
+ * @param     Parser         parser of the access log lines
+ * @param     *analysis      data gathered so far
+ * @param     LogSource[]    access logs, tagged by site
+ * @param     LogState[]     position of every access log
+ * @param     LogState       position of the error.log
+ */
+func followLogs(parser ndefenceLog.Parser, results *analysis,
+	sources []ndefenceIO.LogSource, accessStates []ndefenceIO.LogState,
+	errorState ndefenceIO.LogState) {
+
+	// variable declaration
+	accessFollowers := make([]*ndefenceIO.Follower, len(sources))
+	errorFollower := ndefenceIO.NewFollower(errorState.Path, errorState,
+		maxLineLength)
+	lastReport := time.Now()
+	pendingEntries := 0
+
+	for i, source := range sources {
+		accessStates[i].Path = source.Path
+		accessFollowers[i] = ndefenceIO.NewFollower(source.Path,
+			accessStates[i], maxLineLength)
+		defer accessFollowers[i].Close()
+	}
+	defer errorFollower.Close()
 
-			// attempt to trim it
-			redirectLocation = strings.Trim(redirectLocation, "\"")
+	for {
 
-			// safety check, ensure the value is at least 1 character long
-			if len(redirectLocation) < 1 {
-				continue
-			}
+		// wait a moment for new lines to be written
+		time.Sleep(pollInterval)
 
-			// since the \t character tends to get mangled easily, add a
-			// buffer of single-space characters instead to the IPv4
-			// addresses
-			spaceFormattedIPAddress, err :=
-				ndefenceUtils.SpaceFormatIPv4(ip)
+		// read whatever was written since the last poll
+		urgentBefore := results.urgentSeen
+		for i, follower := range accessFollowers {
+
+			state, site := &accessStates[i], sources[i].Site
+			err := follower.Poll(func(line string) {
 
-			// if an error occurs, skip to the next element
+				entry, err := parser.Parse(line)
+				if err != nil {
+					return
+				}
+
+				entry.Site = site
+				results.add(entry)
+				if entry.Time.After(state.LastTimestamp) {
+					state.LastTimestamp = entry.Time
+				}
+				pendingEntries++
+			})
+
+			// a temporary problem, e.g. mid-rotation, so try again later
 			if err != nil {
-				continue
+				fmt.Println(err)
 			}
+		}
 
-			// assemble all of the currently gathered info into a log line
-			assembledLineString := spaceFormattedIPAddress + " | " +
-				htmlCode + " | " + redirectLocation + "\n"
+		err := errorFollower.Poll(func(line string) {
 
-			// append it to the log contents of redirect entries
-			redirectLogContents += assembledLineString
+			entry, err := parseErrorLine(line)
+			if err != nil {
+				return
+			}
 
-			// finally, add the ip address to the list of IPv4 addresses to
-			// consider blocking eventually
-			if !ndefenceUtils.IsStringInArray(ip, blockedIPAddresses) {
-				blockedIPAddresses = append(blockedIPAddresses, ip)
+			results.addError(entry)
+			if entry.Time.After(errorState.LastTimestamp) {
+				errorState.LastTimestamp = entry.Time
 			}
+			pendingEntries++
+		})
+		if err != nil {
+			fmt.Println(err)
+		}
 
-			// increment the line counter
-			linesAddedToRedirect++
+		// nothing new, or nothing urgent within the report interval
+		if pendingEntries < 1 {
+			continue
+		}
+		if results.urgentSeen == urgentBefore &&
+			time.Since(lastReport) < reportInterval {
+			continue
 		}
 
-		// attempt to obtain the whois entries, as a string
-		whoisStrings, whoisSummaryMap, err :=
-			ndefenceHostname.ObtainWhoisEntries(ipAddresses)
+		writeReports(results)
+		lastReport = time.Now()
+		pendingEntries = 0
 
-		// if an error occurred, terminate the program
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+		// remember how far the logs were read, and discard anything that
+		// fell out of the windows
+		states := make([]ndefenceIO.LogState, 0, len(sources)+1)
+		for i, follower := range accessFollowers {
+			accessStates[i] = follower.State(accessStates[i])
+			states = append(states, accessStates[i])
 		}
+		errorState = errorFollower.State(errorState)
+		states = append(states, errorState)
 
-		// set the title of the whoisLogContents
-		whoisLogContents := "Whois Entry Data\n\n"
+		if err := saveState(results, states); err != nil {
+			fmt.Println("Warning: unable to save the state: ", err)
+		}
+	}
+}
 
-		// append the date to the whoisLogContents on the next line
-		whoisLogContents += genericLogHeader
+// parseErrorLine ... parse an error.log line, skipping the errors caused by
+// the trusted proxies themselves
+/*
+ * @param     string        line data
+ *
+ * @return    ErrorEntry    parsed entry
+ * @return    error         error message, if any
+ */
+func parseErrorLine(line string) (ndefenceLog.ErrorEntry, error) {
 
-		// append the whois entry strings to the whois log contents
-		whoisLogContents += whoisStrings
+	entry, err := ndefenceLog.ParseErrorLine(serverType, line)
+	if err != nil {
+		return entry, err
+	}
 
-		// attempt to stat() the whois.log file, else create it if it does
-		// not currently exist
-		err = ndefenceIO.StatOrCreateFile(webLocation + whoisLog)
+	if proxies.IsTrustedAddress(entry.ClientIP) {
+		return ndefenceLog.ErrorEntry{}, fmt.Errorf("parseErrorLine() "+
+			"--> error caused by a trusted proxy: %s", entry.ClientIP)
+	}
 
-		// if an error occurred during stat(), yet the program was unable
-		// to recover or recreate the file, then exit the program
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
+	return entry, nil
+}
 
-		// attempt to write the string contents to the ip.log file
-		err = ioutil.WriteFile(webLocation+whoisLog,
-			[]byte(whoisLogContents),
-			0644)
+// saveState ... remember how far every log was read, along with the data
+// gathered so far; without a state directory, the data that fell out of the
+// windows is simply discarded
+/*
+ * @param     *analysis     data gathered so far
+ * @param     LogState[]    position of every log
+ *
+ * @return    error         error message, if any
+ */
+func saveState(results *analysis, states []ndefenceIO.LogState) error {
 
-		// convert the ip addresses map into an array of strings
-		IPstrings, err := ndefenceHostname.ConvertIPAddressMapToString(
-			ipAddresses, whoisSummaryMap)
+	if stateDirectory == "" {
+		results.prune()
+		return nil
+	}
 
-		// if an error occurred, terminate from the program
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+	// the windows are pruned regardless, so that a daemon unable to save
+	// its state does not hold on to ever more data
+	for _, state := range states {
+		if err := ndefenceIO.WriteLogState(stateDirectory, state); err != nil {
+			results.prune()
+			return err
 		}
+	}
 
-		// attempt to stat() the ip.log file, else create it if it does
-		// not currently exist
-		err = ndefenceIO.StatOrCreateFile(webLocation + ipLog)
+	return results.save(stateDirectory)
+}
 
-		// if an error occurred during stat(), yet the program was unable
-		// to recover or recreate the file, then exit the program
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
+// writeReports ... write the ip, whois, redirect and blocked logs, then
+// update the blocked IP config, using the data gathered by an analysis
+/*
+ * @param     *analysis    gathered data
+ */
+func writeReports(results *analysis) {
 
-		// set the title to the IPLogContents
-		IPLogContents := "IP Address Counts Data\n\n"
+	// String variable to hold eventual output, as well error variable.
+	var err error
 
-		// append the generic log header to the ip.log file
-		IPLogContents += genericLogHeader
+	// Variable to hold a generic log header format
+	var logHeaderFmt = "Generated on: %s\n\nLog Data for %s\n" +
+		"-------------------------\n\n"
 
-		// append the IPstrings content to this point of the log; it will
-		// either contain the "IPv4 Address + Daily Count" or a message
-		// stating that no addresses appear to be recorded today.
-		IPLogContents += IPstrings
+	// attempt to grab the current day/month/year
+	datetime := time.Now().Format(time.UnixDate)
+
+	//
+	// safety check, ensure this actually got a meaningful string
+	//
+	// * should be at least len("DD/MM/YYYY"), so at least 10
+	//
+	if len(datetime) < 10 {
+		fmt.Println("Warning: Improper system date-time value " +
+			"detected!")
+		os.Exit(1)
+	}
 
-		// attempt to write the string contents to the ip.log file
-		err = ioutil.WriteFile(webLocation+ipLog,
-			[]byte(IPLogContents),
-			0644)
+	// assemble the generic log header used by all of the logs
+	genericLogHeader := fmt.Sprintf(logHeaderFmt, datetime,
+		describeWindow(results.latestTime, reportWindow))
 
-		// if an error occurred, terminate the program
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
+	// set the title and append the header to the redirectLogContents
+	redirectLogContents := "Redirection Entry Data\n\n"
+	redirectLogContents += genericLogHeader
 
-		// if no entries were added to the redirect.log, then add a short
-		// message noting that there were no addresses at this time
-		if linesAddedToRedirect < 1 {
-			redirectLogContents += "No redirections listed at this time."
-		}
+	// gather the request counts of the report window
+	ipAddresses := results.ipCounts(reportWindow)
 
-		// attempt to stat() the ip.log file, else create it if it does
-		// not currently exist
-		err = ndefenceIO.StatOrCreateFile(webLocation + redirectLog)
+	// for every redirect within the report window...
+	linesAddedToRedirect := 0
+	for _, redirect := range results.redirectsWithin(reportWindow) {
 
-		// if an error occurred during stat(), yet the program was unable
-		// to recover or recreate the file, then exit the program
+		// since the \t character tends to get mangled easily, add a
+		// buffer of single-space characters instead to the IP addresses
+		spaceFormattedIPAddress, err :=
+			ndefenceUtils.SpaceFormatIP(redirect.IP)
+
+		// if an error occurs, skip to the next element
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			continue
 		}
 
-		// having gotten this far, attempt to write the redirect data
-		// contents to the log file
-		err = ioutil.WriteFile(webLocation+redirectLog,
-			[]byte(redirectLogContents),
-			0644)
+		// assemble all of the currently gathered info into a log line,
+		// then append it to the log contents of redirect entries
+		redirectLogContents += spaceFormattedIPAddress + " | " +
+			redirect.Site + " | " + strconv.Itoa(redirect.Status) + " | " +
+			redirect.Kind + " | " + redirect.Location + "\n"
+		linesAddedToRedirect++
+	}
+
+	// attempt to obtain the whois entries, as a string, along with the
+	// country and autonomous system of every address; new error.log lines
+	// alone may leave the report window without any requests, in which
+	// case there is no one to look up
+	whoisStrings := ""
+	whoisSummaryMap := make(map[string]string)
+	whoisASNMap := make(map[string]string)
+	if len(ipAddresses) > 0 {
+		whoisStrings, whoisSummaryMap, whoisASNMap, err =
+			ndefenceHostname.ObtainWhoisEntries(ipAddresses)
 
-		// if an error occurs, terminate from the program
+		// if an error occurred, terminate the program
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
+	}
 
-		// cycle thru all of the ip address counts...
-		for ip, count := range ipAddresses {
+	// set the title of the whoisLogContents
+	whoisLogContents := "Whois Entry Data\n\n"
 
-			// obtain the country code of this IP address
-			givenCountryCode := whoisSummaryMap[ip]
+	// append the date to the whoisLogContents on the next line
+	whoisLogContents += genericLogHeader
 
-			// safety check, ensure the result is not nil
-			if len(givenCountryCode) != 2 || givenCountryCode == ".." {
-				continue
-			}
+	// append the whois entry strings to the whois log contents
+	whoisLogContents += whoisStrings
 
-			// skip if the country is one of the following:
-			//
-			// * US --> United States
-			// * CA --> Canada
-			// * UK --> United Kingdom
-			// * FR --> France
-			// * DE --> Germany
-			// * NL --> Netherlands
-			//
-			if givenCountryCode == "US" || givenCountryCode == "CA" ||
-				givenCountryCode == "UK" || givenCountryCode == "FR" ||
-				givenCountryCode == "DE" || givenCountryCode == "NL" {
-				continue
-			}
+	// attempt to stat() the whois.log file, else create it if it does
+	// not currently exist
+	err = ndefenceIO.StatOrCreateFile(webLocation + whoisLog)
 
-			// skip to the next if count is less than 5
-			if count < 5 {
-				continue
-			}
+	// if an error occurred during stat(), yet the program was unable
+	// to recover or recreate the file, then exit the program
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
-			// go ahead an append to the list of blocked ips
-			blockedIPAddresses = append(blockedIPAddresses, ip)
+	// attempt to write the string contents to the ip.log file
+	err = ioutil.WriteFile(webLocation+whoisLog,
+		[]byte(whoisLogContents),
+		0644)
+
+	// the detectors may count further back than the report window, e.g.
+	// when the block window is the longer one, so the addresses they
+	// still count are looked up as well, but left out of the whois.log
+	extraAddresses := make(map[string]int)
+	for ip := range results.detectors.Addresses(results.latestTime) {
+		if _, ok := ipAddresses[ip]; !ok {
+			extraAddresses[ip] = 1
 		}
+	}
+	if len(extraAddresses) > 0 {
 
-		// attempt to stat() the blocked.log file, else create it if it does
-		// not currently exist
-		err = ndefenceIO.StatOrCreateFile(webLocation + blockedLog)
+		_, extraCountries, extraASNs, err :=
+			ndefenceHostname.ObtainWhoisEntries(extraAddresses)
 
-		// if an error occurred during stat(), yet the program was unable
-		// to recover or recreate the file, then exit the program
+		// if an error occurred, carry on without those addresses
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			fmt.Println("Warning: unable to obtain the whois entries: ",
+				err)
 		}
-
-		// if no entries were added to the blocked.log, then add a short
-		// message noting that there were no addresses at this time
-		blockedLogContents := ""
-		if len(blockedIPAddresses) < 1 {
-			blockedLogContents = "# No IPs blocked at this time."
-		} else {
-			// else print the IPs that would have been blocked
-			// append all of the blocked IPs together, newline separated
-			for _, ip := range blockedIPAddresses {
-				blockedLogContents += ip + "\n"
-			}
+		for ip, country := range extraCountries {
+			whoisSummaryMap[ip] = country
 		}
+		for ip, system := range extraASNs {
+			whoisASNMap[ip] = system
+		}
+	}
 
-		// having gotten this far, attempt to write the blocked data
-		// contents to the log file
-		err = ioutil.WriteFile(webLocation+blockedLog,
-			[]byte(blockedLogContents),
-			0644)
+	// the detectors may have notes on some of the addresses, e.g. the
+	// paths that failed the most for a scanner
+	detectorContext := ndefenceDetect.Context{Now: results.latestTime,
+		Countries: whoisSummaryMap, ASNs: whoisASNMap}
 
-		// if an error occurs, terminate from the program
+	// convert the ip addresses map into an array of strings
+	IPstrings := "No requests were recorded within the window.\n"
+	if len(ipAddresses) > 0 {
+		IPstrings, err = ndefenceHostname.ConvertIPAddressMapToString(
+			ipAddresses, whoisSummaryMap, results.agentCounts(reportWindow),
+			results.siteCounts(reportWindow),
+			results.detectors.Annotate(detectorContext))
+
+		// if an error occurred, terminate from the program
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
+	}
 
-		// read the current list of blocked IP addresses
-		currentlyBlockedIPs, err :=
-			ndefenceUtils.ReadBlockedIPConfig(
-				defaultBlockedIPsConfigPath, serverType,
-				datetime)
+	// attempt to stat() the ip.log file, else create it if it does
+	// not currently exist
+	err = ndefenceIO.StatOrCreateFile(webLocation + ipLog)
 
-		// if an error occurs, terminate from the program
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
+	// if an error occurred during stat(), yet the program was unable
+	// to recover or recreate the file, then exit the program
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// set the title to the IPLogContents
+	IPLogContents := "IP Address Counts Data\n\n"
 
-		// merge both sets of blocked IP addresses
-		for _, ip := range blockedIPAddresses {
-			timestamp, err := strconv.ParseInt(datetime, 10, 64)
+	// append the generic log header to the ip.log file
+	IPLogContents += genericLogHeader
+
+	// append the IPstrings content to this point of the log; it will
+	// either contain the "IP Address + Daily Count" or a message
+	// stating that no addresses appear to be recorded today.
+	IPLogContents += IPstrings
+
+	// attempt to write the string contents to the ip.log file
+	err = ioutil.WriteFile(webLocation+ipLog,
+		[]byte(IPLogContents),
+		0644)
+
+	// if an error occurred, terminate the program
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// if no entries were added to the redirect.log, then add a short
+	// message noting that there were no addresses at this time
+	if linesAddedToRedirect < 1 {
+		redirectLogContents += "No redirections listed at this time."
+	}
+
+	// attempt to stat() the ip.log file, else create it if it does
+	// not currently exist
+	err = ndefenceIO.StatOrCreateFile(webLocation + redirectLog)
+
+	// if an error occurred during stat(), yet the program was unable
+	// to recover or recreate the file, then exit the program
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// having gotten this far, attempt to write the redirect data
+	// contents to the log file
+	err = ioutil.WriteFile(webLocation+redirectLog,
+		[]byte(redirectLogContents),
+		0644)
+
+	// if an error occurs, terminate from the program
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// list the addresses that caused errors, e.g. probing for files that
+	// do not exist, even if they never caused a redirect
+	clientErrorLogContents := "Client Error Data\n\n" + genericLogHeader
+	clientErrorLogContents += describeClientErrors(
+		results.errorCounts(reportWindow))
+
+	// attempt to stat() the errors.log file, else create it if it does
+	// not currently exist
+	err = ndefenceIO.StatOrCreateFile(webLocation + clientErrorLog)
+
+	// if an error occurred during stat(), yet the program was unable
+	// to recover or recreate the file, then exit the program
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// attempt to write the client error data to the log file
+	err = ioutil.WriteFile(webLocation+clientErrorLog,
+		[]byte(clientErrorLogContents),
+		0644)
+
+	// if an error occurs, terminate from the program
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// ask the detectors which addresses to block, now that the country
+	// of every address is known
+	findings := results.detectors.Evaluate(detectorContext)
+	blocks := results.threats.Blocked(findings, results.latestTime,
+		blockExpiry)
+
+	// attempt to stat() the blocked.log file, else create it if it does
+	// not currently exist
+	err = ndefenceIO.StatOrCreateFile(webLocation + blockedLog)
+
+	// if an error occurred during stat(), yet the program was unable
+	// to recover or recreate the file, then exit the program
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// if no entries were added to the blocked.log, then add a short
+	// message noting that there were no addresses at this time
+	blockedLogContents := ""
+	if len(blocks) < 1 {
+		blockedLogContents = "# No IPs blocked at this time."
+	} else {
+		// else print the IPs that would have been blocked, along with
+		// their threat score and the rules that blocked them, newline
+		// separated
+		for _, block := range blocks {
+
+			spaceFormattedIPAddress, err :=
+				ndefenceUtils.SpaceFormatIP(block.IP)
 			if err != nil {
-				timestamp = 0
+				spaceFormattedIPAddress = block.IP
 			}
-			currentlyBlockedIPs[ip] = int(timestamp)
+
+			reasons := block.Describe()
+			if len(block.Findings) < 1 {
+				reasons = "earlier findings only"
+			}
+
+			blockedLogContents += spaceFormattedIPAddress + " | " +
+				block.Level + " | " + block.Breakdown() + " | " +
+				reasons + "\n"
 		}
+	}
 
-		//
-		// If a config is specified, attempt to generate a new one
-		//
-		err = ndefenceUtils.GenerateBlockedCfg(
-			defaultBlockedIPsConfigPath,
-			serverType,
-			currentlyBlockedIPs,
-			datetime)
+	// having gotten this far, attempt to write the blocked data
+	// contents to the log file
+	err = ioutil.WriteFile(webLocation+blockedLog,
+		[]byte(blockedLogContents),
+		0644)
 
-		// if an error occurs, terminate from the program
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+	// if an error occurs, terminate from the program
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// without a blocked IP config there is nothing left to update
+	if defaultBlockedIPsConfigPath == "" {
+		return
+	}
+
+	// the blocked IP config records when every address was blocked, in
+	// seconds since the epoch
+	timestamp := time.Now().Unix()
+	timestampStr := strconv.FormatInt(timestamp, 10)
+
+	// read the current list of blocked IP addresses
+	currentlyBlockedIPs, err :=
+		ndefenceUtils.ReadBlockedIPConfig(
+			defaultBlockedIPsConfigPath, serverType,
+			timestampStr, blockExpiry)
+
+	// if an error occurs, terminate from the program
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// merge both sets of blocked IP addresses, keeping the permanent
+	// entries as they are; blocks for a time other than the general
+	// expiry note when they end, without ever ending a block sooner
+	for _, block := range blocks {
+
+		current, ok := currentlyBlockedIPs[block.IP]
+		if ok && current.Since == -1 {
+			continue
 		}
 
-		// if daemon mode is disabled, then exit this loop
-		if !daemonMode {
-			break
+		// addresses whose score reached the permanent score stay blocked
+		// for ever
+		if block.Level == ndefenceDetect.LevelPermanent {
+			currentlyBlockedIPs[block.IP] = ndefenceUtils.BlockedIP{
+				Since: -1}
+			continue
 		}
 
-		// take the current time and increment 12 hours
-		currentTime := time.Now()
-		twelveHoursLater := currentTime.Add(time.Duration(12) * time.Hour)
+		entry := ndefenceUtils.BlockedIP{Since: timestamp}
+		if block.Duration != blockExpiry && block.Duration > 0 {
+			entry.Until = timestamp + int64(block.Duration.Seconds())
+		}
+		if ok && block.Duration > 0 && current.Until > timestamp+
+			int64(block.Duration.Seconds()) {
+			entry.Until = current.Until
+		}
+
+		currentlyBlockedIPs[block.IP] = entry
+	}
 
-		// since the user has selected daemon mode, wait 12 hours
-		for {
+	//
+	// If a config is specified, attempt to generate a new one
+	//
+	err = ndefenceUtils.GenerateBlockedCfg(
+		defaultBlockedIPsConfigPath,
+		serverType,
+		currentlyBlockedIPs,
+		timestampStr,
+		blockExpiry)
+
+	// if an error occurs, terminate from the program
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
 
-			// grab the current time
-			currentTime = time.Now()
+// analysisWindow ... the longest span of time any of the windows cover
+/*
+ * @return    duration    span of time
+ */
+func analysisWindow() time.Duration {
 
-			// if 12 hours have passed, go ahead and break
-			if currentTime.After(twelveHoursLater) {
-				break
-			}
-		}
+	if blockWindow > reportWindow {
+		return blockWindow
 	}
 
-	// If all is well, we can return quietly here.
-	os.Exit(0)
+	return reportWindow
 }
@@ -0,0 +1,345 @@
+//
+// Configuration functions for ndefence
+//
+
+package ndefenceConfig
+
+//
+// Imports
+//
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+// Globals
+//
+var (
+
+	// Prefix of the environment variables that override the config file
+	EnvPrefix = "NDEFENCE_"
+)
+
+//
+// Setting object definition
+//
+type Setting struct {
+
+	// Dotted key of the setting in the config file, e.g. "blocking.window"
+	Key string
+
+	// Name of the command line flag of the setting, if any
+	Flag string
+
+	// Variable holding the setting; a *string, *int, *float64, *bool or
+	// *time.Duration, or a *map[string]string holding a whole table keyed
+	// by the rest of the dotted key
+	Target interface{}
+}
+
+// EnvName ... name of the environment variable that overrides a setting,
+// e.g. blocking.window --> NDEFENCE_BLOCKING_WINDOW
+/*
+ * @param     string    dotted key
+ *
+ * @return    string    environment variable name
+ */
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-",
+		"_").Replace(key))
+}
+
+// ReadConfigFile ... read and parse a config file
+/*
+ * @param     string    /path/to/ndefence.toml
+ *
+ * @return    map       map[dotted key] = value
+ * @return    error     error message, if any
+ */
+func ReadConfigFile(path string) (map[string]Value, error) {
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ReadConfigFile() --> unable to read the "+
+			"config file: %s", path)
+	}
+
+	values, err := ParseTOML(string(contents))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return values, nil
+}
+
+// Apply ... set every setting from, in increasing order of precedence, the
+// config file values, then the environment; settings whose flag was given
+// explicitly on the command line are left alone, since flags take
+// precedence over both
+/*
+ * @param     Setting[]    settings
+ * @param     map          map[dotted key] = value, from the config file;
+ *                         may be nil
+ * @param     string[]     environment, as per os.Environ()
+ * @param     map          map[flag name] = whether it was given explicitly
+ *
+ * @return    error        error message naming the offending key, if any
+ */
+func Apply(settings []Setting, values map[string]Value, environ []string,
+	explicitFlags map[string]bool) error {
+
+	// variable declaration
+	known := make(map[string]bool)
+	env := make(map[string]string)
+
+	for _, variable := range environ {
+		if pair := strings.SplitN(variable, "=", 2); len(pair) == 2 {
+			env[pair[0]] = pair[1]
+		}
+	}
+
+	for _, setting := range settings {
+
+		known[setting.Key] = true
+
+		// tables, e.g. [blocking.site_thresholds], belong to the setting
+		// named after them
+		tableKeys := make([]string, 0)
+		for key := range values {
+			if strings.HasPrefix(key, setting.Key+".") {
+				tableKeys = append(tableKeys, key)
+				known[key] = true
+			}
+		}
+
+		if setting.Flag != "" && explicitFlags[setting.Flag] {
+			continue
+		}
+
+		if value, ok := values[setting.Key]; ok {
+			if err := setFromValue(setting, value); err != nil {
+				return err
+			}
+		} else if len(tableKeys) > 0 {
+			if err := setFromTable(setting, values, tableKeys); err != nil {
+				return err
+			}
+		}
+
+		name := EnvName(setting.Key)
+		if text, ok := env[name]; ok {
+			if err := setFromString(setting.Target, text); err != nil {
+				return fmt.Errorf("Apply() --> environment variable %s "+
+					"(%s): %s", name, setting.Key, err)
+			}
+		}
+	}
+
+	// catch typos, rather than silently ignoring them
+	unknown := make([]string, 0)
+	for key := range values {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("Apply() --> unknown config key %s (line %d)",
+			unknown[0], values[unknown[0]].Line)
+	}
+
+	return nil
+}
+
+//! Set a setting from a config file value.
+/*
+ * @param     Setting    setting
+ * @param     Value      value
+ *
+ * @return    error      error message naming the key, if any
+ */
+func setFromValue(setting Setting, value Value) error {
+
+	// variable declaration
+	var err error
+
+	switch target := setting.Target.(type) {
+
+	case *string:
+		switch data := value.Data.(type) {
+		case string:
+			*target = data
+		case []interface{}:
+			// lists are held as comma separated strings, the same way
+			// they are given on the command line
+			items := make([]string, 0, len(data))
+			for _, item := range data {
+				text, ok := item.(string)
+				if !ok {
+					err = fmt.Errorf("expected a list of strings")
+					break
+				}
+				items = append(items, text)
+			}
+			*target = strings.Join(items, ",")
+		default:
+			err = fmt.Errorf("expected a string or a list of strings")
+		}
+
+	case *int:
+		data, ok := value.Data.(int64)
+		if !ok {
+			err = fmt.Errorf("expected an integer")
+		}
+		*target = int(data)
+
+	case *float64:
+		switch data := value.Data.(type) {
+		case float64:
+			*target = data
+		case int64:
+			*target = float64(data)
+		default:
+			err = fmt.Errorf("expected a number")
+		}
+
+	case *bool:
+		data, ok := value.Data.(bool)
+		if !ok {
+			err = fmt.Errorf("expected true or false")
+		}
+		*target = data
+
+	case *time.Duration:
+		data, ok := value.Data.(string)
+		if !ok {
+			err = fmt.Errorf("expected a duration, e.g. \"24h\"")
+			break
+		}
+		err = setFromString(target, data)
+
+	case *map[string]string:
+		err = fmt.Errorf("expected a table")
+
+	default:
+		err = fmt.Errorf("unsupported setting type")
+	}
+
+	if err != nil {
+		return fmt.Errorf("Apply() --> config key %s (line %d): %s",
+			value.Key, value.Line, err)
+	}
+
+	return nil
+}
+
+//! Set a string setting from a table of values, e.g. a table of site
+//! thresholds becomes "blog=10,shop=50".
+/*
+ * @param     Setting     setting
+ * @param     map         map[dotted key] = value
+ * @param     string[]    keys of the table
+ *
+ * @return    error       error message naming the key, if any
+ */
+func setFromTable(setting Setting, values map[string]Value,
+	keys []string) error {
+
+	// a whole table, e.g. [detectors.redirect], keeps every key
+	if table, ok := setting.Target.(*map[string]string); ok {
+		*table = make(map[string]string)
+		for _, key := range keys {
+			(*table)[strings.TrimPrefix(key, setting.Key+".")] =
+				values[key].Text()
+		}
+		return nil
+	}
+
+	target, ok := setting.Target.(*string)
+	if !ok {
+		value := values[keys[0]]
+		return fmt.Errorf("Apply() --> config key %s (line %d): expected "+
+			"a single value, not a table", setting.Key, value.Line)
+	}
+
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+
+		value := values[key]
+		text := ""
+		switch data := value.Data.(type) {
+		case string:
+			text = data
+		case int64:
+			text = strconv.FormatInt(data, 10)
+		default:
+			return fmt.Errorf("Apply() --> config key %s (line %d): "+
+				"expected a string or an integer", key, value.Line)
+		}
+
+		pairs = append(pairs, strings.TrimPrefix(key, setting.Key+".")+
+			"="+text)
+	}
+
+	*target = strings.Join(pairs, ",")
+	return nil
+}
+
+//! Set a setting from text, as given in the environment.
+/*
+ * @param     interface{}    variable holding the setting
+ * @param     string         text
+ *
+ * @return    error          error message, if any
+ */
+func setFromString(target interface{}, text string) error {
+
+	switch target := target.(type) {
+
+	case *string:
+		*target = text
+
+	case *int:
+		value, err := strconv.Atoi(strings.TrimSpace(text))
+		if err != nil {
+			return fmt.Errorf("expected an integer, got: %s", text)
+		}
+		*target = value
+
+	case *float64:
+		value, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+		if err != nil {
+			return fmt.Errorf("expected a number, got: %s", text)
+		}
+		*target = value
+
+	case *bool:
+		value, err := strconv.ParseBool(strings.TrimSpace(text))
+		if err != nil {
+			return fmt.Errorf("expected true or false, got: %s", text)
+		}
+		*target = value
+
+	case *time.Duration:
+		value, err := time.ParseDuration(strings.TrimSpace(text))
+		if err != nil {
+			return fmt.Errorf("expected a duration, e.g. \"24h\", got: %s",
+				text)
+		}
+		*target = value
+
+	case *map[string]string:
+		return fmt.Errorf("tables can only be set in the config file")
+
+	default:
+		return fmt.Errorf("unsupported setting type")
+	}
+
+	return nil
+}
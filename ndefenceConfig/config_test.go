@@ -0,0 +1,143 @@
+//
+// Tests of the settings of ndefence
+//
+
+package ndefenceConfig
+
+//
+// Imports
+//
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestApply ... the config file is overridden by the environment, which is
+// in turn overridden by the flags given explicitly
+func TestApply(t *testing.T) {
+
+	// variable declaration
+	serverType := "nginx"
+	lookback := 0
+	window := 24 * time.Hour
+	expiry := 24 * time.Hour
+	score := 1.0
+	daemon := false
+	thresholds := map[string]string{}
+
+	settings := []Setting{
+		{Key: "server_type", Flag: "server-type", Target: &serverType},
+		{Key: "logs.lookback", Flag: "lookback", Target: &lookback},
+		{Key: "blocking.window", Flag: "block-window", Target: &window},
+		{Key: "blocking.expiry", Flag: "block-expiry", Target: &expiry},
+		{Key: "blocking.block_score", Target: &score},
+		{Key: "blocking.site_thresholds", Target: &thresholds},
+		{Key: "daemon.enabled", Flag: "daemon-mode", Target: &daemon},
+	}
+
+	values, err := ParseTOML(`
+server_type = "apache"
+
+[logs]
+lookback = 7
+
+[blocking]
+window = "12h"
+expiry = "48h"
+block_score = 2.5
+
+[blocking.site_thresholds]
+shop = 5
+blog = 10
+
+[daemon]
+enabled = true
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// the flag parser has already set the flags given explicitly
+	lookback = 3
+	environ := []string{"NDEFENCE_BLOCKING_WINDOW=1h",
+		"NDEFENCE_LOGS_LOOKBACK=5", "NDEFENCE_DAEMON_ENABLED=false",
+		"UNRELATED=1"}
+
+	err = Apply(settings, values, environ, map[string]bool{"lookback": true})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// from the config file
+	if serverType != "apache" {
+		t.Errorf("server_type: expected apache, got: %s", serverType)
+	}
+	if expiry != 48*time.Hour {
+		t.Errorf("blocking.expiry: expected 48h, got: %s", expiry)
+	}
+	if score != 2.5 {
+		t.Errorf("blocking.block_score: expected 2.5, got: %v", score)
+	}
+	expected := map[string]string{"shop": "5", "blog": "10"}
+	if !reflect.DeepEqual(thresholds, expected) {
+		t.Errorf("blocking.site_thresholds: expected %v, got: %v",
+			expected, thresholds)
+	}
+
+	// from the environment, over the config file
+	if window != time.Hour {
+		t.Errorf("blocking.window: expected 1h, got: %s", window)
+	}
+	if daemon {
+		t.Errorf("daemon.enabled: expected false, got: true")
+	}
+
+	// from the flag, over both the environment and the config file
+	if lookback != 3 {
+		t.Errorf("logs.lookback: expected 3, got: %d", lookback)
+	}
+}
+
+// TestApplyRejected ... typos and values of the wrong type are refused,
+// naming the offending key
+func TestApplyRejected(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		contents string
+		environ  []string
+		message  string
+	}{
+		{"unknown key", "[blocking]\nwindw = \"1h\"",
+			nil, "unknown config key blocking.windw (line 2)"},
+		{"wrong type in the file", "[blocking]\nwindow = true",
+			nil, "blocking.window"},
+		{"wrong type in the environment", "",
+			[]string{"NDEFENCE_BLOCKING_WINDOW=soon"},
+			"NDEFENCE_BLOCKING_WINDOW"},
+	}
+
+	for _, test := range tests {
+
+		window := time.Hour
+		settings := []Setting{{Key: "blocking.window", Target: &window}}
+
+		values, err := ParseTOML(test.contents)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+
+		err = Apply(settings, values, test.environ, nil)
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%s: expected an error containing %q, got: %s",
+				test.name, test.message, err)
+		}
+	}
+}
@@ -0,0 +1,514 @@
+//
+// Parser of the subset of TOML used by the ndefence config file
+//
+
+package ndefenceConfig
+
+//
+// Imports
+//
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+//
+// Value object definition
+//
+type Value struct {
+
+	// Full dotted key, e.g. "blocking.window"
+	Key string
+
+	// Line of the config file the value was set on
+	Line int
+
+	// Parsed value; a string, int64, float64, bool or []interface{}
+	Data interface{}
+}
+
+// Text ... the value as text, the same way it would be given on the
+// command line; lists become comma separated
+/*
+ * @return    string    text
+ */
+func (v Value) Text() string {
+	return formatData(v.Data)
+}
+
+// ParseTOML ... parse the contents of a config file written in a subset of
+// TOML, namely comments, [tables], key = value pairs, dotted keys, basic
+// and literal strings, integers, floats, booleans, arrays and inline
+// tables; every value is keyed by its full dotted key
+/*
+ * @param     string    config file contents
+ *
+ * @return    map       map[dotted key] = value
+ * @return    error     error message, if any
+ */
+func ParseTOML(contents string) (map[string]Value, error) {
+
+	// variable declaration
+	values := make(map[string]Value)
+	table := ""
+	lines := strings.Split(strings.Replace(contents, "\r\n", "\n", -1),
+		"\n")
+
+	for i := 0; i < len(lines); i++ {
+
+		lineNumber := i + 1
+		line := strings.TrimSpace(stripComment(lines[i]))
+
+		// skip blank lines and comments
+		if line == "" {
+			continue
+		}
+
+		// [table] header
+		if strings.HasPrefix(line, "[") {
+
+			if strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("ParseTOML() --> line %d: arrays "+
+					"of tables are not supported", lineNumber)
+			}
+
+			end := strings.IndexByte(line, ']')
+			if end < 0 || strings.TrimSpace(line[end+1:]) != "" {
+				return nil, fmt.Errorf("ParseTOML() --> line %d: "+
+					"malformed table header", lineNumber)
+			}
+
+			keys, err := parseKey(line[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("ParseTOML() --> line %d: %s",
+					lineNumber, err)
+			}
+			table = strings.Join(keys, ".")
+			continue
+		}
+
+		// key = value
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("ParseTOML() --> line %d: expected "+
+				"key = value", lineNumber)
+		}
+
+		keys, err := parseKey(line[:eq])
+		if err != nil {
+			return nil, fmt.Errorf("ParseTOML() --> line %d: %s",
+				lineNumber, err)
+		}
+		key := strings.Join(keys, ".")
+		if table != "" {
+			key = table + "." + key
+		}
+
+		// arrays may continue over several lines, until the brackets
+		// balance out
+		text := strings.TrimSpace(line[eq+1:])
+		for strings.HasPrefix(text, "[") && !isBalanced(text) &&
+			i+1 < len(lines) {
+			i++
+			text += "\n" + stripComment(lines[i])
+		}
+
+		parsed, rest, err := parseValue(text)
+		if err == nil && strings.TrimSpace(rest) != "" {
+			err = fmt.Errorf("unexpected text after the value: %s",
+				strings.TrimSpace(rest))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ParseTOML() --> line %d: %s: %s",
+				lineNumber, key, err)
+		}
+
+		if err := store(values, key, parsed, lineNumber); err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
+
+//! Format a parsed value as text.
+/*
+ * @param     interface{}    parsed value
+ *
+ * @return    string         text
+ */
+func formatData(data interface{}) string {
+
+	switch data := data.(type) {
+	case string:
+		return data
+	case int64:
+		return strconv.FormatInt(data, 10)
+	case float64:
+		return strconv.FormatFloat(data, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(data)
+	case []interface{}:
+		items := make([]string, 0, len(data))
+		for _, item := range data {
+			items = append(items, formatData(item))
+		}
+		return strings.Join(items, ",")
+	}
+
+	return fmt.Sprint(data)
+}
+
+//! Store a value, flattening inline tables into dotted keys.
+/*
+ * @param     map            map[dotted key] = value
+ * @param     string         dotted key
+ * @param     interface{}    parsed value
+ * @param     int            line number
+ *
+ * @return    error          error message, if any
+ */
+func store(values map[string]Value, key string, parsed interface{},
+	line int) error {
+
+	if table, ok := parsed.(map[string]interface{}); ok {
+		for name, value := range table {
+			if err := store(values, key+"."+name, value, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if previous, ok := values[key]; ok {
+		return fmt.Errorf("ParseTOML() --> line %d: %s: already set on "+
+			"line %d", line, key, previous.Line)
+	}
+
+	values[key] = Value{Key: key, Line: line, Data: parsed}
+	return nil
+}
+
+//! Parse a possibly dotted and quoted key, e.g. blocking."site.com".
+/*
+ * @param     string      key text
+ *
+ * @return    string[]    pieces of the key
+ * @return    error       error message, if any
+ */
+func parseKey(text string) ([]string, error) {
+
+	// variable declaration
+	keys := make([]string, 0)
+	text = strings.TrimSpace(text)
+
+	for {
+
+		var key string
+		if strings.HasPrefix(text, "\"") || strings.HasPrefix(text, "'") {
+			parsed, rest, err := parseString(text)
+			if err != nil {
+				return nil, err
+			}
+			key, text = parsed, rest
+		} else {
+			end := 0
+			for end < len(text) && isBareKeyChar(text[end]) {
+				end++
+			}
+			key, text = text[:end], text[end:]
+		}
+
+		if key == "" {
+			return nil, fmt.Errorf("empty or malformed key")
+		}
+		keys = append(keys, key)
+
+		text = strings.TrimSpace(text)
+		if text == "" {
+			return keys, nil
+		}
+		if text[0] != '.' {
+			return nil, fmt.Errorf("malformed key near: %s", text)
+		}
+		text = strings.TrimSpace(text[1:])
+	}
+}
+
+//! Parse a single value at the start of the given text.
+/*
+ * @param     string         text
+ *
+ * @return    interface{}    parsed value
+ * @return    string         remaining text
+ * @return    error          error message, if any
+ */
+func parseValue(text string) (interface{}, string, error) {
+
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, "", fmt.Errorf("missing value")
+	}
+
+	switch text[0] {
+	case '"', '\'':
+		return parseString(text)
+	case '[':
+		return parseArray(text)
+	case '{':
+		return parseInlineTable(text)
+	}
+
+	// a bare value runs until a separator or comment
+	end := strings.IndexAny(text, ",]}# \t\n")
+	if end < 0 {
+		end = len(text)
+	}
+	word, rest := text[:end], text[end:]
+
+	switch word {
+	case "true":
+		return true, rest, nil
+	case "false":
+		return false, rest, nil
+	}
+
+	number := strings.Replace(word, "_", "", -1)
+	if i, err := strconv.ParseInt(number, 0, 64); err == nil {
+		return i, rest, nil
+	}
+	if f, err := strconv.ParseFloat(number, 64); err == nil {
+		return f, rest, nil
+	}
+
+	return nil, rest, fmt.Errorf("unrecognized value: %s (strings must "+
+		"be quoted)", word)
+}
+
+//! Parse a basic "string" or literal 'string'.
+/*
+ * @param     string    text, starting with the opening quote
+ *
+ * @return    string    parsed string
+ * @return    string    remaining text
+ * @return    error     error message, if any
+ */
+func parseString(text string) (string, string, error) {
+
+	if strings.HasPrefix(text, `"""`) || strings.HasPrefix(text, "'''") {
+		return "", "", fmt.Errorf("multi-line strings are not supported")
+	}
+
+	// literal strings have no escapes at all
+	if text[0] == '\'' {
+		end := strings.IndexByte(text[1:], '\'')
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated string")
+		}
+		return text[1 : end+1], text[end+2:], nil
+	}
+
+	var result strings.Builder
+	for i := 1; i < len(text); i++ {
+
+		switch c := text[i]; c {
+
+		case '"':
+			return result.String(), text[i+1:], nil
+
+		case '\n':
+			return "", "", fmt.Errorf("unterminated string")
+
+		case '\\':
+			if i+1 >= len(text) {
+				return "", "", fmt.Errorf("unterminated string")
+			}
+			i++
+			switch text[i] {
+			case '"', '\\':
+				result.WriteByte(text[i])
+			case 'n':
+				result.WriteByte('\n')
+			case 't':
+				result.WriteByte('\t')
+			case 'r':
+				result.WriteByte('\r')
+			case 'u', 'U':
+				size := 4
+				if text[i] == 'U' {
+					size = 8
+				}
+				if i+size >= len(text) {
+					return "", "", fmt.Errorf("malformed unicode escape")
+				}
+				code, err := strconv.ParseUint(text[i+1:i+1+size], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(code)) {
+					return "", "", fmt.Errorf("malformed unicode escape")
+				}
+				result.WriteRune(rune(code))
+				i += size
+			default:
+				return "", "", fmt.Errorf("unknown escape: \\%c", text[i])
+			}
+
+		default:
+			result.WriteByte(c)
+		}
+	}
+
+	return "", "", fmt.Errorf("unterminated string")
+}
+
+//! Parse an [array], which may span several lines.
+/*
+ * @param     string           text, starting with the opening bracket
+ *
+ * @return    interface{}[]    parsed values
+ * @return    string           remaining text
+ * @return    error            error message, if any
+ */
+func parseArray(text string) (interface{}, string, error) {
+
+	// variable declaration
+	array := make([]interface{}, 0)
+	text = strings.TrimSpace(text[1:])
+
+	for {
+
+		if strings.HasPrefix(text, "]") {
+			return array, text[1:], nil
+		}
+
+		value, rest, err := parseValue(text)
+		if err != nil {
+			return nil, "", err
+		}
+		array = append(array, value)
+
+		text = strings.TrimSpace(rest)
+		if strings.HasPrefix(text, ",") {
+			text = strings.TrimSpace(text[1:])
+			continue
+		}
+		if !strings.HasPrefix(text, "]") {
+			return nil, "", fmt.Errorf("expected , or ] in array")
+		}
+	}
+}
+
+//! Parse an { inline = "table" }.
+/*
+ * @param     string         text, starting with the opening brace
+ *
+ * @return    interface{}    map[key] = parsed value
+ * @return    string         remaining text
+ * @return    error          error message, if any
+ */
+func parseInlineTable(text string) (interface{}, string, error) {
+
+	// variable declaration
+	table := make(map[string]interface{})
+	text = strings.TrimSpace(text[1:])
+
+	for {
+
+		if strings.HasPrefix(text, "}") {
+			return table, text[1:], nil
+		}
+
+		eq := strings.IndexByte(text, '=')
+		if eq < 0 {
+			return nil, "", fmt.Errorf("expected key = value in table")
+		}
+		keys, err := parseKey(text[:eq])
+		if err != nil {
+			return nil, "", err
+		}
+
+		value, rest, err := parseValue(text[eq+1:])
+		if err != nil {
+			return nil, "", err
+		}
+		table[strings.Join(keys, ".")] = value
+
+		text = strings.TrimSpace(rest)
+		if strings.HasPrefix(text, ",") {
+			text = strings.TrimSpace(text[1:])
+			continue
+		}
+		if !strings.HasPrefix(text, "}") {
+			return nil, "", fmt.Errorf("expected , or } in table")
+		}
+	}
+}
+
+//! Whether the brackets of an array balance out, ignoring quoted text.
+/*
+ * @param     string    text
+ *
+ * @return    bool      whether or not this is true
+ */
+func isBalanced(text string) bool {
+
+	depth := 0
+	quote := byte(0)
+
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		}
+	}
+
+	return depth <= 0
+}
+
+//! Remove a trailing comment from a line, ignoring quoted text.
+/*
+ * @param     string    line
+ *
+ * @return    string    line without the comment
+ */
+func stripComment(line string) string {
+
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+
+	return line
+}
+
+//! Whether a character may appear in a bare key.
+/*
+ * @param     byte    character
+ *
+ * @return    bool    whether or not this is true
+ */
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+		c >= '0' && c <= '9' || c == '_' || c == '-'
+}
@@ -0,0 +1,160 @@
+//
+// Tests of the TOML subset parser of ndefence
+//
+
+package ndefenceConfig
+
+//
+// Imports
+//
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParseTOML ... every supported construct parses into its dotted key
+func TestParseTOML(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		contents string
+		expected map[string]interface{}
+	}{
+		{
+			name:     "basic string with escapes",
+			contents: `key = "a \"quoted\" \\ value\té"`,
+			expected: map[string]interface{}{
+				"key": "a \"quoted\" \\ value\té"},
+		},
+		{
+			name:     "literal string without escapes",
+			contents: `key = '^/wp-login\.php$'`,
+			expected: map[string]interface{}{"key": `^/wp-login\.php$`},
+		},
+		{
+			name: "integers, floats and booleans",
+			contents: "a = 1_000\nb = -0.5\nc = true\nd = false\n" +
+				"e = 0x10",
+			expected: map[string]interface{}{"a": int64(1000),
+				"b": -0.5, "c": true, "d": false, "e": int64(16)},
+		},
+		{
+			name: "array spanning lines with comments",
+			contents: "list = [\n" +
+				"  \"one\", # first\n" +
+				"  'two#not a comment',\n" +
+				"  [3, 4],\n" +
+				"]\n" +
+				"after = 1",
+			expected: map[string]interface{}{
+				"list": []interface{}{"one", "two#not a comment",
+					[]interface{}{int64(3), int64(4)}},
+				"after": int64(1)},
+		},
+		{
+			name: "dotted tables and keys",
+			contents: "[blocking]\nwindow = \"24h\"\n" +
+				"[blocking.site_thresholds]\n\"shop.example.com\" = 5\n" +
+				"[detectors]\nrate.limits = [\"1m=300\"]",
+			expected: map[string]interface{}{
+				"blocking.window": "24h",
+				"blocking.site_thresholds.shop.example.com": int64(5),
+				"detectors.rate.limits": []interface{}{
+					"1m=300"}},
+		},
+		{
+			name: "inline tables are flattened",
+			contents: "[detectors]\n" +
+				"strict = { type = \"country\", threshold = 2 }",
+			expected: map[string]interface{}{
+				"detectors.strict.type":      "country",
+				"detectors.strict.threshold": int64(2)},
+		},
+		{
+			name: "comments, blank lines and CRLF line endings",
+			contents: "# leading comment\r\n\r\n" +
+				"key = \"value # kept\" # trailing comment\r\n",
+			expected: map[string]interface{}{"key": "value # kept"},
+		},
+	}
+
+	for _, test := range tests {
+
+		values, err := ParseTOML(test.contents)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+
+		parsed := make(map[string]interface{}, len(values))
+		for key, value := range values {
+			if value.Key != key {
+				t.Errorf("%s: value of %s is keyed as %s", test.name, key,
+					value.Key)
+			}
+			parsed[key] = value.Data
+		}
+
+		if !reflect.DeepEqual(parsed, test.expected) {
+			t.Errorf("%s: expected %#v, got: %#v", test.name,
+				test.expected, parsed)
+		}
+	}
+}
+
+// TestParseTOMLLines ... every value remembers the line it was set on,
+// including values after an array spanning lines
+func TestParseTOMLLines(t *testing.T) {
+
+	values, err := ParseTOML("a = 1\n\nb = [\n1,\n2]\nc = 3\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for key, line := range map[string]int{"a": 1, "b": 3, "c": 6} {
+		if values[key].Line != line {
+			t.Errorf("%s: expected line %d, got: %d", key, line,
+				values[key].Line)
+		}
+	}
+}
+
+// TestParseTOMLRejected ... malformed input is refused, naming the line
+func TestParseTOMLRejected(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		contents string
+		message  string
+	}{
+		{"bare string", "key = value", "line 1"},
+		{"missing value", "key =", "missing value"},
+		{"missing equals sign", "\nkey", "line 2: expected key = value"},
+		{"unterminated basic string", `key = "open`, "unterminated"},
+		{"unterminated literal string", "key = 'open", "unterminated"},
+		{"unknown escape", `key = "\q"`, "unknown escape"},
+		{"malformed unicode escape", `key = "\u12"`, "unicode"},
+		{"multi-line string", `key = """text"""`, "multi-line"},
+		{"unterminated array", "key = [1, 2", "expected , or ]"},
+		{"text after the value", "key = 1 2", "unexpected text"},
+		{"array of tables", "[[servers]]", "arrays of tables"},
+		{"malformed table header", "[blocking", "malformed table"},
+		{"empty key", "= 1", "empty or malformed key"},
+		{"duplicate key", "[a]\nb = 1\n[a]\nb = 2",
+			"already set on line 2"},
+	}
+
+	for _, test := range tests {
+
+		_, err := ParseTOML(test.contents)
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%s: expected an error containing %q, got: %s",
+				test.name, test.message, err)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+//
+// User agent detector of ndefence
+//
+
+package ndefenceDetect
+
+//
+// Imports
+//
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/rbisewski/ndefence/ndefenceLog"
+	"github.com/rbisewski/ndefence/ndefenceUtils"
+)
+
+//
+// Globals
+//
+var (
+
+	// Scores of the classes of user agent, unless others are given; the
+	// tools of attackers and scrapers, as well as a missing user agent,
+	// block an address by themselves, whereas headless browsers only add to
+	// the other findings. The HTTP libraries are left out, since monitoring
+	// probes, webhooks and mobile apps use them as much as attackers do
+	DefaultAgentScores = "scanner=1,scraper=1,empty=1,headless=0.5"
+)
+
+//
+// Detector of clients using the user agents of attack tools and scrapers,
+// as classified by the user agent patterns
+//
+type agentDetector struct {
+	name   string
+	scores map[string]float64
+
+	// Requests of every client, by class of user agent, along with a user
+	// agent of the highest scoring class, to show in the findings
+	events  *counterSet
+	samples map[string]string
+}
+
+//
+// Persisted state of a user agent detector
+//
+type agentSnapshot struct {
+	Events  json.RawMessage   `json:"events"`
+	Samples map[string]string `json:"samples"`
+}
+
+//! Assemble a user agent detector; options are "scores", a list of
+//! class=score pairs adjusting the default ones, and "window"; classes
+//! without a score are ignored.
+/*
+ * @param     string      name of the detector
+ * @param     Options     options
+ * @param     Defaults    general settings
+ *
+ * @return    Detector    detector
+ * @return    error       error message, if any
+ */
+func newAgentDetector(name string, options Options,
+	defaults Defaults) (Detector, error) {
+
+	if err := options.Check("scores", "window"); err != nil {
+		return nil, err
+	}
+
+	scores, err := options.Scores("scores", DefaultAgentScores)
+	if err != nil {
+		return nil, err
+	}
+	window, err := options.Duration("window", defaults.Window)
+	if err != nil {
+		return nil, err
+	}
+
+	return &agentDetector{name: name, scores: scores,
+		events:  newCounterSet(window, defaults.Resolution),
+		samples: make(map[string]string)}, nil
+}
+
+// Observe ... count the requests of a client made with a user agent of a
+// class that has a score
+func (d *agentDetector) Observe(entry ndefenceLog.LogEntry) bool {
+
+	score := d.scores[entry.Agent]
+	if score <= 0 {
+		return false
+	}
+
+	// note the first user agent of the highest scoring class
+	sample, ok := d.samples[entry.ClientIP]
+	if !ok || score > d.scores[d.classOf(entry.ClientIP, entry.Time)] {
+		sample = entry.UserAgent
+	}
+	d.samples[entry.ClientIP] = sample
+
+	return d.events.add(entry.ClientIP, entry.Agent, entry.Time) == 1
+}
+
+// Evaluate ... find every client that used a user agent of a class that has
+// a score within the window
+func (d *agentDetector) Evaluate(ctx Context) []Finding {
+
+	findings := make([]Finding, 0)
+	aggregated, members := AggregateCounts(d.events.counts(ctx.Now))
+
+	for target, classes := range aggregated {
+
+		// only the highest scoring class counts, since a client using
+		// several tools is no more of a threat than one using the worst
+		best := ""
+		for class := range classes {
+			if best == "" || d.scores[class] > d.scores[best] ||
+				(d.scores[class] == d.scores[best] && class < best) {
+				best = class
+			}
+		}
+
+		sample := ""
+		for _, ip := range members[target] {
+			if d.classOf(ip, ctx.Now) == best {
+				sample = d.samples[ip]
+				break
+			}
+		}
+
+		reason := fmt.Sprintf("%s user agent within %s (%s)", best,
+			ndefenceUtils.FormatDuration(d.events.window),
+			ndefenceUtils.FormatCounts(classes))
+		if sample != "" && sample != "-" {
+			reason += fmt.Sprintf(": %q", sample)
+		}
+
+		findings = append(findings, Finding{IP: target, Rule: d.name,
+			Score: d.scores[best], Reason: reason})
+	}
+
+	return findings
+}
+
+// Prune ... discard the requests that fell out of the window, along with
+// the user agents of the clients left without any
+func (d *agentDetector) Prune(now time.Time) {
+
+	d.events.prune(now)
+
+	for ip := range d.samples {
+		if _, ok := d.events.counters[ip]; !ok {
+			delete(d.samples, ip)
+		}
+	}
+}
+
+// Save ... encode the request counts and user agents
+func (d *agentDetector) Save() (json.RawMessage, error) {
+
+	events, err := d.events.save()
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(agentSnapshot{Events: events, Samples: d.samples})
+}
+
+// Restore ... decode previously saved request counts and user agents
+func (d *agentDetector) Restore(state json.RawMessage) error {
+
+	var snapshot agentSnapshot
+	if err := json.Unmarshal(state, &snapshot); err != nil {
+		return err
+	}
+
+	if len(snapshot.Events) > 0 {
+		if err := d.events.restore(snapshot.Events); err != nil {
+			return err
+		}
+	}
+	if snapshot.Samples != nil {
+		d.samples = snapshot.Samples
+	}
+
+	return nil
+}
+
+//! Highest scoring class of user agent a client used within the window.
+/*
+ * @param     string       IP address
+ * @param     time.Time    end of the window
+ *
+ * @return    string       class, or an empty string if there is none
+ */
+func (d *agentDetector) classOf(ip string, now time.Time) string {
+
+	best := ""
+	for class := range d.events.counters[ip] {
+		if d.events.count(ip, class, now) < 1 {
+			continue
+		}
+		if best == "" || d.scores[class] > d.scores[best] ||
+			(d.scores[class] == d.scores[best] && class < best) {
+			best = class
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,433 @@
+//
+// Built-in detectors of ndefence
+//
+
+package ndefenceDetect
+
+//
+// Imports
+//
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rbisewski/ndefence/ndefenceLog"
+	"github.com/rbisewski/ndefence/ndefenceUtils"
+)
+
+// Register the built-in types of detector.
+func init() {
+	Register("redirect", newRedirectDetector)
+	Register("country", newCountryDetector)
+	Register("errors", newErrorsDetector)
+	Register("rule", newRuleDetector)
+	Register("rate", newRateDetector)
+	Register("signatures", newSignatureDetector)
+	Register("scanning", newScanningDetector)
+	Register("agent", newAgentDetector)
+	Register("login", newLoginDetector)
+	Register("subnet", newSubnetDetector)
+}
+
+//
+// Detector of clients that were redirected in a suspicious way, e.g. via an
+// open redirect of the site or around a loop
+//
+type redirectDetector struct {
+	name     string
+	statuses []int
+	kinds    map[string]bool
+	score    float64
+	events   *counterSet
+}
+
+//
+// Detector of clients that made too many requests to a site, as per the
+// country policy
+//
+type countryDetector struct {
+	name      string
+	threshold int
+	defaults  Defaults
+	score     float64
+	events    *counterSet
+}
+
+//
+// Detector of clients that caused too many error log events
+//
+type errorsDetector struct {
+	name      string
+	threshold int
+	score     float64
+	events    *counterSet
+}
+
+//! Assemble a redirect detector; options are "status", a list of the
+//! status codes to look for, all of 3xx redirects by default, "kinds", a
+//! list of the classes of redirect to count, e.g. "open" or "loop",
+//! "window" and "score".
+/*
+ * @param     string      name of the detector
+ * @param     Options     options
+ * @param     Defaults    general settings
+ *
+ * @return    Detector    detector
+ * @return    error       error message, if any
+ */
+func newRedirectDetector(name string, options Options,
+	defaults Defaults) (Detector, error) {
+
+	err := options.Check("status", "kinds", "window", "score")
+	if err != nil {
+		return nil, err
+	}
+
+	statuses, err := options.Ints("status", ndefenceLog.RedirectStatuses)
+	if err != nil {
+		return nil, err
+	}
+
+	// internal and external redirects are part of any site, so only the
+	// ones abusing it are counted unless others are given
+	text, ok := options["kinds"]
+	if !ok {
+		text = ndefenceLog.RedirectOpen + "," + ndefenceLog.RedirectLoop
+	}
+	kinds := make(map[string]bool)
+	for _, kind := range strings.Split(text, ",") {
+		kind = strings.ToLower(strings.TrimSpace(kind))
+		switch kind {
+		case "":
+			continue
+		case ndefenceLog.RedirectInternal, ndefenceLog.RedirectExternal,
+			ndefenceLog.RedirectOpen, ndefenceLog.RedirectLoop,
+			ndefenceLog.RedirectUnknown:
+			kinds[kind] = true
+		default:
+			return nil, fmt.Errorf("kinds: unknown class of redirect: %q",
+				kind)
+		}
+	}
+	if len(kinds) < 1 {
+		return nil, fmt.Errorf("kinds: at least one class is needed")
+	}
+
+	window, err := options.Duration("window", defaults.Window)
+	if err != nil {
+		return nil, err
+	}
+	score, err := options.Float("score", BlockScore)
+	if err != nil {
+		return nil, err
+	}
+
+	return &redirectDetector{name: name, statuses: statuses, kinds: kinds,
+		score: score, events: newCounterSet(window, defaults.Resolution)}, nil
+}
+
+// Observe ... count the redirects of the given classes issued to a client
+func (d *redirectDetector) Observe(entry ndefenceLog.LogEntry) bool {
+
+	if !d.kinds[entry.Redirect] {
+		return false
+	}
+
+	for _, status := range d.statuses {
+		if entry.Status == status {
+			return d.events.add(entry.ClientIP, entry.Redirect,
+				entry.Time) == 1
+		}
+	}
+
+	return false
+}
+
+// Evaluate ... find every client redirected in a suspicious way within
+// the window
+func (d *redirectDetector) Evaluate(ctx Context) []Finding {
+
+	findings := make([]Finding, 0)
+	aggregated, _ := AggregateCounts(d.events.counts(ctx.Now))
+
+	for target, kinds := range aggregated {
+		findings = append(findings, Finding{IP: target, Rule: d.name,
+			Score: d.score, Reason: fmt.Sprintf("redirected within %s "+
+				"(%s)", ndefenceUtils.FormatDuration(d.events.window),
+				ndefenceUtils.FormatCounts(kinds))})
+	}
+
+	return findings
+}
+
+// Prune ... discard the redirects that fell out of the window
+func (d *redirectDetector) Prune(now time.Time) {
+	d.events.prune(now)
+}
+
+// Save ... encode the redirect counts
+func (d *redirectDetector) Save() (json.RawMessage, error) {
+	return d.events.save()
+}
+
+// Restore ... decode previously saved redirect counts
+func (d *redirectDetector) Restore(state json.RawMessage) error {
+	return d.events.restore(state)
+}
+
+//! Assemble a country detector; options are "threshold", which replaces
+//! the general request threshold, "window" and "score".
+/*
+ * @param     string      name of the detector
+ * @param     Options     options
+ * @param     Defaults    general settings
+ *
+ * @return    Detector    detector
+ * @return    error       error message, if any
+ */
+func newCountryDetector(name string, options Options,
+	defaults Defaults) (Detector, error) {
+
+	if err := options.Check("threshold", "window", "score"); err != nil {
+		return nil, err
+	}
+	if defaults.Countries == nil {
+		return nil, fmt.Errorf("no country policy given")
+	}
+
+	threshold, err := options.Int("threshold", defaults.RequestThreshold)
+	if err != nil {
+		return nil, err
+	}
+	window, err := options.Duration("window", defaults.Window)
+	if err != nil {
+		return nil, err
+	}
+	score, err := options.Float("score", BlockScore)
+	if err != nil {
+		return nil, err
+	}
+
+	return &countryDetector{name: name, threshold: threshold,
+		defaults: defaults, score: score,
+		events: newCounterSet(window, defaults.Resolution)}, nil
+}
+
+// Observe ... count the requests of a client to every site
+func (d *countryDetector) Observe(entry ndefenceLog.LogEntry) bool {
+	d.events.add(entry.ClientIP, entry.Site, entry.Time)
+	return false
+}
+
+// Evaluate ... find every client of a country subject to blocking that
+// made at least as many requests to a site as its threshold
+func (d *countryDetector) Evaluate(ctx Context) []Finding {
+
+	findings := make([]Finding, 0)
+	aggregated, members := AggregateCounts(d.events.counts(ctx.Now))
+
+	for target, sites := range aggregated {
+
+		// obtain the country code of this IP address, or of the first
+		// address of the network that has one
+		givenCountryCode := ""
+		for _, ip := range members[target] {
+			if code, ok := ctx.Countries[ip]; ok {
+				givenCountryCode = code
+				break
+			}
+		}
+
+		// skip if the country policy exempts this country, which is
+		// also the case if the country is unknown
+		policy := d.defaults.Countries
+		if !policy.Evaluates(givenCountryCode) {
+			continue
+		}
+
+		// a country threshold of zero exempts the country as well
+		countryThreshold, hasCountryThreshold := policy.Threshold(
+			givenCountryCode)
+		if hasCountryThreshold && countryThreshold == 0 {
+			continue
+		}
+
+		// skip to the next if no site received at least as many
+		// requests as its threshold
+		site, threshold, ok := d.exceededSite(sites, countryThreshold,
+			hasCountryThreshold)
+		if !ok {
+			continue
+		}
+
+		findings = append(findings, Finding{IP: target, Rule: d.name,
+			Score: d.score, Reason: fmt.Sprintf("%d requests to %s within "+
+				"%s from %s (threshold %d)", sites[site], site,
+				ndefenceUtils.FormatDuration(d.events.window),
+				givenCountryCode, threshold)})
+	}
+
+	return findings
+}
+
+// Addresses ... the addresses counted within the window, since their
+// countries are needed even once they left the report window
+func (d *countryDetector) Addresses(now time.Time) []string {
+	return d.events.addresses(now)
+}
+
+//! The site whose threshold the requests of an address reached, if any; a
+//! country threshold replaces the default one, and where a site has a
+//! threshold of its own the lower of the two applies.
+/*
+ * @param     map       map[site] = count
+ * @param     int       threshold of the country of the address
+ * @param     bool      whether the country has a threshold at all
+ *
+ * @return    string    site
+ * @return    int       threshold of the site
+ * @return    bool      whether any site reached its threshold
+ */
+func (d *countryDetector) exceededSite(sites map[string]int,
+	countryThreshold int, hasCountryThreshold bool) (string, int, bool) {
+
+	// check the sites in order, so that the reason is always the same
+	names := make([]string, 0, len(sites))
+	for site := range sites {
+		names = append(names, site)
+	}
+	sort.Strings(names)
+
+	for _, site := range names {
+
+		threshold, ok := d.defaults.SiteThresholds[site]
+		switch {
+		case !ok && hasCountryThreshold:
+			threshold = countryThreshold
+		case !ok:
+			threshold = d.threshold
+		case hasCountryThreshold && threshold > 0 &&
+			countryThreshold < threshold:
+			threshold = countryThreshold
+		}
+
+		// a threshold of zero disables the check for that site
+		if threshold > 0 && sites[site] >= threshold {
+			return site, threshold, true
+		}
+	}
+
+	return "", 0, false
+}
+
+// Prune ... discard the requests that fell out of the window
+func (d *countryDetector) Prune(now time.Time) {
+	d.events.prune(now)
+}
+
+// Save ... encode the request counts
+func (d *countryDetector) Save() (json.RawMessage, error) {
+	return d.events.save()
+}
+
+// Restore ... decode previously saved request counts
+func (d *countryDetector) Restore(state json.RawMessage) error {
+	return d.events.restore(state)
+}
+
+//! Assemble an errors detector; options are "threshold", which replaces
+//! the general error threshold, "window" and "score".
+/*
+ * @param     string      name of the detector
+ * @param     Options     options
+ * @param     Defaults    general settings
+ *
+ * @return    Detector    detector
+ * @return    error       error message, if any
+ */
+func newErrorsDetector(name string, options Options,
+	defaults Defaults) (Detector, error) {
+
+	if err := options.Check("threshold", "window", "score"); err != nil {
+		return nil, err
+	}
+
+	threshold, err := options.Int("threshold", defaults.ErrorThreshold)
+	if err != nil {
+		return nil, err
+	}
+	window, err := options.Duration("window", defaults.Window)
+	if err != nil {
+		return nil, err
+	}
+	score, err := options.Float("score", BlockScore)
+	if err != nil {
+		return nil, err
+	}
+
+	return &errorsDetector{name: name, threshold: threshold, score: score,
+		events: newCounterSet(window, defaults.Resolution)}, nil
+}
+
+// Observe ... access log entries are of no interest to this detector
+func (d *errorsDetector) Observe(entry ndefenceLog.LogEntry) bool {
+	return false
+}
+
+// ObserveError ... count the errors caused by a client, by category; an
+// address reaching the threshold is worth blocking right away
+func (d *errorsDetector) ObserveError(entry ndefenceLog.ErrorEntry) bool {
+
+	// a threshold of zero only reports the errors
+	if d.threshold < 1 {
+		return false
+	}
+
+	total := d.events.add(entry.ClientIP, entry.Category, entry.Time)
+	return total == d.threshold
+}
+
+// Evaluate ... find every client that caused at least as many errors as
+// the threshold
+func (d *errorsDetector) Evaluate(ctx Context) []Finding {
+
+	findings := make([]Finding, 0)
+	aggregated, _ := AggregateCounts(d.events.counts(ctx.Now))
+
+	for target, categories := range aggregated {
+
+		total := 0
+		for _, count := range categories {
+			total += count
+		}
+
+		if d.threshold < 1 || total < d.threshold {
+			continue
+		}
+
+		findings = append(findings, Finding{IP: target, Rule: d.name,
+			Score: d.score, Reason: fmt.Sprintf("%d errors within %s (%s)",
+				total, ndefenceUtils.FormatDuration(d.events.window),
+				ndefenceUtils.FormatCounts(categories))})
+	}
+
+	return findings
+}
+
+// Prune ... discard the errors that fell out of the window
+func (d *errorsDetector) Prune(now time.Time) {
+	d.events.prune(now)
+}
+
+// Save ... encode the error counts
+func (d *errorsDetector) Save() (json.RawMessage, error) {
+	return d.events.save()
+}
+
+// Restore ... decode previously saved error counts
+func (d *errorsDetector) Restore(state json.RawMessage) error {
+	return d.events.restore(state)
+}
@@ -0,0 +1,200 @@
+//
+// Per-address event counters shared by the detectors of ndefence
+//
+
+package ndefenceDetect
+
+//
+// Imports
+//
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/rbisewski/ndefence/ndefenceStats"
+)
+
+//
+// Counter set object definition, counting the events of every address by
+// key, e.g. by site or by error category
+//
+type counterSet struct {
+
+	// Span of time over which events are counted, and resolution thereof
+	window     time.Duration
+	resolution time.Duration
+
+	// Counters of every address, by key
+	counters map[string]map[string]*ndefenceStats.SlidingCounter
+}
+
+//! Assemble an empty counter set.
+/*
+ * @param     duration       span of time over which events are counted
+ * @param     duration       size of a single bucket
+ *
+ * @return    *counterSet    counter set
+ */
+func newCounterSet(window time.Duration,
+	resolution time.Duration) *counterSet {
+
+	return &counterSet{
+		window:     window,
+		resolution: resolution,
+		counters:   make(map[string]map[string]*ndefenceStats.SlidingCounter),
+	}
+}
+
+//! Count a single event of an address.
+/*
+ * @param     string       IP address
+ * @param     string       key, e.g. site or error category
+ * @param     time.Time    time of the event
+ *
+ * @return    int          events of the address, over every key, within
+ *                         the window ending at the time of the event
+ */
+func (s *counterSet) add(ip string, key string, t time.Time) int {
+
+	keyed, ok := s.counters[ip]
+	if !ok {
+		keyed = make(map[string]*ndefenceStats.SlidingCounter)
+		s.counters[ip] = keyed
+	}
+
+	counter, ok := keyed[key]
+	if !ok {
+		counter = ndefenceStats.NewSlidingCounter(s.window, s.resolution)
+		keyed[key] = counter
+	}
+	counter.Add(t, 1)
+
+	total := 0
+	for _, counter := range keyed {
+		total += counter.CountWithin(t, s.window)
+	}
+
+	return total
+}
+
+//! Events of an address under a single key within the window ending at the
+//! given time.
+/*
+ * @param     string       IP address
+ * @param     string       key, e.g. site or error category
+ * @param     time.Time    end of the window
+ *
+ * @return    int          number of events
+ */
+func (s *counterSet) count(ip string, key string, now time.Time) int {
+
+	counter, ok := s.counters[ip][key]
+	if !ok {
+		return 0
+	}
+
+	return counter.CountWithin(now, s.window)
+}
+
+//! Event counts of every address, by key, within the window ending at the
+//! given time.
+/*
+ * @param     time.Time    end of the window
+ *
+ * @return    map          map[IP address][key] = count
+ */
+func (s *counterSet) counts(now time.Time) map[string]map[string]int {
+
+	counts := make(map[string]map[string]int)
+	for ip, keyed := range s.counters {
+		for key, counter := range keyed {
+
+			count := counter.CountWithin(now, s.window)
+			if count < 1 {
+				continue
+			}
+
+			if _, ok := counts[ip]; !ok {
+				counts[ip] = make(map[string]int)
+			}
+			counts[ip][key] = count
+		}
+	}
+
+	return counts
+}
+
+//! Addresses with any events within the window ending at the given time.
+/*
+ * @param     time.Time    end of the window
+ *
+ * @return    string[]     IP addresses
+ */
+func (s *counterSet) addresses(now time.Time) []string {
+
+	counts := s.counts(now)
+	addresses := make([]string, 0, len(counts))
+	for ip := range counts {
+		addresses = append(addresses, ip)
+	}
+
+	return addresses
+}
+
+//! Discard the counts that fell out of the window, along with any counters
+//! left empty.
+/*
+ * @param     time.Time    end of the window
+ */
+func (s *counterSet) prune(now time.Time) {
+
+	for ip, keyed := range s.counters {
+		for key, counter := range keyed {
+			counter.Prune(now)
+			if counter.Empty() {
+				delete(keyed, key)
+			}
+		}
+		if len(keyed) == 0 {
+			delete(s.counters, ip)
+		}
+	}
+}
+
+//! Encode the counters, so that they can be persisted.
+/*
+ * @return    RawMessage    encoded counters
+ * @return    error         error message, if any
+ */
+func (s *counterSet) save() (json.RawMessage, error) {
+	return json.Marshal(s.counters)
+}
+
+//! Decode previously persisted counters, adopting the current window.
+/*
+ * @param     RawMessage    encoded counters
+ *
+ * @return    error         error message, if any
+ */
+func (s *counterSet) restore(state json.RawMessage) error {
+
+	persisted := make(map[string]map[string]*ndefenceStats.SlidingCounter)
+	if err := json.Unmarshal(state, &persisted); err != nil {
+		return err
+	}
+
+	for ip, keyed := range persisted {
+		for key, counter := range keyed {
+			if counter == nil {
+				delete(keyed, key)
+				continue
+			}
+			counter.Window = s.window
+		}
+		if len(keyed) > 0 {
+			s.counters[ip] = keyed
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,575 @@
+//
+// Detection rules of ndefence
+//
+
+package ndefenceDetect
+
+//
+// Imports
+//
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rbisewski/ndefence/ndefenceHostname"
+	"github.com/rbisewski/ndefence/ndefenceLog"
+	"github.com/rbisewski/ndefence/ndefenceUtils"
+)
+
+//
+// Globals
+//
+var (
+
+	// Score at which an address is blocked, unless the threat policy says
+	// otherwise, and that of a finding unless its detector says otherwise;
+	// the scores of every finding for an address are added up, so such a
+	// finding blocks an address by itself
+	BlockScore = 1.0
+
+	// Factories of every type of detector, keyed by type
+	factories = make(map[string]Factory)
+
+	// Types of detector that are enabled unless the config says otherwise;
+	// the redirect detector is not, since redirects are part of any site,
+	// and only blocks once it is given a table of its own
+	builtinDetectors = []string{"country", "errors", "rate", "signatures",
+		"scanning", "agent", "login", "subnet"}
+)
+
+//
+// Detector interface definition
+//
+type Detector interface {
+
+	// Observe ... take a single access log entry into account; returns
+	// whether the entry may have caused a new finding, so that the
+	// findings are worth evaluating right away
+	Observe(entry ndefenceLog.LogEntry) bool
+
+	// Evaluate ... the findings of the detector at the given time
+	Evaluate(ctx Context) []Finding
+}
+
+//
+// ErrorObserver interface definition, for detectors interested in the
+// error log as well
+//
+type ErrorObserver interface {
+
+	// ObserveError ... take a single error log entry into account; returns
+	// whether the entry may have caused a new finding
+	ObserveError(entry ndefenceLog.ErrorEntry) bool
+}
+
+//
+// Stateful interface definition, for detectors whose state is persisted
+// between runs
+//
+type Stateful interface {
+
+	// Prune ... discard whatever fell out of the window ending at the
+	// given time
+	Prune(now time.Time)
+
+	// Save ... encode the state of the detector
+	Save() (json.RawMessage, error)
+
+	// Restore ... decode a previously saved state of the detector
+	Restore(state json.RawMessage) error
+}
+
+//
+// Annotator interface definition, for detectors with something to add to
+// the report of an address, e.g. the paths it requested
+//
+type Annotator interface {
+
+	// Annotate ... notes on the addresses of interest at the given time
+	Annotate(ctx Context) map[string]string
+}
+
+//
+// Addresser interface definition, for detectors relying on the whois
+// records of the addresses they count, which may reach further back than
+// the report window
+//
+type Addresser interface {
+
+	// Addresses ... the addresses counted within the window ending at the
+	// given time
+	Addresses(now time.Time) []string
+}
+
+//
+// Factory of a type of detector
+//
+type Factory func(name string, options Options,
+	defaults Defaults) (Detector, error)
+
+//
+// Defaults object definition, holding the general settings that the
+// detectors fall back on
+//
+type Defaults struct {
+
+	// Span of time over which the detectors count, and resolution thereof
+	Window     time.Duration
+	Resolution time.Duration
+
+	// Number of requests to a site at which an address is blocked, along
+	// with per-site overrides
+	RequestThreshold int
+	SiteThresholds   map[string]int
+
+	// Countries subject to blocking for their request counts
+	Countries *ndefenceHostname.CountryPolicy
+
+	// Number of error log events at which an address is blocked
+	ErrorThreshold int
+}
+
+//
+// Context object definition, holding what is known at evaluation time
+//
+type Context struct {
+
+	// End of the windows, i.e. the time of the newest entry seen
+	Now time.Time
+
+	// Country code of every address, as per the whois records
+	Countries map[string]string
+
+	// Autonomous system of every address whose whois record names one,
+	// e.g. "AS3320"
+	ASNs map[string]string
+}
+
+//
+// Finding object definition
+//
+type Finding struct {
+
+	// Address or network to block
+	IP string
+
+	// Name of the detector that made the finding
+	Rule string
+
+	// Severity, i.e. the points it adds to the threat score of the
+	// address; findings adding up to BlockScore cause a block
+	Score float64
+
+	// Human readable explanation, e.g. "12 requests to blog within 24h"
+	Reason string
+
+	// How long to block the address for; zero for the general expiry
+	Duration time.Duration
+}
+
+//
+// Block object definition
+//
+type Block struct {
+
+	// Address or network to block
+	IP string
+
+	// How long to block it for; zero for ever
+	Duration time.Duration
+
+	// Level of the block, e.g. "escalated", as per its threat score
+	Level string
+
+	// Threat score of the address, and the points of every detector
+	// making it up, highest first
+	Score         float64
+	Contributions []Contribution
+
+	// Findings that caused the block, sorted by rule; empty if the address
+	// is blocked for earlier findings that have yet to decay
+	Findings []Finding
+}
+
+//
+// Engine object definition, holding every detector enabled
+//
+type Engine struct {
+
+	// Detectors, sorted by name
+	detectors []Detector
+
+	// Name of every detector, in the same order
+	names []string
+}
+
+// Register ... make a type of detector available to the config file
+/*
+ * @param     string     type of detector, e.g. "redirect"
+ * @param     Factory    function assembling such a detector
+ */
+func Register(kind string, factory Factory) {
+	factories[kind] = factory
+}
+
+// NewEngine ... assemble the built-in detectors, along with the ones
+// configured, e.g. "strict.type=country,strict.threshold=2", and the rules;
+// a detector named after a built-in one adjusts or, via "enabled=false",
+// disables it
+/*
+ * @param     map        map[detector name.option] = value
+ * @param     map        map[rule name] = rule, e.g. "count > 100 within
+ *                       1m => block 1h"
+ * @param     Defaults   general settings
+ *
+ * @return    *Engine    engine
+ * @return    error      error message naming the offending option, if any
+ */
+func NewEngine(settings map[string]string, rules map[string]string,
+	defaults Defaults) (*Engine, error) {
+
+	// variable declaration
+	specs := make(map[string]Options)
+
+	for _, kind := range builtinDetectors {
+		specs[kind] = Options{"type": kind}
+	}
+
+	// rules are detectors of their own, which may be given further
+	// options, e.g. a score, in a table of the same name
+	for name, rule := range rules {
+		if _, ok := specs[name]; ok {
+			return nil, fmt.Errorf("NewEngine() --> %s: the name of a "+
+				"built-in detector, so it cannot name a rule", name)
+		}
+		specs[name] = Options{"type": "rule", "rule": rule}
+	}
+
+	for key, value := range settings {
+
+		pieces := strings.SplitN(key, ".", 2)
+		if len(pieces) != 2 {
+			return nil, fmt.Errorf("NewEngine() --> %s: expected a table "+
+				"per detector, e.g. [detectors.%s]", key, key)
+		}
+
+		name, option := pieces[0], pieces[1]
+		if _, ok := specs[name]; !ok {
+			specs[name] = Options{"type": name}
+		}
+		if _, ok := rules[name]; ok && (option == "type" ||
+			option == "rule") {
+			return nil, fmt.Errorf("NewEngine() --> %s: already defined "+
+				"as a rule", name)
+		}
+		specs[name][option] = value
+	}
+
+	names := make([]string, 0, len(specs))
+	for name := range specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	engine := &Engine{}
+	for _, name := range names {
+
+		options := specs[name]
+
+		enabled, err := options.Bool("enabled", true)
+		if err != nil {
+			return nil, fmt.Errorf("NewEngine() --> %s: %s", name, err)
+		}
+		if !enabled {
+			continue
+		}
+
+		factory, ok := factories[options["type"]]
+		if !ok {
+			return nil, fmt.Errorf("NewEngine() --> %s: unknown type of "+
+				"detector: %s", name, options["type"])
+		}
+
+		detector, err := factory(name, options, defaults)
+		if err != nil {
+			return nil, fmt.Errorf("NewEngine() --> %s: %s", name, err)
+		}
+
+		engine.detectors = append(engine.detectors, detector)
+		engine.names = append(engine.names, name)
+	}
+
+	return engine, nil
+}
+
+// Names ... name of every detector enabled
+/*
+ * @return    string[]    names, sorted
+ */
+func (e *Engine) Names() []string {
+	return e.names
+}
+
+// Observe ... pass a single access log entry to every detector
+/*
+ * @param     LogEntry    parsed entry, whose client address is canonical
+ *
+ * @return    bool        whether any detector wants to be evaluated now
+ */
+func (e *Engine) Observe(entry ndefenceLog.LogEntry) bool {
+
+	urgent := false
+	for _, detector := range e.detectors {
+		if detector.Observe(entry) {
+			urgent = true
+		}
+	}
+
+	return urgent
+}
+
+// ObserveError ... pass a single error log entry to every detector
+// interested in them
+/*
+ * @param     ErrorEntry    parsed entry, whose client address is canonical
+ *
+ * @return    bool          whether any detector wants to be evaluated now
+ */
+func (e *Engine) ObserveError(entry ndefenceLog.ErrorEntry) bool {
+
+	urgent := false
+	for _, detector := range e.detectors {
+		observer, ok := detector.(ErrorObserver)
+		if ok && observer.ObserveError(entry) {
+			urgent = true
+		}
+	}
+
+	return urgent
+}
+
+// Evaluate ... gather the findings of every detector
+/*
+ * @param     Context      what is known at evaluation time
+ *
+ * @return    Finding[]    findings, sorted by address then rule
+ */
+func (e *Engine) Evaluate(ctx Context) []Finding {
+
+	findings := make([]Finding, 0)
+	for _, detector := range e.detectors {
+		findings = append(findings, detector.Evaluate(ctx)...)
+	}
+
+	sortFindings(findings)
+	return findings
+}
+
+// Annotate ... gather the notes of every detector that has any
+/*
+ * @param     Context    what is known at evaluation time
+ *
+ * @return    map        map[IP address] = notes, separated by semicolons
+ */
+func (e *Engine) Annotate(ctx Context) map[string]string {
+
+	notes := make(map[string]string)
+	for _, detector := range e.detectors {
+
+		annotator, ok := detector.(Annotator)
+		if !ok {
+			continue
+		}
+
+		for ip, note := range annotator.Annotate(ctx) {
+			if notes[ip] != "" {
+				notes[ip] += "; "
+			}
+			notes[ip] += note
+		}
+	}
+
+	return notes
+}
+
+// Addresses ... gather the addresses whose whois records any detector
+// relies on
+/*
+ * @param     time.Time    end of the windows
+ *
+ * @return    map          map[IP address] = true
+ */
+func (e *Engine) Addresses(now time.Time) map[string]bool {
+
+	addresses := make(map[string]bool)
+	for _, detector := range e.detectors {
+
+		addresser, ok := detector.(Addresser)
+		if !ok {
+			continue
+		}
+
+		for _, ip := range addresser.Addresses(now) {
+			addresses[ip] = true
+		}
+	}
+
+	return addresses
+}
+
+// Prune ... discard whatever fell out of the windows of the detectors
+/*
+ * @param     time.Time    end of the windows
+ */
+func (e *Engine) Prune(now time.Time) {
+
+	for _, detector := range e.detectors {
+		if stateful, ok := detector.(Stateful); ok {
+			stateful.Prune(now)
+		}
+	}
+}
+
+// Save ... encode the state of every detector that has one
+/*
+ * @return    map      map[detector name] = state
+ * @return    error    error message, if any
+ */
+func (e *Engine) Save() (map[string]json.RawMessage, error) {
+
+	states := make(map[string]json.RawMessage)
+	for i, detector := range e.detectors {
+
+		stateful, ok := detector.(Stateful)
+		if !ok {
+			continue
+		}
+
+		state, err := stateful.Save()
+		if err != nil {
+			return nil, fmt.Errorf("Save() --> %s: %s", e.names[i], err)
+		}
+		states[e.names[i]] = state
+	}
+
+	return states, nil
+}
+
+// Restore ... decode the previously saved state of every detector; the
+// state of a detector that changed type, or is corrupt, is discarded
+/*
+ * @param     map    map[detector name] = state
+ */
+func (e *Engine) Restore(states map[string]json.RawMessage) {
+
+	for i, detector := range e.detectors {
+
+		stateful, ok := detector.(Stateful)
+		state, saved := states[e.names[i]]
+		if !ok || !saved {
+			continue
+		}
+
+		if err := stateful.Restore(state); err != nil {
+			fmt.Println("Warning: discarding the saved state of detector " +
+				e.names[i] + ": " + err.Error())
+		}
+	}
+}
+
+// Describe ... explain a block, e.g. "redirect: redirected within 24h
+// (open: 1); errors: 25 errors within 24h (not-found: 25)"
+/*
+ * @return    string    description
+ */
+func (b Block) Describe() string {
+
+	reasons := make([]string, 0, len(b.Findings))
+	for _, finding := range b.Findings {
+		reasons = append(reasons, finding.Rule+": "+finding.Reason)
+	}
+
+	return strings.Join(reasons, "; ")
+}
+
+// BlockTarget ... the address or network to block for a given client; IPv6
+// clients usually have a whole /64 to themselves, so that is what is blocked
+/*
+ * @param     string    IP address
+ *
+ * @return    string    IP address, or network in CIDR notation
+ */
+func BlockTarget(ip string) string {
+
+	network, err := ndefenceUtils.ObtainSlash64FromIpv6(ip)
+	if err != nil {
+		return ip
+	}
+
+	return network
+}
+
+// AggregateCounts ... merge the counts of the addresses that are blocked
+// together, e.g. the IPv6 addresses of a single /64
+/*
+ * @param     map    map[IP address][key] = count
+ *
+ * @return    map    map[address or network][key] = count
+ * @return    map    map[address or network] = sorted addresses within it
+ */
+func AggregateCounts(counts map[string]map[string]int) (
+	map[string]map[string]int, map[string][]string) {
+
+	// variable declaration
+	aggregated := make(map[string]map[string]int)
+	members := make(map[string][]string)
+
+	for ip, keyed := range counts {
+
+		target := BlockTarget(ip)
+		if _, ok := aggregated[target]; !ok {
+			aggregated[target] = make(map[string]int)
+		}
+
+		for key, count := range keyed {
+			aggregated[target][key] += count
+		}
+		members[target] = append(members[target], ip)
+	}
+
+	for _, ips := range members {
+		ndefenceUtils.SortIPAddresses(ips)
+	}
+
+	return aggregated, members
+}
+
+//! Sort findings by address, then by rule.
+/*
+ * @param     Finding[]    findings, sorted in place
+ */
+func sortFindings(findings []Finding) {
+
+	ips := make([]string, 0, len(findings))
+	for _, finding := range findings {
+		ips = append(ips, finding.IP)
+	}
+	ndefenceUtils.SortIPAddresses(ips)
+
+	rank := make(map[string]int)
+	for i, ip := range ips {
+		if _, ok := rank[ip]; !ok {
+			rank[ip] = i
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].IP != findings[j].IP {
+			return rank[findings[i].IP] < rank[findings[j].IP]
+		}
+		return findings[i].Rule < findings[j].Rule
+	})
+}
@@ -0,0 +1,412 @@
+//
+// Login brute force detector of ndefence
+//
+
+package ndefenceDetect
+
+//
+// Imports
+//
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rbisewski/ndefence/ndefenceLog"
+	"github.com/rbisewski/ndefence/ndefenceUtils"
+)
+
+//
+// Globals
+//
+var (
+
+	// Authentication endpoints, unless others are given, as regular
+	// expressions matched against the path without its query string
+	DefaultLoginPaths = `^/wp-login\.php$,^/xmlrpc\.php$,` +
+		`^(/[^/]+)?/(admin/)?(login|signin|sign-in)/?$,^/user/login$`
+
+	// Status codes of the attempts that look failed, unless others are
+	// given; WordPress answers a failed login with the form again, i.e. a
+	// 200, and a successful one with a redirect
+	DefaultLoginStatuses = []int{200, 401, 403}
+
+	// Query string fields that may hold the username, unless others are
+	// given
+	DefaultLoginUserFields = "log,user,username,login,email"
+
+	// Number of failed attempts at which an address is blocked, and at
+	// which the addresses trying a single username are, unless others are
+	// given, along with the span of time they are counted over
+	DefaultLoginThreshold     = 10
+	DefaultLoginUserThreshold = 20
+	DefaultLoginWindow        = time.Hour
+
+	// Number of failed attempts an address must have made against a
+	// username under attack to be blocked along with the others, unless
+	// another is given, so that its owner mistyping a password is spared
+	DefaultLoginUserMinimum = 3
+)
+
+//
+// Detector of clients guessing passwords at the authentication endpoints,
+// one address at a time or spread over many addresses against one username
+//
+type loginDetector struct {
+	name          string
+	paths         []*regexp.Regexp
+	statuses      []int
+	methods       map[string]bool
+	userFields    []string
+	threshold     int
+	userThreshold int
+	userMinimum   int
+	score         float64
+	block         time.Duration
+
+	// Failed attempts of every client, by endpoint, and those against
+	// every username, by client
+	attempts *counterSet
+	users    *counterSet
+}
+
+//
+// Persisted state of a login detector
+//
+type loginSnapshot struct {
+	Attempts json.RawMessage `json:"attempts"`
+	Users    json.RawMessage `json:"users"`
+}
+
+//! Assemble a login detector; options are "paths", a list of regular
+//! expressions matching the authentication endpoints, "status", the status
+//! codes of failed attempts, "methods", "user_fields", the query string
+//! fields holding the username, "threshold", the failed attempts of a
+//! client at which it is blocked, "user_threshold", the failed attempts
+//! against a username at which the clients trying it are blocked,
+//! "user_minimum", the attempts a client must have made against such a
+//! username, "window", "block" and "score".
+/*
+ * @param     string      name of the detector
+ * @param     Options     options
+ * @param     Defaults    general settings
+ *
+ * @return    Detector    detector
+ * @return    error       error message, if any
+ */
+func newLoginDetector(name string, options Options,
+	defaults Defaults) (Detector, error) {
+
+	err := options.Check("paths", "status", "methods", "user_fields",
+		"threshold", "user_threshold", "user_minimum", "window", "block",
+		"score")
+	if err != nil {
+		return nil, err
+	}
+
+	text, ok := options["paths"]
+	if !ok {
+		text = DefaultLoginPaths
+	}
+	paths := make([]*regexp.Regexp, 0)
+	for _, item := range strings.Split(text, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		path, err := regexp.Compile(item)
+		if err != nil {
+			return nil, fmt.Errorf("paths: %s", err)
+		}
+		paths = append(paths, path)
+	}
+	if len(paths) < 1 {
+		return nil, fmt.Errorf("paths: at least one endpoint is needed")
+	}
+
+	statuses, err := options.Ints("status", DefaultLoginStatuses)
+	if err != nil {
+		return nil, err
+	}
+
+	methods := make(map[string]bool)
+	text, ok = options["methods"]
+	if !ok {
+		text = "POST"
+	}
+	for _, method := range strings.Split(text, ",") {
+		if method = strings.TrimSpace(method); method != "" {
+			methods[strings.ToUpper(method)] = true
+		}
+	}
+
+	text, ok = options["user_fields"]
+	if !ok {
+		text = DefaultLoginUserFields
+	}
+	userFields := make([]string, 0)
+	for _, field := range strings.Split(text, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			userFields = append(userFields, field)
+		}
+	}
+
+	threshold, err := options.Int("threshold", DefaultLoginThreshold)
+	if err != nil {
+		return nil, err
+	}
+	userThreshold, err := options.Int("user_threshold",
+		DefaultLoginUserThreshold)
+	if err != nil {
+		return nil, err
+	}
+	userMinimum, err := options.Int("user_minimum",
+		DefaultLoginUserMinimum)
+	if err != nil {
+		return nil, err
+	}
+	if userMinimum < 1 {
+		return nil, fmt.Errorf("user_minimum: must be at least 1")
+	}
+	window, err := options.Duration("window", DefaultLoginWindow)
+	if err != nil {
+		return nil, err
+	}
+	block, err := options.Duration("block", 0)
+	if err != nil {
+		return nil, err
+	}
+	score, err := options.Float("score", BlockScore)
+	if err != nil {
+		return nil, err
+	}
+
+	return &loginDetector{name: name, paths: paths, statuses: statuses,
+		methods: methods, userFields: userFields, threshold: threshold,
+		userThreshold: userThreshold, userMinimum: userMinimum,
+		score: score, block: block,
+		attempts: newCounterSet(window, defaults.Resolution),
+		users:    newCounterSet(window, defaults.Resolution)}, nil
+}
+
+// Observe ... count the failed looking attempts of a client to log in, and
+// those against the username it tried, if known
+func (d *loginDetector) Observe(entry ndefenceLog.LogEntry) bool {
+
+	if !d.methods[strings.ToUpper(entry.Method)] ||
+		!d.isFailure(entry.Status) {
+		return false
+	}
+
+	pieces := strings.SplitN(entry.Path, "?", 2)
+	if !d.isEndpoint(pieces[0]) {
+		return false
+	}
+
+	attempts := d.attempts.add(entry.ClientIP, pieces[0], entry.Time)
+	urgent := d.threshold > 0 && attempts == d.threshold
+
+	// the username is taken from the query string, or else from basic
+	// authentication, since the request body is never logged
+	user := ""
+	if len(pieces) > 1 {
+		user = d.userOf(pieces[1])
+	}
+	if user == "" && entry.User != "" && entry.User != "-" {
+		user = strings.ToLower(entry.User)
+	}
+	if user != "" && d.userThreshold > 0 {
+		if d.users.add(user, entry.ClientIP, entry.Time) ==
+			d.userThreshold {
+			urgent = true
+		}
+	}
+
+	return urgent
+}
+
+// Evaluate ... find every client that failed to log in too often, or that
+// tried a username that failed too often
+func (d *loginDetector) Evaluate(ctx Context) []Finding {
+
+	// variable declaration
+	reasons := make(map[string][]string)
+
+	if d.threshold > 0 {
+
+		aggregated, _ := AggregateCounts(d.attempts.counts(ctx.Now))
+		for target, endpoints := range aggregated {
+
+			total := 0
+			for _, count := range endpoints {
+				total += count
+			}
+			if total < d.threshold {
+				continue
+			}
+
+			reasons[target] = append(reasons[target], fmt.Sprintf("%d "+
+				"failed logins within %s (%s)", total,
+				ndefenceUtils.FormatDuration(d.attempts.window),
+				ndefenceUtils.FormatCounts(endpoints)))
+		}
+	}
+
+	if d.userThreshold > 0 {
+
+		// usernames are sorted, so that the reasons read the same on
+		// every evaluation
+		byUser := d.users.counts(ctx.Now)
+		users := make([]string, 0, len(byUser))
+		for user := range byUser {
+			users = append(users, user)
+		}
+		sort.Strings(users)
+
+		for _, user := range users {
+
+			// the addresses trying the username, by network as blocked
+			clients := make(map[string]map[string]int)
+			total := 0
+			for ip, count := range byUser[user] {
+				target := BlockTarget(ip)
+				if clients[target] == nil {
+					clients[target] = make(map[string]int)
+				}
+				clients[target][ip] += count
+				total += count
+			}
+			if total < d.userThreshold {
+				continue
+			}
+
+			for target, ips := range clients {
+
+				attempts := 0
+				for _, count := range ips {
+					attempts += count
+				}
+				if attempts < d.userMinimum {
+					continue
+				}
+
+				reasons[target] = append(reasons[target], fmt.Sprintf(
+					"%d of %d failed logins as %q from %d %s within %s",
+					attempts, total, user, len(byUser[user]),
+					plural(len(byUser[user]), "address", "addresses"),
+					ndefenceUtils.FormatDuration(d.users.window)))
+			}
+		}
+	}
+
+	findings := make([]Finding, 0, len(reasons))
+	for target, pieces := range reasons {
+		findings = append(findings, Finding{IP: target, Rule: d.name,
+			Score: d.score, Duration: d.block,
+			Reason: strings.Join(pieces, ", ")})
+	}
+
+	return findings
+}
+
+// Prune ... discard the attempts that fell out of the window
+func (d *loginDetector) Prune(now time.Time) {
+	d.attempts.prune(now)
+	d.users.prune(now)
+}
+
+// Save ... encode the attempt counts
+func (d *loginDetector) Save() (json.RawMessage, error) {
+
+	// variable declaration
+	var snapshot loginSnapshot
+	var err error
+
+	if snapshot.Attempts, err = d.attempts.save(); err != nil {
+		return nil, err
+	}
+	if snapshot.Users, err = d.users.save(); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(snapshot)
+}
+
+// Restore ... decode previously saved attempt counts
+func (d *loginDetector) Restore(state json.RawMessage) error {
+
+	var snapshot loginSnapshot
+	if err := json.Unmarshal(state, &snapshot); err != nil {
+		return err
+	}
+
+	if len(snapshot.Attempts) > 0 {
+		if err := d.attempts.restore(snapshot.Attempts); err != nil {
+			return err
+		}
+	}
+	if len(snapshot.Users) > 0 {
+		if err := d.users.restore(snapshot.Users); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//! Whether a path is one of the authentication endpoints.
+/*
+ * @param     string    path, without its query string
+ *
+ * @return    bool      whether or not this is true
+ */
+func (d *loginDetector) isEndpoint(path string) bool {
+
+	for _, candidate := range d.paths {
+		if candidate.MatchString(path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//! Whether a status code looks like a failed attempt to log in.
+/*
+ * @param     int     HTTP status code
+ *
+ * @return    bool    whether or not this is true
+ */
+func (d *loginDetector) isFailure(status int) bool {
+
+	for _, candidate := range d.statuses {
+		if status == candidate {
+			return true
+		}
+	}
+
+	return false
+}
+
+//! Username given in a query string, if any.
+/*
+ * @param     string    query string, without the leading ?
+ *
+ * @return    string    username, lower case, or an empty string
+ */
+func (d *loginDetector) userOf(query string) string {
+
+	// a malformed query string still yields the fields before the flaw
+	values, _ := url.ParseQuery(query)
+
+	for _, field := range d.userFields {
+		if user := strings.TrimSpace(values.Get(field)); user != "" {
+			return strings.ToLower(user)
+		}
+	}
+
+	return ""
+}
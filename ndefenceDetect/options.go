@@ -0,0 +1,216 @@
+//
+// Options of the detectors of ndefence
+//
+
+package ndefenceDetect
+
+//
+// Imports
+//
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+// Options of a single detector, as given in the config file; lists are
+// held as comma separated strings
+//
+type Options map[string]string
+
+// Check ... ensure every option given is one the detector knows of
+/*
+ * @param     string...    names of the options known, besides "type" and
+ *                         "enabled"
+ *
+ * @return    error        error message naming the option, if any
+ */
+func (o Options) Check(known ...string) error {
+
+	for option := range o {
+
+		if option == "type" || option == "enabled" {
+			continue
+		}
+
+		found := false
+		for _, name := range known {
+			if option == name {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("unknown option: %s", option)
+		}
+	}
+
+	return nil
+}
+
+// Int ... value of an integer option
+/*
+ * @param     string    option name
+ * @param     int       value if the option is not given
+ *
+ * @return    int       value
+ * @return    error     error message, if any
+ */
+func (o Options) Int(name string, fallback int) (int, error) {
+
+	text, ok := o[name]
+	if !ok {
+		return fallback, nil
+	}
+
+	value, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("%s: expected a number of at least 0, got: %s",
+			name, text)
+	}
+
+	return value, nil
+}
+
+// Ints ... value of an option holding a list of integers
+/*
+ * @param     string    option name
+ * @param     int[]     value if the option is not given
+ *
+ * @return    int[]     value
+ * @return    error     error message, if any
+ */
+func (o Options) Ints(name string, fallback []int) ([]int, error) {
+
+	text, ok := o[name]
+	if !ok {
+		return fallback, nil
+	}
+
+	values := make([]int, 0)
+	for _, item := range strings.Split(text, ",") {
+
+		value, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil {
+			return nil, fmt.Errorf("%s: expected a list of numbers, got: "+
+				"%s", name, text)
+		}
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+// Float ... value of a decimal option
+/*
+ * @param     string     option name
+ * @param     float64    value if the option is not given
+ *
+ * @return    float64    value
+ * @return    error      error message, if any
+ */
+func (o Options) Float(name string, fallback float64) (float64, error) {
+
+	text, ok := o[name]
+	if !ok {
+		return fallback, nil
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("%s: expected a number of at least 0, got: %s",
+			name, text)
+	}
+
+	return value, nil
+}
+
+// Bool ... value of a true / false option
+/*
+ * @param     string    option name
+ * @param     bool      value if the option is not given
+ *
+ * @return    bool      value
+ * @return    error     error message, if any
+ */
+func (o Options) Bool(name string, fallback bool) (bool, error) {
+
+	text, ok := o[name]
+	if !ok {
+		return fallback, nil
+	}
+
+	value, err := strconv.ParseBool(strings.TrimSpace(text))
+	if err != nil {
+		return false, fmt.Errorf("%s: expected true or false, got: %s",
+			name, text)
+	}
+
+	return value, nil
+}
+
+// Duration ... value of a span of time option, e.g. "24h"
+/*
+ * @param     string      option name
+ * @param     duration    value if the option is not given
+ *
+ * @return    duration    value
+ * @return    error       error message, if any
+ */
+func (o Options) Duration(name string, fallback time.Duration) (
+	time.Duration, error) {
+
+	text, ok := o[name]
+	if !ok {
+		return fallback, nil
+	}
+
+	value, err := time.ParseDuration(strings.TrimSpace(text))
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("%s: expected a positive duration, e.g. "+
+			"\"24h\", got: %s", name, text)
+	}
+
+	return value, nil
+}
+
+// Scores ... value of a list of name=score pairs, e.g. ["sql=1", "path=0.5"];
+// the pairs given adjust the fallback ones, rather than replace them
+/*
+ * @param     string    option name
+ * @param     string    pairs if the option is not given, comma separated
+ *
+ * @return    map       map[name] = score
+ * @return    error     error message, if any
+ */
+func (o Options) Scores(name string, fallback string) (map[string]float64,
+	error) {
+
+	scores := make(map[string]float64)
+	for _, item := range strings.Split(fallback+","+o[name], ",") {
+
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		pair := strings.SplitN(item, "=", 2)
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("%s: expected name=score, e.g. sql=1, "+
+				"got: %s", name, item)
+		}
+
+		score, err := strconv.ParseFloat(strings.TrimSpace(pair[1]), 64)
+		if err != nil || score < 0 {
+			return nil, fmt.Errorf("%s: expected a score of at least 0, "+
+				"got: %s", name, pair[1])
+		}
+
+		scores[strings.TrimSpace(pair[0])] = score
+	}
+
+	return scores, nil
+}
@@ -0,0 +1,345 @@
+//
+// Request rate detector of ndefence
+//
+
+package ndefenceDetect
+
+//
+// Imports
+//
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rbisewski/ndefence/ndefenceLog"
+	"github.com/rbisewski/ndefence/ndefenceStats"
+	"github.com/rbisewski/ndefence/ndefenceUtils"
+)
+
+//
+// Globals
+//
+var (
+
+	// Request rate limits, unless others are given; a page load fetching
+	// its images and scripts easily makes dozens of requests at once, so
+	// only the short windows are tight
+	DefaultRateLimits = "10s=100,1m=300,10m=1200"
+
+	// Number of clients whose rates are tracked at once, unless another
+	// number is given; the least recently seen ones are forgotten first
+	DefaultRateClients = 100000
+
+	// Number of buckets each window is split into
+	rateBuckets = 10
+)
+
+//
+// Request rate limit object definition
+//
+type rateLimit struct {
+
+	// Span of time the requests are counted over
+	Window time.Duration
+
+	// Number of requests within the window above which a client is blocked
+	Max int
+}
+
+//
+// Detector of clients that made requests faster than the limits allow
+//
+type rateDetector struct {
+	name   string
+	limits []rateLimit
+	score  float64
+	block  time.Duration
+
+	// Clients being tracked, most recently seen first, along with where
+	// each of them is in the list
+	order      *list.List
+	clients    map[string]*list.Element
+	maxClients int
+
+	// Worst rate of every client that exceeded a limit, kept for the
+	// general block window
+	triggers map[string]rateTrigger
+	keep     time.Duration
+}
+
+//
+// Rates of a single client
+//
+type rateClient struct {
+	ip       string
+	lastSeen time.Time
+	counters []*ndefenceStats.SlidingCounter
+}
+
+//
+// Worst rate of a client that exceeded a limit
+//
+type rateTrigger struct {
+	Time   time.Time     `json:"time"`
+	Count  int           `json:"count"`
+	Window time.Duration `json:"window"`
+	Max    int           `json:"max"`
+}
+
+//! Assemble a rate detector; options are "limits", a list of window=max
+//! pairs, e.g. ["10s=100", "1m=300"], "clients", the number of clients
+//! tracked at once, "block", how long to block for, and "score".
+/*
+ * @param     string      name of the detector
+ * @param     Options     options
+ * @param     Defaults    general settings
+ *
+ * @return    Detector    detector
+ * @return    error       error message, if any
+ */
+func newRateDetector(name string, options Options,
+	defaults Defaults) (Detector, error) {
+
+	err := options.Check("limits", "clients", "block", "score")
+	if err != nil {
+		return nil, err
+	}
+
+	text, ok := options["limits"]
+	if !ok {
+		text = DefaultRateLimits
+	}
+	limits, err := parseRateLimits(text)
+	if err != nil {
+		return nil, err
+	}
+
+	maxClients, err := options.Int("clients", DefaultRateClients)
+	if err != nil {
+		return nil, err
+	}
+	if maxClients < 1 {
+		return nil, fmt.Errorf("clients: must be at least 1")
+	}
+
+	block, err := options.Duration("block", 0)
+	if err != nil {
+		return nil, err
+	}
+	score, err := options.Float("score", BlockScore)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rateDetector{name: name, limits: limits, score: score,
+		block: block, order: list.New(),
+		clients:    make(map[string]*list.Element),
+		maxClients: maxClients,
+		triggers:   make(map[string]rateTrigger),
+		keep:       defaults.Window}, nil
+}
+
+// Observe ... count a request of a client in every window; exceeding a
+// limit is noted right away, since a burst is usually over long before
+// the findings are evaluated
+func (d *rateDetector) Observe(entry ndefenceLog.LogEntry) bool {
+
+	client := d.touch(entry.ClientIP, entry.Time)
+	urgent := false
+
+	for i, limit := range d.limits {
+
+		counter := client.counters[i]
+		counter.Add(entry.Time, 1)
+		counter.Prune(entry.Time)
+
+		count := counter.CountWithin(entry.Time, limit.Window)
+		if count <= limit.Max {
+			continue
+		}
+		if count == limit.Max+1 {
+			urgent = true
+		}
+
+		// keep the worst rate, relative to its limit
+		trigger, ok := d.triggers[entry.ClientIP]
+		if !ok || count*trigger.Max > trigger.Count*limit.Max {
+			trigger = rateTrigger{Count: count, Window: limit.Window,
+				Max: limit.Max}
+		}
+		if entry.Time.After(trigger.Time) {
+			trigger.Time = entry.Time
+		}
+		d.triggers[entry.ClientIP] = trigger
+	}
+
+	return urgent
+}
+
+// Evaluate ... find every client that exceeded a limit within the block
+// window
+func (d *rateDetector) Evaluate(ctx Context) []Finding {
+
+	// variable declaration
+	since := ctx.Now.Add(-d.keep)
+	worst := make(map[string]rateTrigger)
+
+	for ip, trigger := range d.triggers {
+
+		if trigger.Time.Before(since) {
+			continue
+		}
+
+		target := BlockTarget(ip)
+		current, ok := worst[target]
+		if !ok || trigger.Count*current.Max > current.Count*trigger.Max {
+			worst[target] = trigger
+		}
+	}
+
+	findings := make([]Finding, 0, len(worst))
+	for target, trigger := range worst {
+		findings = append(findings, Finding{IP: target, Rule: d.name,
+			Score: d.score, Duration: d.block,
+			Reason: fmt.Sprintf("%d requests within %s (limit %d)",
+				trigger.Count, ndefenceUtils.FormatDuration(trigger.Window),
+				trigger.Max)})
+	}
+
+	return findings
+}
+
+// Prune ... forget the clients not seen within the longest window, and the
+// triggers that fell out of the block window
+func (d *rateDetector) Prune(now time.Time) {
+
+	longest := d.limits[len(d.limits)-1].Window
+	for element := d.order.Back(); element != nil; {
+
+		client := element.Value.(*rateClient)
+		if !client.lastSeen.Before(now.Add(-longest)) {
+			break
+		}
+
+		previous := element.Prev()
+		d.order.Remove(element)
+		delete(d.clients, client.ip)
+		element = previous
+	}
+
+	since := now.Add(-d.keep)
+	for ip, trigger := range d.triggers {
+		if trigger.Time.Before(since) {
+			delete(d.triggers, ip)
+		}
+	}
+}
+
+// Save ... encode the triggers; the rates themselves cover a few minutes at
+// most, so they are not worth keeping between runs
+func (d *rateDetector) Save() (json.RawMessage, error) {
+	return json.Marshal(d.triggers)
+}
+
+// Restore ... decode previously saved triggers
+func (d *rateDetector) Restore(state json.RawMessage) error {
+
+	triggers := make(map[string]rateTrigger)
+	if err := json.Unmarshal(state, &triggers); err != nil {
+		return err
+	}
+
+	d.triggers = triggers
+	return nil
+}
+
+//! Obtain the rates of a client, tracking it if needed, and mark it as the
+//! most recently seen; the least recently seen client is forgotten once
+//! too many are tracked.
+/*
+ * @param     string         IP address
+ * @param     time.Time      time of the request
+ *
+ * @return    *rateClient    rates of the client
+ */
+func (d *rateDetector) touch(ip string, t time.Time) *rateClient {
+
+	if element, ok := d.clients[ip]; ok {
+		client := element.Value.(*rateClient)
+		if t.After(client.lastSeen) {
+			client.lastSeen = t
+		}
+		d.order.MoveToFront(element)
+		return client
+	}
+
+	client := &rateClient{ip: ip, lastSeen: t,
+		counters: make([]*ndefenceStats.SlidingCounter, len(d.limits))}
+	for i, limit := range d.limits {
+		client.counters[i] = ndefenceStats.NewSlidingCounter(limit.Window,
+			limit.Window/time.Duration(rateBuckets))
+	}
+	d.clients[ip] = d.order.PushFront(client)
+
+	for d.order.Len() > d.maxClients {
+		oldest := d.order.Back()
+		d.order.Remove(oldest)
+		delete(d.clients, oldest.Value.(*rateClient).ip)
+	}
+
+	return client
+}
+
+//! Parse a list of request rate limits, e.g. "10s=100,1m=300".
+/*
+ * @param     string         comma separated window=max pairs
+ *
+ * @return    rateLimit[]    limits, shortest window first
+ * @return    error          error message, if any
+ */
+func parseRateLimits(text string) ([]rateLimit, error) {
+
+	limits := make([]rateLimit, 0)
+	for _, item := range strings.Split(text, ",") {
+
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		pair := strings.SplitN(item, "=", 2)
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("limits: expected window=max, e.g. "+
+				"10s=100, got: %s", item)
+		}
+
+		window, err := time.ParseDuration(strings.TrimSpace(pair[0]))
+		if err != nil || window < time.Second {
+			return nil, fmt.Errorf("limits: expected a window of at "+
+				"least 1s, got: %s", pair[0])
+		}
+
+		max, err := strconv.Atoi(strings.TrimSpace(pair[1]))
+		if err != nil || max < 1 {
+			return nil, fmt.Errorf("limits: expected a maximum of at "+
+				"least 1, got: %s", pair[1])
+		}
+
+		limits = append(limits, rateLimit{Window: window, Max: max})
+	}
+
+	if len(limits) < 1 {
+		return nil, fmt.Errorf("limits: at least one limit is needed")
+	}
+
+	sort.Slice(limits, func(i, j int) bool {
+		return limits[i].Window < limits[j].Window
+	})
+
+	return limits, nil
+}
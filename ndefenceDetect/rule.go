@@ -0,0 +1,924 @@
+//
+// Declarative rules of ndefence, e.g.
+//
+//   path ~ "^/wp-login.php" and status in [200,401] count > 10 within 5m
+//     => block 24h
+//
+
+package ndefenceDetect
+
+//
+// Imports
+//
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rbisewski/ndefence/ndefenceHostname"
+	"github.com/rbisewski/ndefence/ndefenceLog"
+	"github.com/rbisewski/ndefence/ndefenceUtils"
+)
+
+//
+// Globals
+//
+var (
+
+	// Fields of an access log entry that a rule may refer to
+	ruleFields = map[string]func(in *ruleInput) string{
+		"ip":       func(in *ruleInput) string { return in.entry.ClientIP },
+		"path":     func(in *ruleInput) string { return in.entry.Path },
+		"method":   func(in *ruleInput) string { return in.entry.Method },
+		"status":   func(in *ruleInput) string { return strconv.Itoa(in.entry.Status) },
+		"ua":       func(in *ruleInput) string { return in.entry.UserAgent },
+		"agent":    func(in *ruleInput) string { return in.entry.Agent },
+		"referer":  func(in *ruleInput) string { return in.entry.Referer },
+		"location": func(in *ruleInput) string { return in.entry.Location },
+		"redirect": func(in *ruleInput) string { return in.entry.Redirect },
+		"country":  func(in *ruleInput) string { return in.country },
+		"site":     func(in *ruleInput) string { return in.entry.Site },
+	}
+)
+
+//
+// Rule object definition
+//
+type Rule struct {
+
+	// Condition an entry must meet to be counted; nil matches every entry
+	condition ruleCondition
+
+	// Number of matching requests, within the window, at which the rule
+	// blocks an address
+	Minimum int
+
+	// Span of time over which matching requests are counted; zero for the
+	// general block window
+	Window time.Duration
+
+	// How long the rule blocks an address for; zero for the general expiry
+	Block time.Duration
+
+	// Country codes the condition compares against, if any
+	countries map[string]bool
+}
+
+//
+// Entry being matched against a rule, along with its enrichment
+//
+type ruleInput struct {
+	entry   *ndefenceLog.LogEntry
+	country string
+}
+
+//
+// Compiled condition of a rule
+//
+type ruleCondition func(in *ruleInput) bool
+
+//
+// Single token of a rule
+//
+type ruleToken struct {
+	kind string
+	text string
+	pos  int
+}
+
+//
+// Rule parser object definition
+//
+type ruleParser struct {
+	tokens    []ruleToken
+	pos       int
+	countries map[string]bool
+}
+
+// ParseRule ... parse and validate a rule of the form
+// "[condition] [count > N [within DURATION]] => block [DURATION]", where
+// the condition compares the fields ip, path, method, status, ua, agent,
+// i.e. the class of the user agent, referer, location, redirect, i.e. the
+// class of the redirect, country and site using ~, !~, ==, !=, <, <=, >,
+// >=, in and not in, combined with and, or, not and parentheses
+/*
+ * @param     string    rule text
+ *
+ * @return    *Rule     compiled rule
+ * @return    error     error message naming the column, if any
+ */
+func ParseRule(text string) (*Rule, error) {
+
+	tokens, err := lexRule(text)
+	if err != nil {
+		return nil, fmt.Errorf("ParseRule() --> %s", err)
+	}
+
+	p := &ruleParser{tokens: tokens, countries: make(map[string]bool)}
+	rule, err := p.parseRule()
+	if err != nil {
+		return nil, fmt.Errorf("ParseRule() --> %s", err)
+	}
+
+	return rule, nil
+}
+
+// Matches ... whether an entry meets the condition of the rule
+/*
+ * @param     LogEntry    parsed entry
+ * @param     string      country code of the client, if known
+ *
+ * @return    bool        whether or not this is true
+ */
+func (r *Rule) Matches(entry ndefenceLog.LogEntry, country string) bool {
+
+	if r.condition == nil {
+		return true
+	}
+
+	return r.condition(&ruleInput{entry: &entry, country: country})
+}
+
+// Countries ... the country codes the condition compares against, sorted;
+// every other country, known or not, matches the same way as ""
+/*
+ * @return    string[]    country codes
+ */
+func (r *Rule) Countries() []string {
+
+	codes := make([]string, 0, len(r.countries))
+	for code := range r.countries {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	return codes
+}
+
+//! Split a rule into tokens.
+/*
+ * @param     string         rule text
+ *
+ * @return    ruleToken[]    tokens, ending with an "end" token
+ * @return    error          error message, if any
+ */
+func lexRule(text string) ([]ruleToken, error) {
+
+	// variable declaration
+	tokens := make([]ruleToken, 0)
+	isWordChar := func(c byte) bool {
+		return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+			c >= '0' && c <= '9' || c == '_' || c == '.'
+	}
+
+	for i := 0; i < len(text); {
+
+		c := text[i]
+		switch {
+
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		// strings, in which a backslash only escapes a quote or another
+		// backslash, so that regexes need no doubled backslashes
+		case c == '"' || c == '\'':
+			var value strings.Builder
+			j := i + 1
+			for ; j < len(text) && text[j] != c; j++ {
+				if text[j] == '\\' && j+1 < len(text) &&
+					(text[j+1] == c || text[j+1] == '\\') {
+					j++
+				}
+				value.WriteByte(text[j])
+			}
+			if j >= len(text) {
+				return nil, fmt.Errorf("col %d: unterminated string", i+1)
+			}
+			tokens = append(tokens, ruleToken{"string", value.String(), i})
+			i = j + 1
+
+		// numbers and durations, e.g. 401, 5m or 1h30m
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(text) && isWordChar(text[j]) {
+				j++
+			}
+			tokens = append(tokens, ruleToken{"number", text[i:j], i})
+			i = j
+
+		case isWordChar(c):
+			j := i
+			for j < len(text) && isWordChar(text[j]) {
+				j++
+			}
+			tokens = append(tokens, ruleToken{"word",
+				strings.ToLower(text[i:j]), i})
+			i = j
+
+		default:
+			operator := ""
+			for _, candidate := range []string{"=>", "==", "!=", "!~",
+				"<=", ">=", "~", "<", ">", "(", ")", "[", "]", ","} {
+				if strings.HasPrefix(text[i:], candidate) {
+					operator = candidate
+					break
+				}
+			}
+			if operator == "" {
+				return nil, fmt.Errorf("col %d: unexpected character: %c",
+					i+1, c)
+			}
+			tokens = append(tokens, ruleToken{"operator", operator, i})
+			i += len(operator)
+		}
+	}
+
+	return append(tokens, ruleToken{"end", "", len(text)}), nil
+}
+
+//! Current token.
+func (p *ruleParser) peek() ruleToken {
+	return p.tokens[p.pos]
+}
+
+//! Consume the current token.
+func (p *ruleParser) next() ruleToken {
+	token := p.tokens[p.pos]
+	if token.kind != "end" {
+		p.pos++
+	}
+	return token
+}
+
+//! Whether the current token is the given keyword or operator.
+func (p *ruleParser) is(text string) bool {
+	token := p.peek()
+	return (token.kind == "word" || token.kind == "operator") &&
+		token.text == text
+}
+
+//! Describe an unexpected token.
+func (p *ruleParser) unexpected(token ruleToken, expected string) error {
+
+	found := "\"" + token.text + "\""
+	if token.kind == "end" {
+		found = "the end of the rule"
+	}
+
+	return fmt.Errorf("col %d: expected %s, found %s", token.pos+1,
+		expected, found)
+}
+
+//! Consume the given keyword or operator.
+func (p *ruleParser) expect(text string) error {
+
+	if !p.is(text) {
+		return p.unexpected(p.peek(), "\""+text+"\"")
+	}
+	p.next()
+
+	return nil
+}
+
+//! Parse a whole rule.
+/*
+ * @return    *Rule    compiled rule
+ * @return    error    error message, if any
+ */
+func (p *ruleParser) parseRule() (*Rule, error) {
+
+	rule := &Rule{Minimum: 1}
+
+	if !p.is("count") && !p.is("=>") {
+		condition, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		rule.condition = condition
+	}
+
+	// count > N, count >= N
+	if p.is("count") {
+
+		p.next()
+		operator := p.next()
+		if operator.text != ">" && operator.text != ">=" {
+			return nil, p.unexpected(operator, "> or >=")
+		}
+
+		number := p.next()
+		count, err := strconv.Atoi(number.text)
+		if number.kind != "number" || err != nil || count < 0 {
+			return nil, p.unexpected(number, "a number")
+		}
+
+		rule.Minimum = count
+		if operator.text == ">" {
+			rule.Minimum++
+		}
+		if rule.Minimum < 1 {
+			rule.Minimum = 1
+		}
+
+		if p.is("within") {
+			p.next()
+			rule.Window, err = p.parseDuration()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := p.expect("=>"); err != nil {
+		return nil, err
+	}
+	if err := p.expect("block"); err != nil {
+		return nil, err
+	}
+
+	if p.peek().kind != "end" {
+		duration, err := p.parseDuration()
+		if err != nil {
+			return nil, err
+		}
+		rule.Block = duration
+	}
+
+	if token := p.peek(); token.kind != "end" {
+		return nil, p.unexpected(token, "the end of the rule")
+	}
+
+	rule.countries = p.countries
+	return rule, nil
+}
+
+//! Parse a duration, e.g. 5m, 1h30m or 7d.
+/*
+ * @return    duration    span of time
+ * @return    error       error message, if any
+ */
+func (p *ruleParser) parseDuration() (time.Duration, error) {
+
+	token := p.next()
+	if token.kind != "number" {
+		return 0, p.unexpected(token, "a duration, e.g. 5m or 24h")
+	}
+
+	// days are not understood by time.ParseDuration
+	if days := strings.TrimSuffix(token.text, "d"); days != token.text {
+		if count, err := strconv.Atoi(days); err == nil && count > 0 {
+			return time.Duration(count) * 24 * time.Hour, nil
+		}
+	}
+
+	duration, err := time.ParseDuration(token.text)
+	if err != nil || duration <= 0 {
+		return 0, p.unexpected(token, "a duration, e.g. 5m or 24h")
+	}
+
+	return duration, nil
+}
+
+//! Parse conditions combined with "or".
+func (p *ruleParser) parseOr() (ruleCondition, error) {
+
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.is("or") {
+
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		a, b := left, right
+		left = func(in *ruleInput) bool { return a(in) || b(in) }
+	}
+
+	return left, nil
+}
+
+//! Parse conditions combined with "and".
+func (p *ruleParser) parseAnd() (ruleCondition, error) {
+
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.is("and") {
+
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		a, b := left, right
+		left = func(in *ruleInput) bool { return a(in) && b(in) }
+	}
+
+	return left, nil
+}
+
+//! Parse a negated or parenthesized condition, or a comparison.
+func (p *ruleParser) parseUnary() (ruleCondition, error) {
+
+	if p.is("not") {
+
+		p.next()
+		condition, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return func(in *ruleInput) bool { return !condition(in) }, nil
+	}
+
+	if p.is("(") {
+
+		p.next()
+		condition, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+
+		return condition, nil
+	}
+
+	return p.parseComparison()
+}
+
+//! Parse a comparison of a field, e.g. status in [200, 401].
+func (p *ruleParser) parseComparison() (ruleCondition, error) {
+
+	// the field
+	token := p.next()
+	field, ok := ruleFields[token.text]
+	if token.kind != "word" || !ok {
+		names := make([]string, 0, len(ruleFields))
+		for name := range ruleFields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, p.unexpected(token, "a field ("+
+			strings.Join(names, ", ")+")")
+	}
+	name := token.text
+
+	// the operator, where "not in" is two words
+	operatorToken := p.next()
+	operator := operatorToken.text
+	switch {
+	case operatorToken.kind == "operator" && operator != "=>" &&
+		strings.ContainsAny(operator, "=!~<>"):
+	case operatorToken.kind == "word" && operator == "in":
+	case operatorToken.kind == "word" && operator == "not" && p.is("in"):
+		p.next()
+		operator = "not in"
+	default:
+		return nil, p.unexpected(operatorToken, "a comparison (~, !~, "+
+			"==, !=, <, <=, >, >=, in or not in)")
+	}
+
+	// the value, or list of values
+	values := make([]ruleToken, 0)
+	if operator == "in" || operator == "not in" {
+
+		if err := p.expect("["); err != nil {
+			return nil, err
+		}
+		for {
+			value := p.next()
+			if value.kind != "string" && value.kind != "number" {
+				return nil, p.unexpected(value, "a value")
+			}
+			values = append(values, value)
+
+			if p.is("]") {
+				p.next()
+				break
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+
+	} else {
+
+		value := p.next()
+		if value.kind != "string" && value.kind != "number" {
+			return nil, p.unexpected(value, "a value")
+		}
+		values = append(values, value)
+	}
+
+	condition, err := p.compileComparison(name, field, operator, values)
+	if err != nil {
+		return nil, fmt.Errorf("col %d: %s", token.pos+1, err)
+	}
+
+	return condition, nil
+}
+
+//! Compile a comparison into a condition.
+/*
+ * @param     string         field name
+ * @param     func           accessor of the field
+ * @param     string         operator
+ * @param     ruleToken[]    values
+ *
+ * @return    ruleCondition  compiled condition
+ * @return    error          error message, if any
+ */
+func (p *ruleParser) compileComparison(name string,
+	field func(in *ruleInput) string, operator string,
+	values []ruleToken) (ruleCondition, error) {
+
+	// variable declaration
+	negate := operator == "!=" || operator == "!~" || operator == "not in"
+	var condition ruleCondition
+
+	switch {
+
+	// regexes apply to the text of any field, except the country
+	case operator == "~" || operator == "!~":
+		if name == "country" {
+			return nil, fmt.Errorf("country only supports ==, !=, in " +
+				"and not in")
+		}
+		re, err := regexp.Compile(values[0].text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %s", values[0].text)
+		}
+		condition = func(in *ruleInput) bool {
+			return re.MatchString(field(in))
+		}
+
+	// numeric comparisons only apply to the status
+	case strings.ContainsAny(operator, "<>"):
+		if name != "status" {
+			return nil, fmt.Errorf("%s only applies to status", operator)
+		}
+		limit, err := strconv.Atoi(values[0].text)
+		if err != nil {
+			return nil, fmt.Errorf("expected a number, got: %s",
+				values[0].text)
+		}
+		condition = func(in *ruleInput) bool {
+			switch operator {
+			case "<":
+				return in.entry.Status < limit
+			case "<=":
+				return in.entry.Status <= limit
+			case ">":
+				return in.entry.Status > limit
+			}
+			return in.entry.Status >= limit
+		}
+
+	// addresses match the networks given, e.g. ip in ["10.0.0.0/8"]
+	case name == "ip":
+		prefixes := make([]netip.Prefix, 0, len(values))
+		for _, value := range values {
+			prefix, err := parseRulePrefix(value.text)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix)
+		}
+		condition = func(in *ruleInput) bool {
+			addr, err := ndefenceUtils.ParseIPAddress(in.entry.ClientIP)
+			if err != nil {
+				return false
+			}
+			for _, prefix := range prefixes {
+				if prefix.Contains(addr) {
+					return true
+				}
+			}
+			return false
+		}
+
+	// anything else is compared as text
+	default:
+		set := make(map[string]bool)
+		for _, value := range values {
+
+			text := value.text
+			switch name {
+			case "status":
+				if _, err := strconv.Atoi(text); err != nil {
+					return nil, fmt.Errorf("expected a status code, got: "+
+						"%s", text)
+				}
+			case "method":
+				text = strings.ToUpper(text)
+			case "country":
+				code, err := ndefenceHostname.NormalizeCountryCode(text)
+				if err != nil {
+					return nil, fmt.Errorf("not an ISO 3166 country "+
+						"code: %s", text)
+				}
+				text = code
+				p.countries[code] = true
+			}
+			set[text] = true
+		}
+		condition = func(in *ruleInput) bool {
+			return set[field(in)]
+		}
+	}
+
+	if negate {
+		positive := condition
+		condition = func(in *ruleInput) bool { return !positive(in) }
+	}
+
+	return condition, nil
+}
+
+//! Parse an address or network of a rule.
+/*
+ * @param     string          address or network, e.g. 10.0.0.0/8
+ *
+ * @return    netip.Prefix    network; an address becomes a /32 or a /128
+ * @return    error           error message, if any
+ */
+func parseRulePrefix(text string) (netip.Prefix, error) {
+
+	if prefix, err := netip.ParsePrefix(text); err == nil {
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(),
+				prefix.Bits()-96)
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := ndefenceUtils.ParseIPAddress(text)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid address or network: "+
+			"%s", text)
+	}
+
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+//
+// Detector of clients that made too many requests matching a rule
+//
+type ruleDetector struct {
+	name      string
+	rule      *Rule
+	countries []string
+	score     float64
+	events    *counterSet
+
+	// Times at which an address, by country, last had at least as many
+	// matching requests as the minimum, kept for the general block window;
+	// a short window may otherwise have passed by the time the findings
+	// are evaluated, e.g. when a whole day of logs is read at once
+	triggers map[string]map[string]ruleTrigger
+	keep     time.Duration
+}
+
+//
+// Time at which a rule was triggered, along with the highest count seen
+//
+type ruleTrigger struct {
+	Time  time.Time `json:"time"`
+	Count int       `json:"count"`
+}
+
+//
+// Persisted form of a rule detector
+//
+type ruleSnapshot struct {
+	Events   json.RawMessage                   `json:"events"`
+	Triggers map[string]map[string]ruleTrigger `json:"triggers"`
+}
+
+//! Assemble a rule detector; options are "rule", the rule itself, and
+//! "score".
+/*
+ * @param     string      name of the detector
+ * @param     Options     options
+ * @param     Defaults    general settings
+ *
+ * @return    Detector    detector
+ * @return    error       error message, if any
+ */
+func newRuleDetector(name string, options Options,
+	defaults Defaults) (Detector, error) {
+
+	if err := options.Check("rule", "score"); err != nil {
+		return nil, err
+	}
+
+	text, ok := options["rule"]
+	if !ok {
+		return nil, fmt.Errorf("missing option: rule")
+	}
+	rule, err := ParseRule(text)
+	if err != nil {
+		return nil, err
+	}
+	if rule.Window == 0 {
+		rule.Window = defaults.Window
+	}
+
+	score, err := options.Float("score", BlockScore)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ruleDetector{name: name, rule: rule,
+		countries: append(rule.Countries(), ""), score: score,
+		events:   newCounterSet(rule.Window, defaults.Resolution),
+		triggers: make(map[string]map[string]ruleTrigger),
+		keep:     defaults.Window}, nil
+}
+
+// Observe ... count the requests matching the rule; since the country of a
+// client is only known later on, rules comparing countries count every
+// request once for each country it would match as
+func (d *ruleDetector) Observe(entry ndefenceLog.LogEntry) bool {
+
+	urgent := false
+	for _, country := range d.countries {
+
+		if !d.rule.Matches(entry, country) {
+			continue
+		}
+
+		d.events.add(entry.ClientIP, country, entry.Time)
+		count := d.events.count(entry.ClientIP, country, entry.Time)
+		if count < d.rule.Minimum {
+			continue
+		}
+		if count == d.rule.Minimum {
+			urgent = true
+		}
+
+		// note that the rule was triggered
+		triggers, ok := d.triggers[entry.ClientIP]
+		if !ok {
+			triggers = make(map[string]ruleTrigger)
+			d.triggers[entry.ClientIP] = triggers
+		}
+		trigger := triggers[country]
+		if entry.Time.After(trigger.Time) {
+			trigger.Time = entry.Time
+		}
+		if count > trigger.Count {
+			trigger.Count = count
+		}
+		triggers[country] = trigger
+	}
+
+	return urgent
+}
+
+// Evaluate ... find every client whose requests matching the rule reached
+// the minimum, either now or at any time within the block window
+func (d *ruleDetector) Evaluate(ctx Context) []Finding {
+
+	// variable declaration
+	matching := make(map[string]map[string]int)
+	triggered := make(map[string]int)
+	since := ctx.Now.Add(-d.keep)
+
+	// pick the count matching the country of every address
+	countryOf := func(ip string) string {
+		country, err := ndefenceHostname.NormalizeCountryCode(
+			ctx.Countries[ip])
+		if err != nil || !d.rule.countries[country] {
+			return ""
+		}
+		return country
+	}
+
+	for ip, countries := range d.events.counts(ctx.Now) {
+		if count := countries[countryOf(ip)]; count > 0 {
+			matching[ip] = map[string]int{"": count}
+		}
+	}
+
+	for ip, triggers := range d.triggers {
+		trigger, ok := triggers[countryOf(ip)]
+		if !ok || trigger.Time.Before(since) {
+			continue
+		}
+
+		target := BlockTarget(ip)
+		if trigger.Count > triggered[target] {
+			triggered[target] = trigger.Count
+		}
+	}
+
+	// the requests of the addresses blocked together count together
+	aggregated, _ := AggregateCounts(matching)
+	for target, counts := range aggregated {
+		if counts[""] >= d.rule.Minimum && counts[""] > triggered[target] {
+			triggered[target] = counts[""]
+		}
+	}
+
+	findings := make([]Finding, 0)
+	for target, count := range triggered {
+		findings = append(findings, Finding{IP: target, Rule: d.name,
+			Score: d.score, Duration: d.rule.Block,
+			Reason: fmt.Sprintf("%d matching requests within %s", count,
+				ndefenceUtils.FormatDuration(d.rule.Window))})
+	}
+
+	return findings
+}
+
+// Addresses ... the addresses counted within the window, or triggered
+// within the block window, if the rule compares countries, since their
+// countries are needed even once they left the report window
+func (d *ruleDetector) Addresses(now time.Time) []string {
+
+	if len(d.rule.countries) < 1 {
+		return nil
+	}
+
+	addresses := d.events.addresses(now)
+	counted := make(map[string]bool, len(addresses))
+	for _, ip := range addresses {
+		counted[ip] = true
+	}
+
+	since := now.Add(-d.keep)
+	for ip, triggers := range d.triggers {
+		for _, trigger := range triggers {
+			if !counted[ip] && !trigger.Time.Before(since) {
+				counted[ip] = true
+				addresses = append(addresses, ip)
+			}
+		}
+	}
+
+	return addresses
+}
+
+// Prune ... discard the requests that fell out of the window, and the
+// triggers that fell out of the block window
+func (d *ruleDetector) Prune(now time.Time) {
+
+	d.events.prune(now)
+
+	since := now.Add(-d.keep)
+	for ip, triggers := range d.triggers {
+		for country, trigger := range triggers {
+			if trigger.Time.Before(since) {
+				delete(triggers, country)
+			}
+		}
+		if len(triggers) == 0 {
+			delete(d.triggers, ip)
+		}
+	}
+}
+
+// Save ... encode the request counts and triggers
+func (d *ruleDetector) Save() (json.RawMessage, error) {
+
+	events, err := d.events.save()
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(ruleSnapshot{Events: events, Triggers: d.triggers})
+}
+
+// Restore ... decode previously saved request counts and triggers
+func (d *ruleDetector) Restore(state json.RawMessage) error {
+
+	snapshot := ruleSnapshot{}
+	if err := json.Unmarshal(state, &snapshot); err != nil {
+		return err
+	}
+
+	if snapshot.Triggers != nil {
+		d.triggers = snapshot.Triggers
+	}
+	if len(snapshot.Events) == 0 {
+		return nil
+	}
+
+	return d.events.restore(snapshot.Events)
+}
@@ -0,0 +1,190 @@
+//
+// Tests of the declarative rules of ndefence
+//
+
+package ndefenceDetect
+
+//
+// Imports
+//
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rbisewski/ndefence/ndefenceLog"
+)
+
+// TestParseRule ... the parts of a rule outside of its condition
+func TestParseRule(t *testing.T) {
+
+	tests := []struct {
+		text    string
+		minimum int
+		window  time.Duration
+		block   time.Duration
+	}{
+		{"=> block", 1, 0, 0},
+		{"path ~ '^/admin' => block 24h", 1, 0, 24 * time.Hour},
+		{"count > 10 within 5m => block", 11, 5 * time.Minute, 0},
+		{"status >= 400 count >= 10 => block 7d", 10, 0,
+			7 * 24 * time.Hour},
+		{"count > 0 within 1h30m => block 15m", 1, 90 * time.Minute,
+			15 * time.Minute},
+		{"COUNT > 2 WITHIN 1m => BLOCK", 3, time.Minute, 0},
+	}
+
+	for _, test := range tests {
+
+		rule, err := ParseRule(test.text)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.text, err)
+			continue
+		}
+
+		if rule.Minimum != test.minimum || rule.Window != test.window ||
+			rule.Block != test.block {
+			t.Errorf("%s: expected count %d within %s, block %s, got: "+
+				"count %d within %s, block %s", test.text, test.minimum,
+				test.window, test.block, rule.Minimum, rule.Window,
+				rule.Block)
+		}
+	}
+}
+
+// TestRuleMatches ... conditions compare the fields of an entry as
+// documented
+func TestRuleMatches(t *testing.T) {
+
+	// variable declaration
+	login := ndefenceLog.LogEntry{ClientIP: "10.1.2.3", Method: "POST",
+		Path: "/wp-login.php", Status: 200, UserAgent: "sqlmap/1.7",
+		Agent: "scanner", Referer: "-", Site: "blog"}
+	page := ndefenceLog.LogEntry{ClientIP: "2001:db8::1", Method: "GET",
+		Path: "/index.html", Status: 404, UserAgent: "Mozilla/5.0 (X11)",
+		Agent: "browser", Referer: "https://example.com/", Site: "shop",
+		Location: "https://evil.example/", Redirect: "open"}
+
+	tests := []struct {
+		text    string
+		country string
+		login   bool
+		page    bool
+	}{
+		// quoted strings, where a backslash escapes the quote alone
+		{`path == "/wp-login.php" => block`, "", true, false},
+		{`path ~ '^/wp-login\.php$' => block`, "", true, false},
+		{`ua == 'it\'s' => block`, "", false, false},
+		{`referer ~ "^https://example\.com/" => block`, "", false, true},
+
+		// numbers, statuses and methods
+		{"status < 300 => block", "", true, false},
+		{"status != 404 => block", "", true, false},
+		{"status in [401, 403, 404] => block", "", false, true},
+		{"method == 'post' => block", "", true, false},
+
+		// lists spanning lines
+		{"site not in [\n  'shop',\n  \"admin\"\n] => block", "", true,
+			false},
+
+		// networks and addresses of either family
+		{"ip in ['10.0.0.0/8'] => block", "", true, false},
+		{"ip in ['2001:db8::/32', '10.1.2.3'] => block", "", true, true},
+		{"ip == '::ffff:10.1.2.3' => block", "", true, false},
+
+		// classes of user agents and redirects, and countries
+		{"agent == 'scanner' or redirect == 'open' => block", "", true,
+			true},
+		{"location ~ 'evil' => block", "", false, true},
+		{"country in ['cn', 'RU'] => block", "CN", true, true},
+		{"country != 'cn' => block", "", true, true},
+
+		// precedence of not, and, or and parentheses
+		{"not status == 200 and site == 'shop' => block", "", false, true},
+		{"site == 'blog' or site == 'shop' and status == 200 => block", "",
+			true, false},
+		{"(site == 'blog' or site == 'shop') and status == 404 => block",
+			"", false, true},
+	}
+
+	for _, test := range tests {
+
+		rule, err := ParseRule(test.text)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.text, err)
+			continue
+		}
+
+		if matched := rule.Matches(login, test.country); matched !=
+			test.login {
+			t.Errorf("%s: expected %v for the login, got: %v", test.text,
+				test.login, matched)
+		}
+		if matched := rule.Matches(page, test.country); matched !=
+			test.page {
+			t.Errorf("%s: expected %v for the page, got: %v", test.text,
+				test.page, matched)
+		}
+	}
+}
+
+// TestRuleCountries ... the country codes of a rule are normalized
+func TestRuleCountries(t *testing.T) {
+
+	rule, err := ParseRule("country in ['cn', 'ru'] or country == 'CN' " +
+		"=> block")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := []string{"CN", "RU"}
+	if countries := rule.Countries(); !reflect.DeepEqual(countries,
+		expected) {
+		t.Errorf("expected %v, got: %v", expected, countries)
+	}
+}
+
+// TestParseRuleRejected ... malformed rules are refused, naming the column
+func TestParseRuleRejected(t *testing.T) {
+
+	tests := []struct {
+		text    string
+		message string
+	}{
+		{"", "col 1: expected a field"},
+		{"path ~ '^/admin'", "expected \"=>\""},
+		{"path ~ '^/admin => block", "col 8: unterminated string"},
+		{"path ~ '^/admin' => block; rm", "col 26: unexpected character"},
+		{"host == 'x' => block", "col 1: expected a field"},
+		{"path = '/' => block", "unexpected character: ="},
+		{"path ~ '(' => block", "col 1: invalid regex"},
+		{"path < 400 => block", "< only applies to status"},
+		{"country ~ 'C' => block", "country only supports"},
+		{"country == 'XX' => block", "not an ISO 3166 country code"},
+		{"status == 'ok' => block", "expected a status code"},
+		{"ip in ['10.0.0.0/33'] => block", "invalid address or network"},
+		{"status in [200, => block", "expected a value"},
+		{"status in [200 404] => block", "expected \",\""},
+		{"(status == 200 => block", "expected \")\""},
+		{"count < 10 => block", "expected > or >="},
+		{"count > many => block", "expected a number"},
+		{"count > 1 within soon => block", "expected a duration"},
+		{"=> block 0s", "expected a duration"},
+		{"=> block 1h extra", "expected the end of the rule"},
+		{"=> allow", "expected \"block\""},
+	}
+
+	for _, test := range tests {
+
+		_, err := ParseRule(test.text)
+		if err == nil {
+			t.Errorf("%q: expected an error", test.text)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%q: expected an error containing %q, got: %s",
+				test.text, test.message, err)
+		}
+	}
+}
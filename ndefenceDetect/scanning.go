@@ -0,0 +1,347 @@
+//
+// Content discovery scanning detector of ndefence
+//
+
+package ndefenceDetect
+
+//
+// Imports
+//
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rbisewski/ndefence/ndefenceLog"
+	"github.com/rbisewski/ndefence/ndefenceUtils"
+)
+
+//
+// Globals
+//
+var (
+
+	// Number of failed requests at which a client may be flagged, and the
+	// share of its requests that must have failed, unless others are given
+	DefaultScanMinimum = 20
+	DefaultScanRatio   = 0.5
+
+	// Number of paths listed for every client flagged, unless another
+	// number is given
+	DefaultScanTop = 5
+
+	// Number of distinct paths whose failures are counted per client;
+	// further paths only count towards the totals, so that a scanner
+	// trying thousands of them cannot exhaust the memory
+	maxScanPaths = 256
+)
+
+//
+// Detector of clients whose requests mostly fail, e.g. content discovery
+// scanners guessing paths and getting 404s and 403s back
+//
+type scanningDetector struct {
+	name     string
+	statuses []int
+	minimum  int
+	ratio    float64
+	top      int
+	score    float64
+
+	// Failed and successful requests of every client, under the keys
+	// "failed" and "succeeded", and the failed ones by path
+	outcomes *counterSet
+	paths    *counterSet
+}
+
+//
+// Persisted state of a scanning detector
+//
+type scanningSnapshot struct {
+	Outcomes json.RawMessage `json:"outcomes"`
+	Paths    json.RawMessage `json:"paths"`
+}
+
+//! Assemble a scanning detector; options are "status", a list of the
+//! status codes counted as failures, all of 4xx by default, "minimum", the
+//! number of failures a client must reach, "ratio", the share of failures
+//! among its 2xx, 3xx and failed requests, "top", the number of paths to
+//! report, "window" and "score".
+/*
+ * @param     string      name of the detector
+ * @param     Options     options
+ * @param     Defaults    general settings
+ *
+ * @return    Detector    detector
+ * @return    error       error message, if any
+ */
+func newScanningDetector(name string, options Options,
+	defaults Defaults) (Detector, error) {
+
+	err := options.Check("status", "minimum", "ratio", "top", "window",
+		"score")
+	if err != nil {
+		return nil, err
+	}
+
+	statuses, err := options.Ints("status", nil)
+	if err != nil {
+		return nil, err
+	}
+	minimum, err := options.Int("minimum", DefaultScanMinimum)
+	if err != nil {
+		return nil, err
+	}
+	if minimum < 1 {
+		return nil, fmt.Errorf("minimum: must be at least 1")
+	}
+	ratio, err := options.Float("ratio", DefaultScanRatio)
+	if err != nil {
+		return nil, err
+	}
+	if ratio < 0 || ratio > 1 {
+		return nil, fmt.Errorf("ratio: must be between 0 and 1")
+	}
+	top, err := options.Int("top", DefaultScanTop)
+	if err != nil {
+		return nil, err
+	}
+	window, err := options.Duration("window", defaults.Window)
+	if err != nil {
+		return nil, err
+	}
+	score, err := options.Float("score", BlockScore)
+	if err != nil {
+		return nil, err
+	}
+
+	return &scanningDetector{name: name, statuses: statuses,
+		minimum: minimum, ratio: ratio, top: top, score: score,
+		outcomes: newCounterSet(window, defaults.Resolution),
+		paths:    newCounterSet(window, defaults.Resolution)}, nil
+}
+
+// Observe ... count the failed and successful requests of a client
+func (d *scanningDetector) Observe(entry ndefenceLog.LogEntry) bool {
+
+	// server errors say nothing about the client, so they are ignored
+	failed := d.isFailure(entry.Status)
+	if !failed && (entry.Status < 200 || entry.Status > 399) {
+		return false
+	}
+
+	if !failed {
+		d.outcomes.add(entry.ClientIP, "succeeded", entry.Time)
+		return false
+	}
+	d.outcomes.add(entry.ClientIP, "failed", entry.Time)
+
+	// the query string varies between attempts at the same path
+	path := strings.SplitN(entry.Path, "?", 2)[0]
+	if d.paths.count(entry.ClientIP, path, entry.Time) > 0 ||
+		len(d.paths.counters[entry.ClientIP]) < maxScanPaths {
+		d.paths.add(entry.ClientIP, path, entry.Time)
+	}
+
+	// note the client as soon as it crosses the thresholds
+	failures := d.outcomes.count(entry.ClientIP, "failed", entry.Time)
+	successes := d.outcomes.count(entry.ClientIP, "succeeded", entry.Time)
+	return d.exceeded(failures, successes) &&
+		!d.exceeded(failures-1, successes)
+}
+
+// Evaluate ... find every client whose failed requests within the window
+// exceed the thresholds
+func (d *scanningDetector) Evaluate(ctx Context) []Finding {
+
+	findings := make([]Finding, 0)
+	aggregated, members := AggregateCounts(d.outcomes.counts(ctx.Now))
+
+	for target, outcomes := range aggregated {
+
+		failures, successes := outcomes["failed"], outcomes["succeeded"]
+		if !d.exceeded(failures, successes) {
+			continue
+		}
+
+		paths := make(map[string]int)
+		for _, ip := range members[target] {
+			for path := range d.paths.counters[ip] {
+				if count := d.paths.count(ip, path, ctx.Now); count > 0 {
+					paths[path] += count
+				}
+			}
+		}
+
+		findings = append(findings, Finding{IP: target, Rule: d.name,
+			Score: d.score, Reason: fmt.Sprintf("%d of %d requests "+
+				"failed within %s (%s)", failures, failures+successes,
+				ndefenceUtils.FormatDuration(d.outcomes.window),
+				formatTopCounts(paths, d.top))})
+	}
+
+	return findings
+}
+
+// Annotate ... list the paths that failed the most for every address of a
+// client that exceeds the thresholds
+func (d *scanningDetector) Annotate(ctx Context) map[string]string {
+
+	notes := make(map[string]string)
+	aggregated, members := AggregateCounts(d.outcomes.counts(ctx.Now))
+
+	for target, outcomes := range aggregated {
+
+		if !d.exceeded(outcomes["failed"], outcomes["succeeded"]) {
+			continue
+		}
+
+		for _, ip := range members[target] {
+
+			paths := make(map[string]int)
+			for path := range d.paths.counters[ip] {
+				if count := d.paths.count(ip, path, ctx.Now); count > 0 {
+					paths[path] = count
+				}
+			}
+
+			if len(paths) > 0 {
+				notes[ip] = "top failed paths: " +
+					formatTopCounts(paths, d.top)
+			}
+		}
+	}
+
+	return notes
+}
+
+// Prune ... discard the requests that fell out of the window
+func (d *scanningDetector) Prune(now time.Time) {
+	d.outcomes.prune(now)
+	d.paths.prune(now)
+}
+
+// Save ... encode the request counts
+func (d *scanningDetector) Save() (json.RawMessage, error) {
+
+	// variable declaration
+	var snapshot scanningSnapshot
+	var err error
+
+	if snapshot.Outcomes, err = d.outcomes.save(); err != nil {
+		return nil, err
+	}
+	if snapshot.Paths, err = d.paths.save(); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(snapshot)
+}
+
+// Restore ... decode previously saved request counts
+func (d *scanningDetector) Restore(state json.RawMessage) error {
+
+	var snapshot scanningSnapshot
+	if err := json.Unmarshal(state, &snapshot); err != nil {
+		return err
+	}
+
+	if len(snapshot.Outcomes) > 0 {
+		if err := d.outcomes.restore(snapshot.Outcomes); err != nil {
+			return err
+		}
+	}
+	if len(snapshot.Paths) > 0 {
+		if err := d.paths.restore(snapshot.Paths); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//! Whether a status code counts as a failed request.
+/*
+ * @param     int     HTTP status code
+ *
+ * @return    bool    whether or not this is true
+ */
+func (d *scanningDetector) isFailure(status int) bool {
+	return isFailedStatus(d.statuses, status)
+}
+
+//! Whether a number of failed and successful requests exceeds both the
+//! minimum and the ratio.
+/*
+ * @param     int     failed requests
+ * @param     int     successful requests
+ *
+ * @return    bool    whether or not this is true
+ */
+func (d *scanningDetector) exceeded(failures int, successes int) bool {
+
+	if failures < d.minimum {
+		return false
+	}
+
+	return float64(failures) >= d.ratio*float64(failures+successes)
+}
+
+//! Whether a status code counts as a failed request, i.e. is one of the
+//! given status codes, or any 4xx if none are given.
+/*
+ * @param     int[]    status codes of failed requests; nil for all of 4xx
+ * @param     int      HTTP status code
+ *
+ * @return    bool     whether or not this is true
+ */
+func isFailedStatus(statuses []int, status int) bool {
+
+	if statuses == nil {
+		return status >= 400 && status <= 499
+	}
+
+	for _, candidate := range statuses {
+		if status == candidate {
+			return true
+		}
+	}
+
+	return false
+}
+
+//! Describe the largest counts of a map, largest first.
+/*
+ * @param     map       map[key] = count
+ * @param     int       number of counts to describe; 0 or less for all
+ *
+ * @return    string    e.g. "/admin: 12, /.env: 3"
+ */
+func formatTopCounts(counts map[string]int, top int) string {
+
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	if top > 0 && len(keys) > top {
+		keys = keys[:top]
+	}
+
+	pieces := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pieces = append(pieces, key+": "+strconv.Itoa(counts[key]))
+	}
+
+	return strings.Join(pieces, ", ")
+}
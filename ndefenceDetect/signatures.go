@@ -0,0 +1,446 @@
+//
+// Scanner signature detector of ndefence
+//
+
+package ndefenceDetect
+
+//
+// Imports
+//
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rbisewski/ndefence/ndefenceLog"
+	"github.com/rbisewski/ndefence/ndefenceUtils"
+)
+
+//
+// Globals
+//
+var (
+
+	// Score of every request matching a signature, unless another score is
+	// given, so that two probes block an address
+	DefaultSignatureScore = 0.5
+
+	// Scores of the categories of signature whose requests are malicious
+	// beyond doubt, so that a single one blocks an address
+	DefaultSignatureScores = "traversal=1,sql=1,shell=1"
+
+	// Signatures of vulnerability scanners, in the same format as the
+	// signature files, i.e. a category, a name and a regular expression
+	// per line; the expressions are matched against the request line, as
+	// logged and URL decoded
+	BuiltinSignatures = `
+# category  name             pattern
+path        dotenv           (?i)/\.env(\.\w+)?([/?\s]|$)
+path        vcs              (?i)/\.(git|svn|hg|bzr)(/|\s|$)
+path        wordpress        (?i)/wp-includes/wlwmanifest\.xml
+path        phpmyadmin       (?i)/(phpmyadmin|pma|myadmin|mysqladmin|dbadmin)[^/\s]*/
+path        cgi-bin          (?i)/cgi-bin/
+path        server-config    (?i)/(\.htaccess|\.htpasswd|web\.config|\.DS_Store|server-status|config\.php|phpinfo\.php)
+path        credentials      (?i)/\.(aws|ssh|docker)/|/id_rsa|/\.npmrc|/\.pypirc
+path        backups          (?i)\.(sql|bak|old|orig|swp)(\?|\s|$)
+path        webshells        (?i)/(shell|cmd|c99|r57|wso|alfa)\.php
+path        phpunit          (?i)/vendor/phpunit/
+path        devices          (?i)/(boaform|HNAP1|GponForm|setup\.cgi|cgi-bin/luci)
+traversal   dot-dot          \.\.[/\\]
+traversal   system-files     (?i)/etc/(passwd|shadow|hosts)|win\.ini|boot\.ini
+sql         union-select     (?i)union(\s|/\*.*?\*/)+(all(\s|/\*.*?\*/)+)?select
+sql         tautology        (?i)['"]\s*or\s+['"]?\w+['"]?\s*=\s*['"]?\w+
+sql         functions        (?i)(sleep|benchmark|pg_sleep|waitfor\s+delay)\s*\(|information_schema|;\s*drop\s+table
+shell       metacharacters   (?i)(;|\||&&|\$\(|` + "`" + `)\s*(cat|wget|curl|sh|bash|nc|id|uname|chmod|rm)(\s|;|$)
+shell       jndi             (?i)\$\{\s*jndi\s*:
+shell       shellshock       \(\)\s*\{\s*:?\s*;\s*\}
+`
+
+	// Signatures only used once included by name, since the sites of some
+	// users legitimately serve what they match, e.g. the WordPress admin
+	// pages that scanners probe for on every other site
+	OptionalSignatures = `
+# category  name             pattern
+path        wp-admin         (?i)/wp-admin/
+`
+)
+
+//
+// Signature object definition
+//
+type signature struct {
+	Category string
+	Name     string
+	Pattern  *regexp.Regexp
+}
+
+//
+// Detector of clients probing for vulnerabilities, as per a set of scanner
+// signatures
+//
+type signatureDetector struct {
+	name    string
+	score   float64
+	scores  map[string]float64
+	exclude map[string]bool
+	builtin []signature
+
+	// Signatures in use, i.e. the built-in ones and those of the file,
+	// along with when the file was last modified
+	signatures []signature
+	file       string
+	modified   time.Time
+
+	// Matching requests of every client, by category and name of the
+	// signature, e.g. "sql/union-select"
+	events *counterSet
+}
+
+//! Assemble a signature detector; options are "file", a file of further
+//! signatures, reloaded whenever it changes, "builtin", whether to use the
+//! built-in signatures, "include", a list of optional signatures to use
+//! as well, "exclude", a list of signatures not to use,
+//! "window", "scores", a list of category=score pairs adjusting the
+//! default ones, and "score", the score of every matching request of any
+//! other category.
+/*
+ * @param     string      name of the detector
+ * @param     Options     options
+ * @param     Defaults    general settings
+ *
+ * @return    Detector    detector
+ * @return    error       error message, if any
+ */
+func newSignatureDetector(name string, options Options,
+	defaults Defaults) (Detector, error) {
+
+	err := options.Check("file", "builtin", "include", "exclude", "window",
+		"scores", "score")
+	if err != nil {
+		return nil, err
+	}
+
+	useBuiltin, err := options.Bool("builtin", true)
+	if err != nil {
+		return nil, err
+	}
+	window, err := options.Duration("window", defaults.Window)
+	if err != nil {
+		return nil, err
+	}
+	score, err := options.Float("score", DefaultSignatureScore)
+	if err != nil {
+		return nil, err
+	}
+
+	scores, err := options.Scores("scores", DefaultSignatureScores)
+	if err != nil {
+		return nil, err
+	}
+
+	exclude := make(map[string]bool)
+	for _, excluded := range strings.Split(options["exclude"], ",") {
+		if excluded = strings.TrimSpace(excluded); excluded != "" {
+			exclude[excluded] = true
+		}
+	}
+
+	d := &signatureDetector{name: name, score: score, scores: scores,
+		exclude: exclude,
+		file:    options["file"],
+		events:  newCounterSet(window, defaults.Resolution)}
+
+	if useBuiltin {
+		d.builtin, err = parseSignatures(BuiltinSignatures)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	optional, err := parseSignatures(OptionalSignatures)
+	if err != nil {
+		return nil, err
+	}
+	for _, included := range strings.Split(options["include"], ",") {
+
+		included = strings.TrimSpace(included)
+		if included == "" {
+			continue
+		}
+
+		found := false
+		for _, sig := range optional {
+			if sig.Name == included {
+				d.builtin = append(d.builtin, sig)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("include: unknown optional signature: "+
+				"%s", included)
+		}
+	}
+
+	if err = d.reload(); err != nil {
+		return nil, err
+	}
+	if len(d.signatures) < 1 {
+		return nil, fmt.Errorf("no signatures to match")
+	}
+
+	return d, nil
+}
+
+// Observe ... count the requests of a client matching a signature
+func (d *signatureDetector) Observe(entry ndefenceLog.LogEntry) bool {
+
+	// scanners encode their payloads to slip past naive filters, so the
+	// request line is matched as decoded as well
+	line := entry.RequestLine()
+	decoded, err := url.QueryUnescape(line)
+	if err != nil {
+		decoded = line
+	}
+
+	// only the signature of the highest score counts, so that a single
+	// request cannot block an address by matching several
+	var matched *signature
+	for i, sig := range d.signatures {
+
+		if !sig.Pattern.MatchString(line) &&
+			!sig.Pattern.MatchString(decoded) {
+			continue
+		}
+		if matched == nil ||
+			d.scoreOf(sig.Category) > d.scoreOf(matched.Category) {
+			matched = &d.signatures[i]
+		}
+	}
+	if matched == nil {
+		return false
+	}
+
+	key := matched.Category + "/" + matched.Name
+	d.events.add(entry.ClientIP, key, entry.Time)
+
+	score := 0.0
+	for key := range d.events.counters[entry.ClientIP] {
+		count := d.events.count(entry.ClientIP, key, entry.Time)
+		score += float64(count) * d.scoreOf(categoryOf(key))
+	}
+
+	return score >= BlockScore &&
+		score-d.scoreOf(matched.Category) < BlockScore
+}
+
+// Evaluate ... find every client that made requests matching a signature
+// within the window
+func (d *signatureDetector) Evaluate(ctx Context) []Finding {
+
+	findings := make([]Finding, 0)
+	aggregated, _ := AggregateCounts(d.events.counts(ctx.Now))
+
+	for target, names := range aggregated {
+
+		hits := 0
+		score := 0.0
+		for key, count := range names {
+			hits += count
+			score += float64(count) * d.scoreOf(categoryOf(key))
+		}
+
+		findings = append(findings, Finding{IP: target, Rule: d.name,
+			Score: score,
+			Reason: fmt.Sprintf("%d scanner %s within %s (%s)", hits,
+				plural(hits, "probe", "probes"),
+				ndefenceUtils.FormatDuration(d.events.window),
+				ndefenceUtils.FormatCounts(names))})
+	}
+
+	return findings
+}
+
+// Prune ... discard the matches that fell out of the window, and pick up
+// any change to the signature file
+func (d *signatureDetector) Prune(now time.Time) {
+
+	d.events.prune(now)
+
+	if err := d.reload(); err != nil {
+		fmt.Println("Warning: keeping the previous signatures of detector " +
+			d.name + ": " + err.Error())
+	}
+}
+
+// Save ... encode the match counts
+func (d *signatureDetector) Save() (json.RawMessage, error) {
+	return d.events.save()
+}
+
+// Restore ... decode previously saved match counts
+func (d *signatureDetector) Restore(state json.RawMessage) error {
+	return d.events.restore(state)
+}
+
+//! Read the signature file, if it was modified since it was last read, and
+//! combine its signatures with the built-in ones; those of the file replace
+//! any built-in signature of the same name.
+/*
+ * @return    error    error message, if any
+ */
+func (d *signatureDetector) reload() error {
+
+	// variable declaration
+	loaded := make([]signature, 0)
+
+	if d.file != "" {
+
+		info, err := os.Stat(d.file)
+		if err != nil {
+			return err
+		}
+		if d.signatures != nil && info.ModTime().Equal(d.modified) {
+			return nil
+		}
+
+		contents, err := os.ReadFile(d.file)
+		if err != nil {
+			return err
+		}
+
+		loaded, err = parseSignatures(string(contents))
+		if err != nil {
+			return fmt.Errorf("%s: %s", d.file, err)
+		}
+		d.modified = info.ModTime()
+
+	} else if d.signatures != nil {
+		return nil
+	}
+
+	named := make(map[string]bool)
+	for _, sig := range loaded {
+		named[sig.Name] = true
+	}
+
+	signatures := make([]signature, 0, len(d.builtin)+len(loaded))
+	for _, sig := range d.builtin {
+		if !d.exclude[sig.Name] && !named[sig.Name] {
+			signatures = append(signatures, sig)
+		}
+	}
+	for _, sig := range loaded {
+		if !d.exclude[sig.Name] {
+			signatures = append(signatures, sig)
+		}
+	}
+
+	d.signatures = signatures
+	return nil
+}
+
+//! Parse a set of scanner signatures, one per line, each a category, a name
+//! and a regular expression separated by whitespace; blank lines and lines
+//! starting with # are skipped.
+/*
+ * @param     string         signatures
+ *
+ * @return    signature[]    parsed signatures
+ * @return    error          error message naming the offending line, if any
+ */
+func parseSignatures(text string) ([]signature, error) {
+
+	// variable declaration
+	signatures := make([]signature, 0)
+	names := make(map[string]bool)
+	lineNumber := 0
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("parseSignatures() --> line %d: "+
+				"expected a category, a name and a pattern", lineNumber)
+		}
+
+		// the pattern is the rest of the line, spaces and all
+		category, name := fields[0], fields[1]
+		rest := strings.TrimSpace(line[len(category):])
+		rest = strings.TrimSpace(rest[len(name):])
+
+		if names[name] {
+			return nil, fmt.Errorf("parseSignatures() --> line %d: "+
+				"duplicate signature: %s", lineNumber, name)
+		}
+		names[name] = true
+
+		pattern, err := regexp.Compile(rest)
+		if err != nil {
+			return nil, fmt.Errorf("parseSignatures() --> line %d: %s",
+				lineNumber, err)
+		}
+
+		signatures = append(signatures, signature{Category: category,
+			Name: name, Pattern: pattern})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return signatures, nil
+}
+
+//! Score of a request matching a signature of the given category.
+/*
+ * @param     string     category, e.g. "sql"
+ *
+ * @return    float64    score
+ */
+func (d *signatureDetector) scoreOf(category string) float64 {
+
+	if score, ok := d.scores[category]; ok {
+		return score
+	}
+
+	return d.score
+}
+
+//! Category of a signature, as per the key of its counter.
+/*
+ * @param     string    key, e.g. "sql/union-select"
+ *
+ * @return    string    category, e.g. "sql"
+ */
+func categoryOf(key string) string {
+	return strings.SplitN(key, "/", 2)[0]
+}
+
+//! Singular or plural form of a word, as per a count.
+/*
+ * @param     int       count
+ * @param     string    singular form
+ * @param     string    plural form
+ *
+ * @return    string    form of the word
+ */
+func plural(count int, singular string, plural string) string {
+
+	if count == 1 {
+		return singular
+	}
+
+	return plural
+}
@@ -0,0 +1,419 @@
+//
+// Subnet and autonomous system detector of ndefence
+//
+
+package ndefenceDetect
+
+//
+// Imports
+//
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rbisewski/ndefence/ndefenceLog"
+	"github.com/rbisewski/ndefence/ndefenceUtils"
+)
+
+//
+// Globals
+//
+var (
+
+	// Number of failed requests at which a network may be flagged, the
+	// number of its addresses that must have failed, and the share of its
+	// requests that must have failed, unless others are given
+	DefaultSubnetThreshold = 200
+	DefaultSubnetAddresses = 10
+	DefaultSubnetRatio     = 0.5
+
+	// Prefix lengths of the networks whose activity is added up, unless
+	// others are given
+	DefaultSubnetIPv4Prefixes = []int{24}
+	DefaultSubnetIPv6Prefixes = []int{48, 64}
+
+	// Prefix length of the IPv6 networks blocked for an autonomous system,
+	// since the routes it announces are not known; IPv4 ones are blocked by
+	// the /24
+	asnIPv6Prefix = 48
+)
+
+//
+// Detector of networks and autonomous systems whose addresses, each below
+// the thresholds of the other detectors, mostly fail together, e.g. a
+// botnet spreading a scan or a password guessing campaign over a subnet
+//
+type subnetDetector struct {
+	name      string
+	statuses  []int
+	threshold int
+	addresses int
+	ratio     float64
+	ipv4      []int
+	ipv6      []int
+	asn       bool
+	score     float64
+
+	// Failed and successful requests of every client, under the keys
+	// "failed" and "succeeded"
+	outcomes *counterSet
+}
+
+//
+// Activity of the addresses of a network or autonomous system
+//
+type subnetActivity struct {
+	failures  int
+	successes int
+
+	// Every address, those with failed requests, and the most failed
+	// requests of any single one of them
+	members []string
+	failed  []string
+	busiest int
+}
+
+//! Assemble a subnet detector; options are "status", a list of the status
+//! codes counted as failures, all of 4xx by default, "threshold", the
+//! number of failures a network must reach, "addresses", the number of its
+//! addresses that must have failed, "ratio", the share of failures among
+//! its 2xx, 3xx and failed requests, "ipv4" and "ipv6", the prefix lengths
+//! of the networks, "asn", whether to add up the addresses of every
+//! autonomous system as well, "window" and "score".
+/*
+ * @param     string      name of the detector
+ * @param     Options     options
+ * @param     Defaults    general settings
+ *
+ * @return    Detector    detector
+ * @return    error       error message, if any
+ */
+func newSubnetDetector(name string, options Options,
+	defaults Defaults) (Detector, error) {
+
+	err := options.Check("status", "threshold", "addresses", "ratio",
+		"ipv4", "ipv6", "asn", "window", "score")
+	if err != nil {
+		return nil, err
+	}
+
+	statuses, err := options.Ints("status", nil)
+	if err != nil {
+		return nil, err
+	}
+	threshold, err := options.Int("threshold", DefaultSubnetThreshold)
+	if err != nil {
+		return nil, err
+	}
+	if threshold < 1 {
+		return nil, fmt.Errorf("threshold: must be at least 1")
+	}
+	addresses, err := options.Int("addresses", DefaultSubnetAddresses)
+	if err != nil {
+		return nil, err
+	}
+	if addresses < 2 {
+		return nil, fmt.Errorf("addresses: must be at least 2")
+	}
+	ratio, err := options.Float("ratio", DefaultSubnetRatio)
+	if err != nil {
+		return nil, err
+	}
+	if ratio < 0 || ratio > 1 {
+		return nil, fmt.Errorf("ratio: must be between 0 and 1")
+	}
+	ipv4, err := prefixLengths(options, "ipv4", DefaultSubnetIPv4Prefixes,
+		32)
+	if err != nil {
+		return nil, err
+	}
+	ipv6, err := prefixLengths(options, "ipv6", DefaultSubnetIPv6Prefixes,
+		128)
+	if err != nil {
+		return nil, err
+	}
+	asn, err := options.Bool("asn", true)
+	if err != nil {
+		return nil, err
+	}
+	window, err := options.Duration("window", defaults.Window)
+	if err != nil {
+		return nil, err
+	}
+	score, err := options.Float("score", BlockScore)
+	if err != nil {
+		return nil, err
+	}
+
+	return &subnetDetector{name: name, statuses: statuses,
+		threshold: threshold, addresses: addresses, ratio: ratio,
+		ipv4: ipv4, ipv6: ipv6, asn: asn, score: score,
+		outcomes: newCounterSet(window, defaults.Resolution)}, nil
+}
+
+// Observe ... count the failed and successful requests of a client; the
+// networks are only added up once evaluated, so nothing is urgent
+func (d *subnetDetector) Observe(entry ndefenceLog.LogEntry) bool {
+
+	// server errors say nothing about the client, so they are ignored
+	if isFailedStatus(d.statuses, entry.Status) {
+		d.outcomes.add(entry.ClientIP, "failed", entry.Time)
+	} else if entry.Status >= 200 && entry.Status <= 399 {
+		d.outcomes.add(entry.ClientIP, "succeeded", entry.Time)
+	}
+
+	return false
+}
+
+// Evaluate ... find every network, and every network of an autonomous
+// system, whose addresses failed together within the window
+func (d *subnetDetector) Evaluate(ctx Context) []Finding {
+
+	// variable declaration
+	networks := make(map[string]*subnetActivity)
+	systems := make(map[string]*subnetActivity)
+	reasons := make(map[string][]string)
+
+	outcomes := d.outcomes.counts(ctx.Now)
+	for ip, counts := range outcomes {
+
+		for _, network := range d.networksOf(ip) {
+			if networks[network] == nil {
+				networks[network] = &subnetActivity{}
+			}
+			networks[network].add(ip, counts)
+		}
+
+		// addresses without a whois record cannot be attributed
+		system := ctx.ASNs[ip]
+		if d.asn && system != "" {
+			if systems[system] == nil {
+				systems[system] = &subnetActivity{}
+			}
+			systems[system].add(ip, counts)
+		}
+	}
+
+	for _, network := range sortedActivities(networks) {
+
+		activity := networks[network]
+		if !d.exceeded(activity) {
+			continue
+		}
+
+		reasons[network] = append(reasons[network],
+			d.describe(activity, ""))
+	}
+
+	// an autonomous system spans too many networks to block as a whole,
+	// so only those of its networks whose requests mostly failed are, so
+	// as to spare its other customers
+	for _, system := range sortedActivities(systems) {
+
+		activity := systems[system]
+		if !d.exceeded(activity) {
+			continue
+		}
+
+		parts := make(map[string]*subnetActivity)
+		for _, ip := range activity.members {
+
+			network, err := ndefenceUtils.ObtainSlash24FromIpv4(ip)
+			if err != nil {
+				network, err = ndefenceUtils.ObtainNetwork(ip,
+					asnIPv6Prefix)
+			}
+			if err != nil {
+				continue
+			}
+
+			if parts[network] == nil {
+				parts[network] = &subnetActivity{}
+			}
+			parts[network].add(ip, outcomes[ip])
+		}
+
+		reason := d.describe(activity, system)
+		for _, network := range sortedActivities(parts) {
+
+			part := parts[network]
+			if part.failures < 1 || float64(part.failures) <
+				d.ratio*float64(part.failures+part.successes) {
+				continue
+			}
+
+			reasons[network] = append(reasons[network], reason)
+		}
+	}
+
+	findings := make([]Finding, 0, len(reasons))
+	for target, pieces := range reasons {
+		findings = append(findings, Finding{IP: target, Rule: d.name,
+			Score: d.score, Reason: strings.Join(pieces, ", ")})
+	}
+
+	return findings
+}
+
+// Addresses ... the addresses counted within the window, since their
+// autonomous systems are needed even once they left the report window
+func (d *subnetDetector) Addresses(now time.Time) []string {
+
+	if !d.asn {
+		return nil
+	}
+
+	return d.outcomes.addresses(now)
+}
+
+// Prune ... discard the requests that fell out of the window
+func (d *subnetDetector) Prune(now time.Time) {
+	d.outcomes.prune(now)
+}
+
+// Save ... encode the request counts
+func (d *subnetDetector) Save() (json.RawMessage, error) {
+	return d.outcomes.save()
+}
+
+// Restore ... decode previously saved request counts
+func (d *subnetDetector) Restore(state json.RawMessage) error {
+	return d.outcomes.restore(state)
+}
+
+//! Networks an address belongs to, as per the prefix lengths of its family.
+/*
+ * @param     string      IP address
+ *
+ * @return    string[]    networks in CIDR notation, e.g. "10.1.2.0/24"
+ */
+func (d *subnetDetector) networksOf(ip string) []string {
+
+	prefixes := d.ipv6
+	if ndefenceUtils.IsValidIPv4Address(ip) {
+		prefixes = d.ipv4
+	}
+
+	networks := make([]string, 0, len(prefixes))
+	for _, bits := range prefixes {
+		if network, err := ndefenceUtils.ObtainNetwork(ip, bits); err == nil {
+			networks = append(networks, network)
+		}
+	}
+
+	return networks
+}
+
+//! Whether the activity of a network exceeds the thresholds.
+/*
+ * @param     *subnetActivity    activity of the network
+ *
+ * @return    bool               whether or not this is true
+ */
+func (d *subnetDetector) exceeded(activity *subnetActivity) bool {
+
+	if activity.failures < d.threshold ||
+		len(activity.failed) < d.addresses {
+		return false
+	}
+
+	return float64(activity.failures) >=
+		d.ratio*float64(activity.failures+activity.successes)
+}
+
+//! Explain the activity of a network, e.g. "250 of 300 requests failed
+//! within 24h from 30 addresses, at most 12 each".
+/*
+ * @param     *subnetActivity    activity of the network
+ * @param     string             autonomous system, if the activity is
+ *                               that of one
+ *
+ * @return    string             description
+ */
+func (d *subnetDetector) describe(activity *subnetActivity,
+	system string) string {
+
+	reason := fmt.Sprintf("%d of %d requests failed within %s from %d "+
+		"addresses, at most %d each", activity.failures,
+		activity.failures+activity.successes,
+		ndefenceUtils.FormatDuration(d.outcomes.window),
+		len(activity.failed), activity.busiest)
+	if system != "" {
+		reason += " (" + system + ")"
+	}
+
+	return reason
+}
+
+//! Add the requests of an address to the activity of a network.
+/*
+ * @param     string    IP address
+ * @param     map       map["failed" / "succeeded"] = count
+ */
+func (a *subnetActivity) add(ip string, counts map[string]int) {
+
+	a.failures += counts["failed"]
+	a.successes += counts["succeeded"]
+
+	a.members = append(a.members, ip)
+	if counts["failed"] > 0 {
+		a.failed = append(a.failed, ip)
+	}
+	if counts["failed"] > a.busiest {
+		a.busiest = counts["failed"]
+	}
+}
+
+//! Keys of a map of activities, sorted, so that the reasons read the same
+//! on every evaluation.
+/*
+ * @param     map         map[network or system] = activity
+ *
+ * @return    string[]    sorted keys
+ */
+func sortedActivities(activities map[string]*subnetActivity) []string {
+
+	keys := make([]string, 0, len(activities))
+	for key := range activities {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+//! Value of an option holding a list of prefix lengths; an empty list
+//! disables the networks of that family.
+/*
+ * @param     Options    options
+ * @param     string     option name
+ * @param     int[]      value if the option is not given
+ * @param     int        longest prefix length of the family
+ *
+ * @return    int[]      value
+ * @return    error      error message, if any
+ */
+func prefixLengths(options Options, name string, fallback []int,
+	longest int) ([]int, error) {
+
+	if text, ok := options[name]; ok && strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+
+	prefixes, err := options.Ints(name, fallback)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, bits := range prefixes {
+		if bits < 1 || bits > longest {
+			return nil, fmt.Errorf("%s: expected prefix lengths between 1 "+
+				"and %d, got: %d", name, longest, bits)
+		}
+	}
+
+	return prefixes, nil
+}
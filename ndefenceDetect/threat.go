@@ -0,0 +1,350 @@
+//
+// Threat scores of ndefence
+//
+
+package ndefenceDetect
+
+//
+// Imports
+//
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rbisewski/ndefence/ndefenceUtils"
+)
+
+//
+// Globals
+//
+var (
+
+	// Span of time over which the points of a finding halve, unless
+	// another is given
+	DefaultThreatHalfLife = 6 * time.Hour
+
+	// Scores at which a block is escalated and at which it is permanent,
+	// along with how long an escalated block lasts, unless others are given
+	DefaultEscalateScore  = 3.0
+	DefaultPermanentScore = 10.0
+	DefaultEscalateExpiry = 7 * 24 * time.Hour
+
+	// Levels of a block, in increasing order of severity
+	LevelBlocked   = "blocked"
+	LevelEscalated = "escalated"
+	LevelPermanent = "permanent"
+
+	// Points below which a detector no longer counts towards a score, so
+	// that long decayed findings are forgotten
+	minimumThreatPoints = 0.01
+)
+
+//
+// ThreatPolicy object definition, holding the thresholds of the threat
+// scores
+//
+type ThreatPolicy struct {
+
+	// Span of time over which the points of a finding halve; zero for no
+	// decay at all
+	HalfLife time.Duration
+
+	// Scores at which an address is blocked, its block is escalated, and
+	// its block is permanent; zero disables the latter two
+	BlockScore     float64
+	EscalateScore  float64
+	PermanentScore float64
+
+	// How long an escalated block lasts
+	EscalateExpiry time.Duration
+}
+
+//
+// ThreatScores object definition
+//
+type ThreatScores struct {
+	policy ThreatPolicy
+
+	// Points of every address or network, by detector
+	points map[string]map[string]threatPoints
+}
+
+//
+// Points a single detector added to a threat score, as of a given time,
+// along with the score of its finding at the time, so that a finding
+// evaluated again only adds whatever it grew by
+//
+type threatPoints struct {
+	Points   float64   `json:"points"`
+	Credited float64   `json:"credited"`
+	Time     time.Time `json:"time"`
+}
+
+//
+// Contribution object definition, holding the points a detector adds to
+// a threat score
+//
+type Contribution struct {
+	Rule   string
+	Points float64
+}
+
+// NewThreatScores ... assemble empty threat scores
+/*
+ * @param     ThreatPolicy     thresholds of the scores
+ *
+ * @return    *ThreatScores    threat scores
+ */
+func NewThreatScores(policy ThreatPolicy) *ThreatScores {
+	return &ThreatScores{policy: policy,
+		points: make(map[string]map[string]threatPoints)}
+}
+
+// Blocked ... add the findings to the threat scores, then list the
+// addresses whose score reaches the block score; the points of a detector
+// only start to decay once its finding ends, so that repeated findings add
+// up. Each address is blocked for the longest time any of its findings asks
+// for, or longer if its score is high enough to escalate the block
+/*
+ * @param     Finding[]    findings, sorted by address
+ * @param     time.Time    time of the evaluation, i.e. of the newest entry
+ * @param     duration     general expiry, for the findings that do not
+ *                         ask for a time of their own; zero for ever
+ *
+ * @return    Block[]      blocks, sorted by address
+ */
+func (t *ThreatScores) Blocked(findings []Finding, now time.Time,
+	expiry time.Duration) []Block {
+
+	// variable declaration
+	blocks := make([]Block, 0)
+	current := make(map[string][]Finding)
+	scores := make(map[string]map[string]float64)
+
+	for _, finding := range findings {
+		if scores[finding.IP] == nil {
+			scores[finding.IP] = make(map[string]float64)
+		}
+		scores[finding.IP][finding.Rule] += finding.Score
+		current[finding.IP] = append(current[finding.IP], finding)
+	}
+
+	// a finding only adds whatever its score grew by since it was last
+	// evaluated, so that a finding evaluated on every run never adds up
+	// with itself; one that ended adds in full once it happens again
+	for target, rules := range t.points {
+		for rule, points := range rules {
+			if _, ok := scores[target][rule]; !ok {
+				points.Credited = 0
+				rules[rule] = points
+			}
+		}
+	}
+	for target, rules := range scores {
+
+		if t.points[target] == nil {
+			t.points[target] = make(map[string]threatPoints)
+		}
+
+		for rule, score := range rules {
+
+			earlier := t.points[target][rule]
+			points := earlier.Points
+			if earlier.Credited == 0 {
+				points = t.decay(earlier, now)
+			}
+			if score > earlier.Credited {
+				points += score - earlier.Credited
+			}
+
+			t.points[target][rule] = threatPoints{Points: points,
+				Credited: score, Time: now}
+		}
+	}
+
+	targets := make([]string, 0, len(t.points))
+	for target := range t.points {
+		targets = append(targets, target)
+	}
+	ndefenceUtils.SortIPAddresses(targets)
+
+	for _, target := range targets {
+
+		score, contributions := t.score(target, scores[target], now)
+		if score < t.policy.BlockScore {
+			continue
+		}
+
+		// an address blocked for its earlier findings alone is blocked
+		// for the general expiry
+		block := Block{IP: target, Duration: expiry, Level: LevelBlocked,
+			Score: score, Contributions: contributions,
+			Findings: current[target]}
+		for i, finding := range block.Findings {
+
+			duration := finding.Duration
+			if duration == 0 {
+				duration = expiry
+			}
+
+			if i == 0 {
+				block.Duration = duration
+			} else {
+				block.Duration = longerDuration(block.Duration, duration)
+			}
+		}
+
+		if t.policy.EscalateScore > 0 && score >= t.policy.EscalateScore {
+			block.Level = LevelEscalated
+			block.Duration = longerDuration(block.Duration,
+				t.policy.EscalateExpiry)
+		}
+		if t.policy.PermanentScore > 0 && score >= t.policy.PermanentScore {
+			block.Level = LevelPermanent
+			block.Duration = 0
+		}
+
+		blocks = append(blocks, block)
+	}
+
+	return blocks
+}
+
+// Prune ... forget the detectors whose points decayed to almost nothing
+/*
+ * @param     time.Time    time of the newest entry
+ */
+func (t *ThreatScores) Prune(now time.Time) {
+
+	for target, rules := range t.points {
+
+		for rule, points := range rules {
+			if points.Credited == 0 &&
+				t.decay(points, now) < minimumThreatPoints {
+				delete(rules, rule)
+			}
+		}
+
+		if len(rules) == 0 {
+			delete(t.points, target)
+		}
+	}
+}
+
+// Save ... encode the points of every address
+/*
+ * @return    json.RawMessage    encoded points
+ * @return    error              error message, if any
+ */
+func (t *ThreatScores) Save() (json.RawMessage, error) {
+	return json.Marshal(t.points)
+}
+
+// Restore ... decode previously saved points
+/*
+ * @param     json.RawMessage    encoded points
+ *
+ * @return    error              error message, if any
+ */
+func (t *ThreatScores) Restore(state json.RawMessage) error {
+
+	points := make(map[string]map[string]threatPoints)
+	if err := json.Unmarshal(state, &points); err != nil {
+		return fmt.Errorf("Restore() --> %s", err)
+	}
+
+	t.points = points
+	return nil
+}
+
+// Breakdown ... explain the score of a block, e.g. "score 2.50 =
+// signatures 1.50 + rate 1.00"
+/*
+ * @return    string    description
+ */
+func (b Block) Breakdown() string {
+
+	pieces := make([]string, 0, len(b.Contributions))
+	for _, contribution := range b.Contributions {
+		pieces = append(pieces, fmt.Sprintf("%s %.2f", contribution.Rule,
+			contribution.Points))
+	}
+
+	return fmt.Sprintf("score %.2f = %s", b.Score,
+		strings.Join(pieces, " + "))
+}
+
+//! Threat score of an address at a given time, along with the points of
+//! every detector making it up, highest first; a detector with a current
+//! finding adds at least the score of that finding.
+/*
+ * @param     string            address or network
+ * @param     map               map[detector] = score of its current finding
+ * @param     time.Time         time of the evaluation
+ *
+ * @return    float64           score
+ * @return    Contribution[]    points of every detector
+ */
+func (t *ThreatScores) score(target string, current map[string]float64,
+	now time.Time) (float64, []Contribution) {
+
+	// variable declaration
+	score := 0.0
+	contributions := make([]Contribution, 0, len(t.points[target]))
+
+	for rule, points := range t.points[target] {
+		decayed := math.Max(t.decay(points, now), current[rule])
+		score += decayed
+		contributions = append(contributions, Contribution{Rule: rule,
+			Points: decayed})
+	}
+
+	sort.Slice(contributions, func(i, j int) bool {
+		if contributions[i].Points != contributions[j].Points {
+			return contributions[i].Points > contributions[j].Points
+		}
+		return contributions[i].Rule < contributions[j].Rule
+	})
+
+	return score, contributions
+}
+
+//! Points left of a detector at a given time, halving every half-life.
+/*
+ * @param     threatPoints    points, as of the time they were raised
+ * @param     time.Time       time of the evaluation
+ *
+ * @return    float64         points left
+ */
+func (t *ThreatScores) decay(points threatPoints, now time.Time) float64 {
+
+	if t.policy.HalfLife <= 0 || !now.After(points.Time) {
+		return points.Points
+	}
+
+	elapsed := float64(now.Sub(points.Time)) / float64(t.policy.HalfLife)
+	return points.Points * math.Exp2(-elapsed)
+}
+
+//! The longer of two block durations, where zero is for ever.
+/*
+ * @param     duration    first duration
+ * @param     duration    second duration
+ *
+ * @return    duration    longer duration
+ */
+func longerDuration(a time.Duration, b time.Duration) time.Duration {
+
+	if a == 0 || b == 0 {
+		return 0
+	}
+	if b > a {
+		return b
+	}
+
+	return a
+}
@@ -0,0 +1,119 @@
+//
+// Cache of the whois / hostname lookups, for use by long running processes.
+//
+
+package ndefenceHostname
+
+//
+// Imports
+//
+import (
+	"bytes"
+	"net"
+	"time"
+)
+
+//
+// Globals
+//
+var (
+
+	// How long a lookup result stays valid
+	LookupCacheTTL = 24 * time.Hour
+
+	// Upper limit on the number of cached results of each kind
+	maxCachedLookups = 65536
+
+	// Cached results of the whois and reverse DNS lookups
+	whoisCache    = make(map[string]cachedLookup)
+	hostnameCache = make(map[string]cachedLookup)
+)
+
+//
+// Cached result of a single lookup
+//
+type cachedLookup struct {
+	value   string
+	values  []string
+	err     error
+	expires time.Time
+}
+
+//! Obtain the whois record of an IP address, from the cache if possible.
+/*
+ *  @param    string       IP address
+ *
+ *  @return   bytes[]      array of byte buffer data
+ *  @return   error        error message, if any
+ */
+func cachedWhois(ip string) (bytes.Buffer, error) {
+
+	// variable declaration
+	var result bytes.Buffer
+	now := time.Now()
+
+	if cached, ok := whoisCache[ip]; ok && now.Before(cached.expires) {
+		result.WriteString(cached.value)
+		return result, cached.err
+	}
+
+	result, err := runWhoisCommand(ip)
+
+	storeLookup(whoisCache, ip, cachedLookup{value: result.String(),
+		err: err, expires: now.Add(LookupCacheTTL)})
+
+	return result, err
+}
+
+//! Obtain the hostnames of an IP address, from the cache if possible.
+/*
+ *  @param    string       IP address
+ *
+ *  @return   string[]     hostnames
+ *  @return   error        error message, if any
+ */
+func cachedLookupAddr(ip string) ([]string, error) {
+
+	now := time.Now()
+
+	if cached, ok := hostnameCache[ip]; ok && now.Before(cached.expires) {
+		return cached.values, cached.err
+	}
+
+	hostnames, err := net.LookupAddr(ip)
+
+	storeLookup(hostnameCache, ip, cachedLookup{values: hostnames,
+		err: err, expires: now.Add(LookupCacheTTL)})
+
+	return hostnames, err
+}
+
+//! Store a lookup result, clearing out expired results if the cache has
+//! grown too large.
+/*
+ *  @param    map             cache to store the result in
+ *  @param    string          IP address
+ *  @param    cachedLookup    result
+ */
+func storeLookup(cache map[string]cachedLookup, ip string,
+	lookup cachedLookup) {
+
+	if len(cache) >= maxCachedLookups {
+
+		now := time.Now()
+		for key, cached := range cache {
+			if now.After(cached.expires) {
+				delete(cache, key)
+			}
+		}
+
+		// still too large, so start over
+		if len(cache) >= maxCachedLookups {
+			for key := range cache {
+				delete(cache, key)
+			}
+		}
+	}
+
+	cache[ip] = lookup
+}
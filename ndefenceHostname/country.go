@@ -0,0 +1,256 @@
+//
+// Country codes and the country policy of ndefence
+//
+
+package ndefenceHostname
+
+//
+// Imports
+//
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//
+// Globals
+//
+var (
+
+	// Modes of a country policy; in allowlist mode the listed countries
+	// are never blocked for their request counts, whereas in denylist mode
+	// only the listed countries are
+	CountryAllowlist = "allowlist"
+	CountryDenylist  = "denylist"
+
+	// ISO 3166-1 alpha-2 country codes, along with the EU and AP region
+	// codes that the regional internet registries use in whois records
+	countryCodes = makeCountrySet(`
+		AD AE AF AG AI AL AM AO AQ AR AS AT AU AW AX AZ
+		BA BB BD BE BF BG BH BI BJ BL BM BN BO BQ BR BS BT BV BW BY BZ
+		CA CC CD CF CG CH CI CK CL CM CN CO CR CU CV CW CX CY CZ
+		DE DJ DK DM DO DZ
+		EC EE EG EH ER ES ET
+		FI FJ FK FM FO FR
+		GA GB GD GE GF GG GH GI GL GM GN GP GQ GR GS GT GU GW GY
+		HK HM HN HR HT HU
+		ID IE IL IM IN IO IQ IR IS IT
+		JE JM JO JP
+		KE KG KH KI KM KN KP KR KW KY KZ
+		LA LB LC LI LK LR LS LT LU LV LY
+		MA MC MD ME MF MG MH MK ML MM MN MO MP MQ MR MS MT MU MV MW MX
+		MY MZ
+		NA NC NE NF NG NI NL NO NP NR NU NZ
+		OM
+		PA PE PF PG PH PK PL PM PN PR PS PT PW PY
+		QA
+		RE RO RS RU RW
+		SA SB SC SD SE SG SH SI SJ SK SL SM SN SO SR SS ST SV SX SY SZ
+		TC TD TF TG TH TJ TK TL TM TN TO TR TT TV TW TZ
+		UA UG UM US UY UZ
+		VA VC VE VG VI VN VU
+		WF WS
+		YE YT
+		ZA ZM ZW
+		EU AP`)
+
+	// Codes in common use that are not the ISO 3166 code of the country
+	countryAliases = map[string]string{
+		"UK": "GB", // United Kingdom
+		"EL": "GR", // Greece, as per the European Union
+		"FX": "FR", // Metropolitan France
+		"TP": "TL", // East Timor, before 2002
+		"YU": "RS", // Yugoslavia, for the most part now Serbia
+		"ZR": "CD", // Zaire
+	}
+)
+
+//
+// CountryPolicy object definition
+//
+type CountryPolicy struct {
+
+	// Either CountryAllowlist or CountryDenylist
+	Mode string
+
+	// Countries listed by the policy
+	Countries map[string]bool
+
+	// Request thresholds of particular countries
+	Thresholds map[string]int
+}
+
+// NormalizeCountryCode ... convert a country code to its ISO 3166 form,
+// e.g. "uk" --> "GB"
+/*
+ * @param     string    country code
+ *
+ * @return    string    ISO 3166 country code
+ * @return    error     error message, if any
+ */
+func NormalizeCountryCode(code string) (string, error) {
+
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if alias, ok := countryAliases[code]; ok {
+		code = alias
+	}
+
+	if !countryCodes[code] {
+		return "", fmt.Errorf("NormalizeCountryCode() --> not an ISO "+
+			"3166 country code: %s", code)
+	}
+
+	return code, nil
+}
+
+// NewCountryPolicy ... assemble a country policy
+/*
+ * @param     string            CountryAllowlist or CountryDenylist
+ * @param     string            comma separated country codes, e.g. "US,GB"
+ *
+ * @return    *CountryPolicy    country policy
+ * @return    error             error message, if any
+ */
+func NewCountryPolicy(mode string, countries string) (*CountryPolicy,
+	error) {
+
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	if mode != CountryAllowlist && mode != CountryDenylist {
+		return nil, fmt.Errorf("NewCountryPolicy() --> expected %s or %s, "+
+			"got: %s", CountryAllowlist, CountryDenylist, mode)
+	}
+
+	policy := &CountryPolicy{
+		Mode:       mode,
+		Countries:  make(map[string]bool),
+		Thresholds: make(map[string]int),
+	}
+
+	for _, code := range strings.Split(countries, ",") {
+
+		if strings.TrimSpace(code) == "" {
+			continue
+		}
+
+		normalized, err := NormalizeCountryCode(code)
+		if err != nil {
+			return nil, err
+		}
+		policy.Countries[normalized] = true
+	}
+
+	return policy, nil
+}
+
+// SetThresholds ... set the request thresholds of particular countries
+/*
+ * @param     string    comma separated "country=count" pairs, e.g.
+ *                      "CN=2,RU=3"
+ *
+ * @return    error     error message, if any
+ */
+func (p *CountryPolicy) SetThresholds(thresholds string) error {
+
+	for _, item := range strings.Split(thresholds, ",") {
+
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		pair := strings.SplitN(item, "=", 2)
+		if len(pair) != 2 {
+			return fmt.Errorf("SetThresholds() --> expected "+
+				"country=count, got: %s", item)
+		}
+
+		code, err := NormalizeCountryCode(pair[0])
+		if err != nil {
+			return err
+		}
+
+		count, err := strconv.Atoi(strings.TrimSpace(pair[1]))
+		if err != nil || count < 0 {
+			return fmt.Errorf("SetThresholds() --> invalid count for "+
+				"country %s: %s", code, pair[1])
+		}
+
+		p.Thresholds[code] = count
+	}
+
+	return nil
+}
+
+// Evaluates ... whether addresses of the given country are subject to
+// blocking for their request counts
+/*
+ * @param     string    country code, as per the whois record
+ *
+ * @return    bool      whether or not this is true
+ */
+func (p *CountryPolicy) Evaluates(code string) bool {
+
+	// addresses of unknown countries are never blocked by country
+	code, err := NormalizeCountryCode(code)
+	if err != nil {
+		return false
+	}
+
+	// in allowlist mode, only the countries not listed are evaluated;
+	// in denylist mode, only the ones listed
+	if p.Mode == CountryDenylist {
+		return p.Countries[code]
+	}
+	return !p.Countries[code]
+}
+
+// Threshold ... the request threshold of the given country, if it has one
+/*
+ * @param     string    country code, as per the whois record
+ *
+ * @return    int       threshold; zero if the country is never blocked
+ * @return    bool      whether the country has a threshold of its own
+ */
+func (p *CountryPolicy) Threshold(code string) (int, bool) {
+
+	code, err := NormalizeCountryCode(code)
+	if err != nil {
+		return 0, false
+	}
+
+	threshold, ok := p.Thresholds[code]
+	return threshold, ok
+}
+
+// String ... describe the policy, e.g. "allowlist: CA, DE, GB"
+/*
+ * @return    string    description
+ */
+func (p *CountryPolicy) String() string {
+
+	codes := make([]string, 0, len(p.Countries))
+	for code := range p.Countries {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	return p.Mode + ": " + strings.Join(codes, ", ")
+}
+
+//! Assemble a set of country codes from a whitespace separated list.
+/*
+ * @param     string    country codes
+ *
+ * @return    map       map[country code] = true
+ */
+func makeCountrySet(codes string) map[string]bool {
+
+	set := make(map[string]bool)
+	for _, code := range strings.Fields(codes) {
+		set[code] = true
+	}
+
+	return set
+}
@@ -10,10 +10,8 @@ package ndefenceHostname
 import (
 	"bytes"
 	"fmt"
-	"net"
 	"os/exec"
 	"regexp"
-	"sort"
 	"strconv"
 	"strings"
 
@@ -25,17 +23,26 @@ import (
 /*
  * @param     map        string map containing ip addresses and counts
  * @param     map        string map containing ip/whois country data
+ * @param     map        counts of every ip address, by class of user
+ *                       agent; may be nil
+ * @param     map        counts of every ip address, by site; may be nil
+ * @param     map        notes on every ip address, e.g. the paths that
+ *                       failed the most; may be nil
  *
- * @return    string     lines that contain "count | ip | country | host \n"
+ * @return    string     lines that contain "count | ip | country | host
+ *                       | agents | sites [| notes] \n"
  *            error      error message, if any
  */
 func ConvertIPAddressMapToString(ipMap map[string]int,
-	whoisCountryMap map[string]string) (string, error) {
+	whoisCountryMap map[string]string,
+	agentMap map[string]map[string]int,
+	siteMap map[string]map[string]int,
+	notes map[string]string) (string, error) {
 
-	// input validation for the IPv4 map
+	// input validation for the IP map
 	if len(ipMap) < 1 {
 		return "", fmt.Errorf("ConvertIPAddressMapToString() --> " +
-			"IPv4 map appears empty")
+			"IP map appears empty")
 
 		// input validation for the WHOIS country map
 	} else if len(whoisCountryMap) < 1 {
@@ -51,29 +58,19 @@ func ConvertIPAddressMapToString(ipMap map[string]int,
 	var linesAppended uint
 	firstHostname := ""
 
-	// for every IPv4 address in the given map...
+	// for every IP address in the given map...
 	for ip := range ipMap {
 
-		// workaround, to better sort IP addresses
-		if strings.Index(ip, ".") == 1 {
-			ip = "00" + ip
-		} else if strings.Index(ip, ".") == 2 {
-			ip = "0" + ip
-		}
-
 		// append that address to the temp string array
 		tmpStrArray = append(tmpStrArray, ip)
 	}
 
-	// sort the given list of IPv4 addresses
-	sort.Strings(tmpStrArray)
+	// sort the given list of IP addresses numerically
+	ndefenceUtils.SortIPAddresses(tmpStrArray)
 
 	// for every ip address
 	for _, ip := range tmpStrArray {
 
-		// workaround, trim away any LHS zeros
-		ip = strings.TrimLeft(ip, "0")
-
 		// grab the count
 		count := ipMap[ip]
 
@@ -87,7 +84,7 @@ func ConvertIPAddressMapToString(ipMap map[string]int,
 		}
 
 		// take the given IP address and attempt to grab the hostname
-		hostnames, err := net.LookupAddr(ip)
+		hostnames, err := cachedLookupAddr(ip)
 
 		// default to "N/A" as the default hostname if an error occurred
 		// or no hostnames could be currently found...
@@ -104,8 +101,8 @@ func ConvertIPAddressMapToString(ipMap map[string]int,
 		}
 
 		// since the \t character tends to get mangled easily, add a buffer
-		// of single-space characters instead to the IPv4 addresses
-		spaceFormattedIPAddress, err := ndefenceUtils.SpaceFormatIPv4(ip)
+		// of single-space characters instead to the IP addresses
+		spaceFormattedIPAddress, err := ndefenceUtils.SpaceFormatIP(ip)
 
 		// if an error occurs, skip to the next element
 		if err != nil {
@@ -120,6 +117,25 @@ func ConvertIPAddressMapToString(ipMap map[string]int,
 		ipStrings += countryCode
 		ipStrings += " | "
 		ipStrings += firstHostname
+
+		// append the classes of the user agents, if any were given
+		if len(agentMap[ip]) > 0 {
+			ipStrings += " | "
+			ipStrings += ndefenceUtils.FormatCounts(agentMap[ip])
+		}
+
+		// append the per-site counts, if any were given
+		if len(siteMap[ip]) > 0 {
+			ipStrings += " | "
+			ipStrings += ndefenceUtils.FormatCounts(siteMap[ip])
+		}
+
+		// append the notes on this address, if there are any
+		if len(notes[ip]) > 0 {
+			ipStrings += " | "
+			ipStrings += notes[ip]
+		}
+
 		ipStrings += "\n"
 
 		// add a line counter for internal use
@@ -143,19 +159,23 @@ func ConvertIPAddressMapToString(ipMap map[string]int,
  *
  * @return    string    whois data of every given ip
  * @return    map       string map containing whois country data
+ * @return    map       string map containing the autonomous system of
+ *                      every ip whose record names one, e.g. AS3320
  * @return    error     error message, if any
  */
 func ObtainWhoisEntries(ipMap map[string]int) (string, map[string]string,
-	error) {
+	map[string]string, error) {
 
 	// input validation
 	if len(ipMap) < 1 {
-		return "", nil, fmt.Errorf("obtainWhoisEntries() --> invalid input")
+		return "", nil, nil, fmt.Errorf("obtainWhoisEntries() --> " +
+			"invalid input")
 	}
 
 	// variable declaration
 	whoisStrings := ""
 	whoisSummaryMap := make(map[string]string)
+	whoisASNMap := make(map[string]string)
 	var entriesAppended uint
 	tmpStrArray := make([]string, 0)
 	tmpStrBuffer := ""
@@ -163,29 +183,19 @@ func ObtainWhoisEntries(ipMap map[string]int) (string, map[string]string,
 	var err error
 	var result bytes.Buffer
 
-	// for every IPv4 address in the given map...
+	// for every IP address in the given map...
 	for ip := range ipMap {
 
-		// workaround, to better sort IP addresses
-		if strings.Index(ip, ".") == 1 {
-			ip = "00" + ip
-		} else if strings.Index(ip, ".") == 2 {
-			ip = "0" + ip
-		}
-
 		// append that address to the temp string array
 		tmpStrArray = append(tmpStrArray, ip)
 	}
 
-	// sort the given list of IPv4 addresses
-	sort.Strings(tmpStrArray)
+	// sort the given list of IP addresses numerically
+	ndefenceUtils.SortIPAddresses(tmpStrArray)
 
 	// for every ip address
 	for _, ip := range tmpStrArray {
 
-		// workaround, trim away any LHS zeros
-		ip = strings.TrimLeft(ip, "0")
-
 		// safety check, skip to the next entry if this one is of length
 		// zero
 		if len(ip) < 1 {
@@ -193,7 +203,7 @@ func ObtainWhoisEntries(ipMap map[string]int) (string, map[string]string,
 		}
 
 		// attempt to obtain the whois record
-		result, err = runWhoisCommand(ip)
+		result, err = cachedWhois(ip)
 
 		// if an error occurs at this point...
 		if err != nil {
@@ -311,8 +321,26 @@ func ObtainWhoisEntries(ipMap map[string]int) (string, map[string]string,
 			break
 		}
 
-		// append it to the whois map
-		whoisSummaryMap[ip] = strings.ToUpper(whoisRegexCountryResult)
+		// append it to the whois map, using the ISO 3166 code where the
+		// registry gave an alias of it, e.g. UK --> GB
+		whoisRegexCountryResult = strings.ToUpper(whoisRegexCountryResult)
+		code, err := NormalizeCountryCode(whoisRegexCountryResult)
+		if err == nil {
+			whoisRegexCountryResult = code
+		}
+		whoisSummaryMap[ip] = whoisRegexCountryResult
+
+		// compile a regex that looks for the autonomous system announcing
+		// the network, e.g. "origin: AS3320" or "OriginAS: AS15169"
+		reOrigin := regexp.MustCompile(
+			"(?im)^[ \t]*origin(?:-?as)?:[ \t]*(AS[0-9]+)[ \t]*$")
+
+		// as with the country, take the last one, since it is the most
+		// specific
+		for _, origin := range reOrigin.FindAllStringSubmatch(
+			trimmedString, -1) {
+			whoisASNMap[ip] = strings.ToUpper(origin[1])
+		}
 
 		// otherwise it's probably good, then go ahead and append it
 		whoisStrings += "Whois Entry for the following: "
@@ -333,7 +361,7 @@ func ObtainWhoisEntries(ipMap map[string]int) (string, map[string]string,
 	}
 
 	// everything worked fine, so return the completed string contents
-	return whoisStrings, whoisSummaryMap, nil
+	return whoisStrings, whoisSummaryMap, whoisASNMap, nil
 }
 
 //! Attempt to execute the whois command.
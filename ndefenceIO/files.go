@@ -37,7 +37,7 @@ func TokenizeFile(filepath string, separator string) ([]string,
 	// unable to access a read the file, so pass back an error
 	if err != nil {
 		return nil, fmt.Errorf("tokenizeFile() --> An error occurred "+
-			"while trying to read the following file: ", filepath)
+			"while trying to read the following file: %s", filepath)
 	}
 
 	// dump the contents of the file to a string
@@ -46,7 +46,7 @@ func TokenizeFile(filepath string, separator string) ([]string,
 	// if the contents are less than 1 byte, mention that via error
 	if len(stringContents) < 1 {
 		return nil, fmt.Errorf("tokenizeFile() --> the following file "+
-			"was empty: ", filepath)
+			"was empty: %s", filepath)
 	}
 
 	// attempt to break up the file into an array of strings
@@ -110,66 +110,3 @@ func StatOrCreateFile(path string) error {
 	// else everything worked, so go ahead and return nil
 	return nil
 }
-
-// ObtainLatestDate ... determine the latest date present in the logs
-/*
- * @param     string    line data
- *
- * @return    string    latest time-date, in the form of DD/MMM/YYYY
- *            error     error message, if any
- */
-func ObtainLatestDate(line string) (string, error) {
-
-	// input validation
-	if len(line) < 1 {
-		return "", fmt.Errorf("obtainLatestDate() --> invalid input")
-	}
-
-	// variable declaration
-	result := ""
-
-	// attempt to split that line via spaces
-	elements := strings.Split(line, " ")
-
-	// safety check, ensure there are at least 4 elements
-	if len(elements) < 4 {
-
-		// otherwise send back an error
-		return "", fmt.Errorf("obtainLatestDate() --> poorly formatted line")
-	}
-
-	// attempt to grab the fourth element
-	datetime := elements[3]
-
-	// attempt to trim the string of [ and ] brackets
-	datetime = strings.Trim(datetime, "[]")
-
-	// ensure the string actually has at least a length of 1
-	if len(datetime) < 1 {
-		return "", fmt.Errorf("obtainLatestDate() --> date-time string " +
-			"is of improper length")
-	}
-
-	// split the string via the ':' characters
-	timePieces := strings.SplitAfter(datetime, ":")
-
-	// ensure there is at least one element
-	if len(timePieces) < 1 || len(timePieces[0]) < 1 {
-		return "", fmt.Errorf("obtainLatestDate() --> unable to use _:_ " +
-			"chars to separate time into pieces")
-	}
-
-	// trim away the remaining : chars
-	result = strings.Trim(timePieces[0], ":")
-
-	// final safety check, ensure that the result has a len > 0
-	if len(result) < 1 {
-
-		// otherwise send back an error
-		return "", fmt.Errorf("obtainLatestDate() --> unable to " +
-			"assemble string result")
-	}
-
-	// if everything turned out fine, go ahead and return
-	return result, nil
-}
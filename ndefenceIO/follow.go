@@ -0,0 +1,205 @@
+//
+// Log file follower, with tail -F semantics, for ndefence
+//
+
+package ndefenceIO
+
+//
+// Imports
+//
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+//
+// Follower object definition
+//
+type Follower struct {
+
+	// Location of the log being followed
+	Path string
+
+	// Lines longer than this, in bytes, are skipped
+	MaxLineLength int
+
+	// Number of lines skipped for exceeding the maximum length
+	Skipped int
+
+	// Currently open file, along with its identity and how much of it has
+	// been read
+	file   *os.File
+	inode  uint64
+	device uint64
+	offset int64
+}
+
+// NewFollower ... prepare to follow a log file, continuing from the
+// position recorded in the given state if it still refers to the same file
+/*
+ * @param     string       /path/to/access.log
+ * @param     LogState     state of the log
+ * @param     int          maximum line length, in bytes
+ *
+ * @return    *Follower    follower; the file is opened by the first Poll()
+ */
+func NewFollower(path string, state LogState, maxLineLength int) *Follower {
+
+	if maxLineLength < 1 {
+		maxLineLength = DefaultMaxLineLength
+	}
+
+	return &Follower{
+		Path:          path,
+		MaxLineLength: maxLineLength,
+		inode:         state.Inode,
+		device:        state.Device,
+		offset:        state.Offset,
+	}
+}
+
+// Poll ... pass every complete line written since the last call to the
+// given function, switching over to the new file after a rotation and
+// starting over after a truncation
+/*
+ * @param     func     function to call with every line
+ *
+ * @return    error    error message, if any
+ */
+func (f *Follower) Poll(fn func(line string)) error {
+
+	// input validation
+	if fn == nil {
+		return fmt.Errorf("Poll() --> invalid input")
+	}
+
+	// the log may not exist yet, e.g. right after a rotation
+	if f.file == nil {
+		err := f.open()
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := f.drain(fn, true); err != nil {
+		return err
+	}
+
+	// check whether the path still refers to the open file
+	inode, device, size, err := ObtainFileIdentity(f.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+
+	// rotated, so read whatever remains of the old file, then move on
+	case inode != f.inode || device != f.device:
+		if err := f.drain(fn, false); err != nil {
+			return err
+		}
+		f.Close()
+		f.offset = 0
+		if err := f.open(); err != nil {
+			return err
+		}
+		return f.drain(fn, true)
+
+	// truncated, so start over from the beginning
+	case size < f.offset:
+		f.offset = 0
+		return f.drain(fn, true)
+	}
+
+	return nil
+}
+
+// State ... the position of the follower, for use with WriteLogState()
+/*
+ * @param     LogState    previous state of the log
+ *
+ * @return    LogState    updated state of the log
+ */
+func (f *Follower) State(state LogState) LogState {
+
+	state.Path = f.Path
+	state.Inode = f.inode
+	state.Device = f.device
+	state.Offset = f.offset
+
+	return state
+}
+
+// Close ... close the currently open file, if any
+func (f *Follower) Close() {
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+}
+
+//! Open the log, keeping the offset only if it is the same file as before.
+/*
+ * @return    error    error message, if any
+ */
+func (f *Follower) open() error {
+
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return err
+	}
+
+	// use the identity of the file that was actually opened
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		file.Close()
+		return fmt.Errorf("open() --> unable to obtain the inode of: %s",
+			f.Path)
+	}
+
+	inode, device := uint64(stat.Ino), uint64(stat.Dev)
+	if inode != f.inode || device != f.device || info.Size() < f.offset {
+		f.offset = 0
+	}
+
+	f.file, f.inode, f.device = file, inode, device
+	return nil
+}
+
+//! Read every line between the offset and the end of the open file.
+/*
+ * @param     func     function to call with every line
+ * @param     bool     whether to leave an incomplete final line unread
+ *
+ * @return    error    error message, if any
+ */
+func (f *Follower) drain(fn func(line string), ignorePartial bool) error {
+
+	if _, err := f.file.Seek(f.offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	lines := NewLineReader(f.file, f.MaxLineLength)
+	lines.IgnorePartial = ignorePartial
+	for lines.Next() {
+		fn(lines.Line())
+	}
+
+	f.offset += lines.Offset
+	f.Skipped += lines.Skipped
+
+	return lines.Err()
+}
@@ -0,0 +1,267 @@
+//
+// Streaming line reader for ndefence
+//
+
+package ndefenceIO
+
+//
+// Imports
+//
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+//
+// Globals
+//
+var (
+
+	// Default upper limit on the length of a single log line; anything
+	// longer is almost certainly garbage or an attack on the parser
+	DefaultMaxLineLength = 64 * 1024
+
+	// Size of the read buffer
+	readBufferSize = 64 * 1024
+)
+
+//
+// LineReader object definition
+//
+type LineReader struct {
+
+	// Underlying buffered reader
+	reader *bufio.Reader
+
+	// Lines longer than this, in bytes, are skipped
+	MaxLineLength int
+
+	// Number of lines skipped for exceeding the maximum length
+	Skipped int
+
+	// Number of bytes consumed so far, counting complete lines only
+	Offset int64
+
+	// Whether a final line that lacks a newline, e.g. one the server is
+	// still in the middle of writing, should be left unread
+	IgnorePartial bool
+
+	// Current line and the error that stopped the reader, if any
+	line string
+	err  error
+}
+
+// NewLineReader ... wrap a reader so that it can be consumed line by line
+/*
+ * @param     io.Reader      source of the data
+ * @param     int            maximum line length, in bytes; zero or less
+ *                           selects the default
+ *
+ * @return    *LineReader    line reader
+ */
+func NewLineReader(r io.Reader, maxLineLength int) *LineReader {
+
+	if maxLineLength < 1 {
+		maxLineLength = DefaultMaxLineLength
+	}
+
+	return &LineReader{
+		reader:        bufio.NewReaderSize(r, readBufferSize),
+		MaxLineLength: maxLineLength,
+	}
+}
+
+// Next ... advance to the next line, returning false once the data has
+// been exhausted or an error occurred
+/*
+ * @return    bool    whether a line is available via Line()
+ */
+func (lr *LineReader) Next() bool {
+
+	for lr.err == nil {
+
+		// variable declaration
+		var line []byte
+		var length int64
+		tooLong := false
+
+		// read until the newline, without holding on to more than the
+		// maximum line length
+		for {
+			chunk, err := lr.reader.ReadSlice('\n')
+			length += int64(len(chunk))
+
+			if !tooLong && len(line)+len(chunk) <= lr.MaxLineLength+2 {
+				line = append(line, chunk...)
+			} else {
+				tooLong = true
+				line = nil
+			}
+
+			if err == bufio.ErrBufferFull {
+				continue
+			}
+			if err != nil {
+				lr.err = err
+			}
+			break
+		}
+
+		// a line without a newline may still be in the middle of being
+		// written, so leave it for the next time if requested to
+		complete := lr.err == nil
+		if !complete && lr.IgnorePartial {
+			break
+		}
+		lr.Offset += length
+
+		// strip the LF, as well as the CR of CRLF line endings
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		line = bytes.TrimSuffix(line, []byte("\r"))
+
+		if tooLong || len(line) > lr.MaxLineLength {
+			lr.Skipped++
+			continue
+		}
+
+		// ignore the empty "line" after the final newline
+		if len(line) < 1 && lr.err != nil {
+			break
+		}
+
+		lr.line = string(line)
+		return true
+	}
+
+	return false
+}
+
+// Line ... the current line, without the line ending
+/*
+ * @return    string    line data
+ */
+func (lr *LineReader) Line() string {
+	return lr.line
+}
+
+// Err ... the error that stopped the reader, if it was not the end of the
+// data
+/*
+ * @return    error    error message, if any
+ */
+func (lr *LineReader) Err() error {
+	if lr.err == io.EOF {
+		return nil
+	}
+	return lr.err
+}
+
+// ReadLogFiles ... stream every line of a set of, possibly gzipped, log
+// files to the given function
+/*
+ * @param     string[]    /path/to/files, oldest first
+ * @param     int         maximum line length, in bytes
+ * @param     func        function to call with every line
+ *
+ * @return    int         number of lines skipped for being too long
+ * @return    error       error message, if any
+ */
+func ReadLogFiles(paths []string, maxLineLength int,
+	fn func(line string)) (int, error) {
+
+	segments := make([]LogSegment, 0, len(paths))
+	for _, path := range paths {
+		segments = append(segments, LogSegment{Path: path})
+	}
+
+	skipped, _, err := ReadLogSegments(segments, maxLineLength, false, fn)
+	return skipped, err
+}
+
+// ReadLogSegments ... stream every line of a set of, possibly gzipped, log
+// files to the given function, starting each file at the given offset
+/*
+ * @param     LogSegment[]    files and offsets, oldest first
+ * @param     int             maximum line length, in bytes
+ * @param     bool            whether to leave an incomplete final line of
+ *                            the last file unread
+ * @param     func            function to call with every line
+ *
+ * @return    int             number of lines skipped for being too long
+ * @return    int64           offset just past the last line read from the
+ *                            last file
+ * @return    error           error message, if any
+ */
+func ReadLogSegments(segments []LogSegment, maxLineLength int,
+	ignorePartial bool, fn func(line string)) (int, int64, error) {
+
+	// input validation
+	if len(segments) < 1 || fn == nil {
+		return 0, 0, fmt.Errorf("ReadLogSegments() --> invalid input")
+	}
+
+	// variable declaration
+	skipped := 0
+	var offset int64
+
+	for i, segment := range segments {
+
+		reader, err := openLogFileAt(segment.Path, segment.Offset)
+		if err != nil {
+			return skipped, 0, fmt.Errorf("ReadLogSegments() --> An "+
+				"error occurred while trying to read the following "+
+				"file: %s", segment.Path)
+		}
+
+		lines := NewLineReader(reader, maxLineLength)
+		lines.IgnorePartial = ignorePartial && i == len(segments)-1
+		for lines.Next() {
+			fn(lines.Line())
+		}
+		reader.Close()
+
+		skipped += lines.Skipped
+		offset = segment.Offset + lines.Offset
+		if lines.Err() != nil {
+			return skipped, 0, fmt.Errorf("ReadLogSegments() --> An "+
+				"error occurred while trying to read the following "+
+				"file: %s", segment.Path)
+		}
+	}
+
+	return skipped, offset, nil
+}
+
+//! Open a log file, then skip past the given number of bytes.
+/*
+ * @param     string           /path/to/file
+ * @param     int64            offset, in bytes
+ *
+ * @return    io.ReadCloser    file contents
+ * @return    error            error message, if any
+ */
+func openLogFileAt(path string, offset int64) (io.ReadCloser, error) {
+
+	reader, err := OpenLogFile(path)
+	if err != nil || offset < 1 {
+		return reader, err
+	}
+
+	// plain files can simply seek, whereas compressed ones must be read
+	if file, ok := reader.(*os.File); ok {
+		_, err = file.Seek(offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(ioutil.Discard, reader, offset)
+	}
+
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+
+	return reader, nil
+}
@@ -0,0 +1,146 @@
+//
+// Benchmarks of the streaming line reader of ndefence, e.g.
+//
+//     go test -run none -bench . ./ndefenceIO/
+//
+
+package ndefenceIO
+
+//
+// Imports
+//
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+//
+// Globals
+//
+var (
+
+	// Number of lines of the generated access log, roughly 8 MB worth
+	benchmarkLines = 50000
+)
+
+//! Generate an access log in the combined format, removed once the
+//! benchmark is done.
+/*
+ * @param     *testing.B    benchmark
+ *
+ * @return    string        /path/to/file
+ */
+func benchmarkLog(b *testing.B) string {
+
+	dir, err := ioutil.TempDir("", "ndefence-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "access.log")
+	file, err := os.Create(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer file.Close()
+
+	for i := 0; i < benchmarkLines; i++ {
+		_, err = fmt.Fprintf(file, "10.%d.%d.%d - - [17/Oct/2026:04:%02d:"+
+			"%02d +0000] \"GET /page/%d?query=%d HTTP/1.1\" 200 %d "+
+			"\"https://example.com/\" \"Mozilla/5.0 (X11; Linux x86_64; "+
+			"rv:120.0) Gecko/20100101 Firefox/120.0\"\n", i/65536%256,
+			i/256%256, i%256, i/60%60, i%60, i, i*7, 1000+i%5000)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	return path
+}
+
+//! Bytes of the heap in use after a garbage collection, so that only what
+//! is still referenced counts.
+/*
+ * @return    int64    bytes in use
+ */
+func liveHeap() int64 {
+
+	runtime.GC()
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return int64(stats.HeapAlloc)
+}
+
+// BenchmarkTokenizeFile ... read the whole log into memory, then split it;
+// every line is still held once the last one is reached
+func BenchmarkTokenizeFile(b *testing.B) {
+
+	path := benchmarkLog(b)
+	live := int64(0)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+
+		b.StopTimer()
+		before := liveHeap()
+		b.StartTimer()
+
+		lines, err := TokenizeFile(path, "\n")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(lines) < benchmarkLines {
+			b.Fatalf("expected %d lines, got: %d", benchmarkLines,
+				len(lines))
+		}
+
+		b.StopTimer()
+		live += liveHeap() - before
+		runtime.KeepAlive(lines)
+		b.StartTimer()
+	}
+
+	b.ReportMetric(float64(live)/float64(b.N), "live-B/op")
+}
+
+// BenchmarkLineReader ... stream the same log line by line; only the read
+// buffer and the current line are held once the last one is reached
+func BenchmarkLineReader(b *testing.B) {
+
+	path := benchmarkLog(b)
+	live := int64(0)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+
+		b.StopTimer()
+		before := liveHeap()
+		b.StartTimer()
+
+		count := 0
+		_, err := ReadLogFiles([]string{path}, 0, func(line string) {
+			count++
+			if count == benchmarkLines {
+				b.StopTimer()
+				live += liveHeap() - before
+				b.StartTimer()
+			}
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if count != benchmarkLines {
+			b.Fatalf("expected %d lines, got: %d", benchmarkLines, count)
+		}
+	}
+
+	b.ReportMetric(float64(live)/float64(b.N), "live-B/op")
+}
@@ -0,0 +1,163 @@
+//
+// Rotated log file functions for ndefence
+//
+
+package ndefenceIO
+
+//
+// Imports
+//
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+//
+// Globals
+//
+var (
+
+	// Suffixes logrotate appends to rotated files, e.g. access.log.1,
+	// access.log.2.gz or, with dateext, access.log-20261017.gz
+	rotatedSuffixRegex = regexp.MustCompile(
+		"^([.][0-9]{1,4}|-[0-9]{8,10})([.]gz)?$")
+)
+
+//
+// Wrapper that closes both the gzip stream and the underlying file
+//
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+// Close ... close the gzip stream, then the file
+func (g gzipFile) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
+}
+
+// ObtainRotatedLogFiles ... list a log file along with its rotated copies,
+// oldest first, skipping any that were last written before the look-back
+// window
+/*
+ * @param     string       /path/to/access.log
+ * @param     duration     look-back window; zero means no limit
+ * @param     time.Time    current time
+ *
+ * @return    string[]     paths, ordered from oldest to newest
+ * @return    error        error message, if any
+ */
+func ObtainRotatedLogFiles(path string, lookback time.Duration,
+	now time.Time) ([]string, error) {
+
+	// input validation
+	if len(path) < 1 {
+		return nil, fmt.Errorf("ObtainRotatedLogFiles() --> invalid input")
+	}
+
+	// find every file that starts with the name of the log
+	candidates, err := filepath.Glob(path + "*")
+	if err != nil {
+		return nil, fmt.Errorf("ObtainRotatedLogFiles() --> %s", err)
+	}
+
+	// variable declaration
+	rotated := make([]string, 0, len(candidates))
+	modTimes := make(map[string]time.Time, len(candidates))
+	windowStart := now.Add(-lookback)
+
+	for _, candidate := range candidates {
+
+		// skip anything that is not a rotated copy, e.g. access.log.bak
+		suffix := strings.TrimPrefix(candidate, path)
+		if suffix == "" || !rotatedSuffixRegex.MatchString(suffix) {
+			continue
+		}
+
+		info, err := os.Stat(candidate)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		// a file last written before the window only has older entries
+		if lookback > 0 && info.ModTime().Before(windowStart) {
+			continue
+		}
+
+		rotated = append(rotated, candidate)
+		modTimes[candidate] = info.ModTime()
+	}
+
+	// oldest first; logrotate numbers files such that a higher number is
+	// older, which is used when the modification times are identical
+	sort.SliceStable(rotated, func(i, j int) bool {
+		a, b := modTimes[rotated[i]], modTimes[rotated[j]]
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return rotated[i] > rotated[j]
+	})
+
+	// the live log is always the newest
+	if _, err := os.Stat(path); err == nil {
+		rotated = append(rotated, path)
+	}
+
+	// at least one file ought to be present
+	if len(rotated) < 1 {
+		return nil, fmt.Errorf("ObtainRotatedLogFiles() --> no log files "+
+			"were found at: %s", path)
+	}
+
+	return rotated, nil
+}
+
+// OpenLogFile ... open a log file for reading, transparently decompressing
+// it if it was gzipped
+/*
+ * @param     string           /path/to/file
+ *
+ * @return    io.ReadCloser    file contents
+ * @return    error            error message, if any
+ */
+func OpenLogFile(path string) (io.ReadCloser, error) {
+
+	// input validation
+	if len(path) < 1 {
+		return nil, fmt.Errorf("OpenLogFile() --> invalid input")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// check for the gzip magic number rather than trusting the name
+	magic := make([]byte, 2)
+	n, _ := io.ReadFull(file, magic)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if n < 2 || magic[0] != 0x1f || magic[1] != 0x8b {
+		return file, nil
+	}
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("OpenLogFile() --> unable to decompress "+
+			"%s: %s", path, err)
+	}
+
+	return gzipFile{Reader: reader, file: file}, nil
+}
@@ -0,0 +1,225 @@
+//
+// Log source functions for ndefence
+//
+
+package ndefenceIO
+
+//
+// Imports
+//
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//
+// Globals
+//
+var (
+
+	// Site name given to logs whose name does not mention a site
+	DefaultSiteName = "default"
+
+	// Suffixes stripped from a log name to obtain its site name, e.g.
+	// example.com.access.log --> example.com
+	siteLogSuffixes = []string{".access.log", "-access.log", "_access.log",
+		"access.log", ".log"}
+)
+
+//
+// LogSource object definition
+//
+type LogSource struct {
+
+	// Name of the site the log belongs to, e.g. "shop"
+	Site string
+
+	// Path or glob of the log, e.g. /var/log/nginx/shop*.access.log
+	Path string
+}
+
+// ParseLogSources ... parse a comma separated list of log sources, each
+// either a "site=path" pair or just a path, in which case the site is named
+// after the log, e.g.
+//
+// shop=/var/log/nginx/shop.access.log,/var/log/nginx/*.access.log
+/*
+ * @param     string         list of log sources
+ *
+ * @return    LogSource[]    log sources
+ * @return    error          error message, if any
+ */
+func ParseLogSources(list string) ([]LogSource, error) {
+
+	// variable declaration
+	sources := make([]LogSource, 0)
+
+	for _, item := range strings.Split(list, ",") {
+
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		source := LogSource{Path: item}
+		if i := strings.IndexByte(item, '='); i >= 0 {
+			source.Site = strings.TrimSpace(item[:i])
+			source.Path = strings.TrimSpace(item[i+1:])
+			if source.Site == "" {
+				return nil, fmt.Errorf("ParseLogSources() --> no site "+
+					"name given for: %s", source.Path)
+			}
+		}
+
+		if source.Path == "" {
+			return nil, fmt.Errorf("ParseLogSources() --> no path "+
+				"given for site: %s", source.Site)
+		}
+
+		// ensure the pattern is usable, so a typo is not silently ignored
+		if _, err := filepath.Match(source.Path, ""); err != nil {
+			return nil, fmt.Errorf("ParseLogSources() --> invalid "+
+				"pattern: %s", source.Path)
+		}
+
+		sources = append(sources, source)
+	}
+
+	return sources, nil
+}
+
+// ExpandLogSources ... expand the globs of the given log sources into the
+// logs currently present, skipping rotated copies, which are read along
+// with the log they were rotated from; a log matched by more than one
+// source belongs to the first
+/*
+ * @param     LogSource[]    log sources
+ *
+ * @return    LogSource[]    one source per log, ordered by path
+ * @return    error          error message, if any
+ */
+func ExpandLogSources(sources []LogSource) ([]LogSource, error) {
+
+	// variable declaration
+	expanded := make([]LogSource, 0)
+	seen := make(map[string]bool)
+
+	for _, source := range sources {
+
+		paths, err := filepath.Glob(source.Path)
+		if err != nil {
+			return nil, fmt.Errorf("ExpandLogSources() --> invalid "+
+				"pattern: %s", source.Path)
+		}
+
+		// a plain path is kept even if it does not exist yet
+		if len(paths) == 0 && !strings.ContainsAny(source.Path, "*?[") {
+			paths = []string{source.Path}
+		}
+
+		for _, path := range paths {
+
+			if seen[path] || isRotatedCopy(path, paths) {
+				continue
+			}
+			seen[path] = true
+
+			site := source.Site
+			if site == "" {
+				site = SiteFromLogName(path)
+			}
+
+			expanded = append(expanded, LogSource{Site: site, Path: path})
+		}
+	}
+
+	sort.SliceStable(expanded, func(i, j int) bool {
+		return expanded[i].Path < expanded[j].Path
+	})
+
+	return expanded, nil
+}
+
+// SiteFromLogName ... name a site after its log, e.g.
+// /var/log/nginx/example.com.access.log --> example.com
+/*
+ * @param     string    /path/to/site.access.log
+ *
+ * @return    string    site name
+ */
+func SiteFromLogName(path string) string {
+
+	name := filepath.Base(path)
+	for _, suffix := range siteLogSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			name = strings.TrimSuffix(name, suffix)
+			break
+		}
+	}
+
+	if name == "" {
+		return DefaultSiteName
+	}
+
+	return name
+}
+
+// ParseSiteThresholds ... parse a comma separated list of "site=count"
+// pairs, e.g. "shop=50,blog=10"
+/*
+ * @param     string    list of thresholds
+ *
+ * @return    map       map[site] = threshold
+ * @return    error     error message, if any
+ */
+func ParseSiteThresholds(list string) (map[string]int, error) {
+
+	// variable declaration
+	thresholds := make(map[string]int)
+
+	for _, item := range strings.Split(list, ",") {
+
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		pair := strings.SplitN(item, "=", 2)
+		if len(pair) != 2 || strings.TrimSpace(pair[0]) == "" {
+			return nil, fmt.Errorf("ParseSiteThresholds() --> expected "+
+				"site=count, got: %s", item)
+		}
+
+		count, err := strconv.Atoi(strings.TrimSpace(pair[1]))
+		if err != nil || count < 0 {
+			return nil, fmt.Errorf("ParseSiteThresholds() --> invalid "+
+				"count for site %s: %s", pair[0], pair[1])
+		}
+
+		thresholds[strings.TrimSpace(pair[0])] = count
+	}
+
+	return thresholds, nil
+}
+
+//! Whether the path is a rotated copy of another of the given paths.
+/*
+ * @param     string      path
+ * @param     string[]    paths matched by the same glob
+ *
+ * @return    bool        whether or not this is true
+ */
+func isRotatedCopy(path string, paths []string) bool {
+
+	for _, other := range paths {
+		if other != path && strings.HasPrefix(path, other) &&
+			rotatedSuffixRegex.MatchString(path[len(other):]) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,211 @@
+//
+// Persisted read state of the log files for ndefence
+//
+
+package ndefenceIO
+
+//
+// Imports
+//
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+//
+// LogState object definition
+//
+type LogState struct {
+
+	// Location of the log this state belongs to
+	Path string `json:"path"`
+
+	// Identity of the file that was being read, used to detect rotation
+	Inode  uint64 `json:"inode"`
+	Device uint64 `json:"device"`
+
+	// Number of bytes of that file that were already analyzed
+	Offset int64 `json:"offset"`
+
+	// Time of the newest entry analyzed
+	LastTimestamp time.Time `json:"last_timestamp"`
+}
+
+//
+// LogSegment object definition
+//
+type LogSegment struct {
+
+	// Location of the file
+	Path string
+
+	// Number of bytes at the start of the file to skip
+	Offset int64
+}
+
+// ReadLogState ... read the persisted state of a given log; a missing state
+// file is not an error, it simply means the log has not been read before
+/*
+ * @param     string      /path/to/state/directory
+ * @param     string      /path/to/access.log
+ *
+ * @return    LogState    state of the log
+ * @return    error       error message, if any
+ */
+func ReadLogState(stateDir string, logPath string) (LogState, error) {
+
+	// input validation
+	if len(stateDir) < 1 || len(logPath) < 1 {
+		return LogState{}, fmt.Errorf("ReadLogState() --> invalid input")
+	}
+
+	contents, err := ioutil.ReadFile(StatePathForLog(stateDir, logPath))
+	if os.IsNotExist(err) {
+		return LogState{Path: logPath}, nil
+	}
+	if err != nil {
+		return LogState{}, err
+	}
+
+	// a corrupt state file is treated as a log read for the first time
+	state := LogState{}
+	if err := json.Unmarshal(contents, &state); err != nil ||
+		state.Path != logPath {
+		return LogState{Path: logPath}, nil
+	}
+
+	return state, nil
+}
+
+// WriteLogState ... persist the state of a given log
+/*
+ * @param     string      /path/to/state/directory
+ * @param     LogState    state of the log
+ *
+ * @return    error       error message, if any
+ */
+func WriteLogState(stateDir string, state LogState) error {
+
+	// input validation
+	if len(stateDir) < 1 || len(state.Path) < 1 {
+		return fmt.Errorf("WriteLogState() --> invalid input")
+	}
+
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return err
+	}
+
+	contents, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first, so that an interrupted run never
+	// leaves a half written state behind
+	path := StatePathForLog(stateDir, state.Path)
+	err = ioutil.WriteFile(path+".tmp", contents, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// StatePathForLog ... location of the state file of a given log
+/*
+ * @param     string    /path/to/state/directory
+ * @param     string    /path/to/access.log
+ *
+ * @return    string    /path/to/state/directory/var_log_nginx_access.log.state
+ */
+func StatePathForLog(stateDir string, logPath string) string {
+
+	name := strings.Trim(filepath.Clean(logPath), string(filepath.Separator))
+	name = strings.Replace(name, string(filepath.Separator), "_", -1)
+
+	return filepath.Join(stateDir, name+".state")
+}
+
+// ObtainFileIdentity ... obtain the inode and device of a given file
+/*
+ * @param     string    /path/to/file
+ *
+ * @return    uint64    inode
+ * @return    uint64    device
+ * @return    int64     size, in bytes
+ * @return    error     error message, if any
+ */
+func ObtainFileIdentity(path string) (uint64, uint64, int64, error) {
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("ObtainFileIdentity() --> unable to "+
+			"obtain the inode of: %s", path)
+	}
+
+	return uint64(stat.Ino), uint64(stat.Dev), info.Size(), nil
+}
+
+// PlanIncrementalRead ... determine which part of a set of log files has
+// not been analyzed yet
+/*
+ * @param     string[]        /path/to/files, oldest first
+ * @param     LogState        state of the log
+ *
+ * @return    LogSegment[]    files and offsets to read, oldest first
+ * @return    bool            whether the previous position was found; if
+ *                            not, entries at or before the last timestamp
+ *                            of the state ought to be skipped instead
+ */
+func PlanIncrementalRead(paths []string, state LogState) ([]LogSegment,
+	bool) {
+
+	// look for the file that was being read last time; after a rotation
+	// this will be e.g. access.log.1 rather than access.log
+	resumeAt := -1
+	if state.Inode != 0 {
+		for i, path := range paths {
+
+			inode, device, size, err := ObtainFileIdentity(path)
+			if err != nil || inode != state.Inode || device != state.Device {
+				continue
+			}
+
+			// a file smaller than the offset was truncated, e.g. by the
+			// logrotate copytruncate option, in which case the lines not
+			// yet read are now in one of the copies
+			if size >= state.Offset {
+				resumeAt = i
+			}
+			break
+		}
+	}
+
+	// not found, so every file has to be read
+	if resumeAt < 0 {
+		segments := make([]LogSegment, 0, len(paths))
+		for _, path := range paths {
+			segments = append(segments, LogSegment{Path: path})
+		}
+		return segments, false
+	}
+
+	// otherwise continue where the last run left off
+	segments := []LogSegment{{Path: paths[resumeAt], Offset: state.Offset}}
+	for _, path := range paths[resumeAt+1:] {
+		segments = append(segments, LogSegment{Path: path})
+	}
+
+	return segments, true
+}
@@ -0,0 +1,162 @@
+//
+// User agent classification for ndefence
+//
+
+package ndefenceLog
+
+//
+// Imports
+//
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+//
+// Globals
+//
+var (
+
+	// Class of the requests that sent no user agent at all
+	AgentEmpty = "empty"
+
+	// Class of the user agents matching no pattern
+	AgentUnknown = "unknown"
+
+	// Patterns of the well known user agents, in the same format as the
+	// agent files, i.e. a class and a regular expression per line; the
+	// first pattern to match decides the class, so the tools that pose
+	// as browsers come first
+	BuiltinAgentPatterns = `
+# class     pattern
+scanner     (?i)sqlmap|nikto|masscan|zgrab|nmap|nuclei|wpscan|acunetix|nessus|openvas|dirbuster|gobuster|feroxbuster|ffuf|wfuzz|whatweb|zmeu|l9explore|censysinspect|netsparker|jorgee
+scraper     (?i)python-requests|python-urllib|aiohttp|python-httpx|scrapy|libwww-perl
+headless    (?i)headlesschrome|phantomjs|puppeteer|playwright
+library     (?i)go-http-client|^java/|okhttp|apache-httpclient|node-fetch|axios/
+tool        (?i)^(curl|wget|httpie)/|postmanruntime
+crawler     (?i)googlebot|bingbot|duckduckbot|baiduspider|yandexbot|applebot|slurp|facebookexternalhit|twitterbot|linkedinbot|ia_archiver|archive\.org_bot
+browser     ^Mozilla/5\.0 \(
+`
+)
+
+//
+// AgentClassifier object definition
+//
+type AgentClassifier struct {
+	patterns []agentPattern
+}
+
+//
+// Pattern deciding the class of the user agents it matches
+//
+type agentPattern struct {
+	class   string
+	pattern *regexp.Regexp
+}
+
+// NewAgentClassifier ... assemble a classifier of user agents, using the
+// patterns of the given file ahead of the built-in ones
+/*
+ * @param     string              /path/to/agents/file; may be empty
+ *
+ * @return    *AgentClassifier    classifier
+ * @return    error               error message, if any
+ */
+func NewAgentClassifier(path string) (*AgentClassifier, error) {
+
+	builtin, err := parseAgentPatterns(BuiltinAgentPatterns)
+	if err != nil {
+		return nil, err
+	}
+
+	if path == "" {
+		return &AgentClassifier{patterns: builtin}, nil
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("NewAgentClassifier() --> %s", err)
+	}
+
+	patterns, err := parseAgentPatterns(string(contents))
+	if err != nil {
+		return nil, fmt.Errorf("NewAgentClassifier() --> %s: %s", path,
+			err)
+	}
+
+	return &AgentClassifier{patterns: append(patterns, builtin...)}, nil
+}
+
+// Classify ... class of a given user agent, e.g. "crawler" or "scanner"
+/*
+ * @param     string    user agent, as logged
+ *
+ * @return    string    class of the user agent
+ */
+func (c *AgentClassifier) Classify(agent string) string {
+
+	// servers log a missing header as a dash
+	agent = strings.TrimSpace(agent)
+	if agent == "" || agent == "-" {
+		return AgentEmpty
+	}
+
+	for _, candidate := range c.patterns {
+		if candidate.pattern.MatchString(agent) {
+			return candidate.class
+		}
+	}
+
+	return AgentUnknown
+}
+
+//! Parse a list of user agent patterns, one per line, each a class and a
+//! regular expression separated by whitespace; blank lines and lines
+//! starting with # are skipped.
+/*
+ * @param     string            patterns
+ *
+ * @return    agentPattern[]    parsed patterns
+ * @return    error             error message naming the offending line
+ */
+func parseAgentPatterns(text string) ([]agentPattern, error) {
+
+	// variable declaration
+	patterns := make([]agentPattern, 0)
+	lineNumber := 0
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// the pattern is the rest of the line, spaces and all
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: expected a class and a "+
+				"pattern", lineNumber)
+		}
+		rest := strings.TrimSpace(line[len(fields[0]):])
+
+		pattern, err := regexp.Compile(rest)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNumber, err)
+		}
+
+		patterns = append(patterns, agentPattern{class: fields[0],
+			pattern: pattern})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return patterns, nil
+}
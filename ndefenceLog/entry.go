@@ -0,0 +1,90 @@
+//
+// Access log entry definition for ndefence
+//
+
+package ndefenceLog
+
+//
+// Imports
+//
+import (
+	"time"
+)
+
+//
+// LogEntry object definition
+//
+type LogEntry struct {
+
+	// Address of the client that made the request
+	ClientIP string
+
+	// RFC 1413 identity of the client, usually "-"
+	Ident string
+
+	// Authenticated user, usually "-"
+	User string
+
+	// Time the request was received, including the zone of the server
+	Time time.Time
+
+	// Request line pieces, e.g. "GET", "/index.html" and "HTTP/1.1"
+	Method   string
+	Path     string
+	Protocol string
+
+	// HTTP status code returned to the client
+	Status int
+
+	// Size of the response body, in bytes
+	Bytes int64
+
+	// Referer and User-Agent headers; only present in "combined" logs
+	Referer   string
+	UserAgent string
+
+	// Class of the user agent, e.g. "browser" or "scanner", as decided by
+	// an AgentClassifier; empty until the entry is classified
+	Agent string
+
+	// Target of a redirect, i.e. the Location header sent; only present in
+	// logs recording $sent_http_location
+	Location string
+
+	// Class of the redirect, e.g. "internal" or "open", as decided by a
+	// RedirectClassifier; empty if the entry is not a redirect
+	Redirect string
+
+	// Every variable captured by a custom format, keyed by the nginx name
+	// of the variable, e.g. "request_time" or "upstream_addr"
+	Fields map[string]string
+
+	// Original line, as read from the log
+	Raw string
+
+	// Site the entry was logged for, as tagged by the log source
+	Site string
+
+	// Address of the trusted proxy that forwarded the request, if the
+	// client address was taken from a forwarding header instead
+	ProxyIP string
+}
+
+// RequestLine ... reassemble the request line of a given entry
+/*
+ * @return    string    request line, e.g. "GET /index.html HTTP/1.1"
+ */
+func (entry LogEntry) RequestLine() string {
+
+	// requests that could not be split are stored as-is in the path
+	if entry.Method == "" {
+		return entry.Path
+	}
+
+	// append the protocol, if the client actually sent one
+	if entry.Protocol == "" {
+		return entry.Method + " " + entry.Path
+	}
+
+	return entry.Method + " " + entry.Path + " " + entry.Protocol
+}
@@ -0,0 +1,288 @@
+//
+// Error log parsing functions for ndefence
+//
+
+package ndefenceLog
+
+//
+// Imports
+//
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+//
+// Globals
+//
+var (
+
+	// Layout of the time at the start of an nginx error log line
+	NginxErrorTimeLayout = "2006/01/02 15:04:05"
+
+	// Layouts of the time at the start of an apache error log line; 2.4
+	// includes microseconds while 2.2 does not
+	ApacheErrorTimeLayouts = []string{
+		"Mon Jan 02 15:04:05.000000 2006",
+		"Mon Jan 02 15:04:05 2006",
+	}
+
+	// Error categories, and the message fragments that identify them; the
+	// first matching category wins
+	ErrorCategories = []ErrorCategory{
+		{"modsecurity", []string{"modsecurity", "mod_security"}},
+		{"forbidden", []string{"access forbidden by rule",
+			"client denied by server configuration", "is forbidden",
+			"ah01630", "ah01797", "(13: permission denied)"}},
+		{"not-found", []string{"(2: no such file or directory)",
+			"file does not exist", "script not found",
+			"(20: not a directory)", "ah00128"}},
+		{"invalid-method", []string{"client sent invalid method",
+			"invalid method in request", "ah00135"}},
+		{"invalid-request", []string{"client sent invalid request",
+			"client sent invalid host", "invalid uri in request",
+			"invalid host in request", "client sent plain http request",
+			"request header too large", "ah00126", "ah00566"}},
+		{"auth", []string{"was not found in", "password mismatch",
+			"no user/password was provided", "user not found",
+			"ah01617", "ah01618"}},
+	}
+
+	// Pieces of an nginx error log line, e.g. the ", client: 1.2.3.4"
+	nginxClientRegex  = regexp.MustCompile(`, client: ([^,]+)`)
+	nginxPrefixRegex  = regexp.MustCompile(`^[0-9]+#[0-9]+: (\*[0-9]+ )?`)
+	apacheClientRegex = regexp.MustCompile(`\[(?:client|remote) ([^\]]+)\]`)
+)
+
+//
+// ErrorCategory object definition
+//
+type ErrorCategory struct {
+
+	// Short name of the category, e.g. "not-found"
+	Name string
+
+	// Lower case fragments of the messages that belong to this category
+	Fragments []string
+}
+
+//
+// ErrorEntry object definition
+//
+type ErrorEntry struct {
+
+	// Time the error was logged
+	Time time.Time
+
+	// Severity of the error, e.g. "error" or "warn"
+	Severity string
+
+	// Address of the client that caused the error, if any
+	ClientIP string
+
+	// Message of the error, without the client / server / request details
+	Message string
+
+	// Category of the error, e.g. "forbidden", as per ErrorCategories, or
+	// "other" if no category matched
+	Category string
+
+	// Original line, as read from the log
+	Raw string
+}
+
+// ParseErrorLine ... parse an error log line of the given server type
+/*
+ * @param     string        server type (nginx, apache)
+ * @param     string        line data
+ *
+ * @return    ErrorEntry    parsed entry
+ * @return    error         error message, if any
+ */
+func ParseErrorLine(serverType string, line string) (ErrorEntry, error) {
+
+	switch serverType {
+	case "nginx":
+		return ParseNginxErrorLine(line)
+	case "apache":
+		return ParseApacheErrorLine(line)
+	}
+
+	return ErrorEntry{}, fmt.Errorf("ParseErrorLine() --> unknown server "+
+		"type: %s", serverType)
+}
+
+// ParseNginxErrorLine ... parse a line of the nginx error.log, e.g.
+//
+// 2026/10/17 08:30:00 [error] 1234#1234: *5 open() "/var/www/x" failed
+// (2: No such file or directory), client: 1.2.3.4, server: _, ...
+/*
+ * @param     string        line data
+ *
+ * @return    ErrorEntry    parsed entry
+ * @return    error         error message, if any
+ */
+func ParseNginxErrorLine(line string) (ErrorEntry, error) {
+
+	// input validation
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) < len(NginxErrorTimeLayout)+3 {
+		return ErrorEntry{}, fmt.Errorf("ParseNginxErrorLine() --> " +
+			"invalid input")
+	}
+
+	// nginx logs the local time, without a zone
+	t, err := time.ParseInLocation(NginxErrorTimeLayout,
+		line[:len(NginxErrorTimeLayout)], time.Local)
+	if err != nil {
+		return ErrorEntry{}, fmt.Errorf("ParseNginxErrorLine() --> "+
+			"unable to parse time: %s", line)
+	}
+
+	// the severity follows in brackets
+	rest := strings.TrimSpace(line[len(NginxErrorTimeLayout):])
+	end := strings.IndexByte(rest, ']')
+	if !strings.HasPrefix(rest, "[") || end < 0 {
+		return ErrorEntry{}, fmt.Errorf("ParseNginxErrorLine() --> no " +
+			"severity present")
+	}
+
+	entry := ErrorEntry{
+		Time:     t,
+		Severity: rest[1:end],
+		Raw:      line,
+	}
+
+	// then the pid#tid: *connection prefix, followed by the message
+	message := nginxPrefixRegex.ReplaceAllString(
+		strings.TrimSpace(rest[end+1:]), "")
+
+	// the client, server, request and so on are appended to the message
+	if match := nginxClientRegex.FindStringSubmatchIndex(message); match != nil {
+		entry.ClientIP = message[match[2]:match[3]]
+		message = message[:match[0]]
+	}
+
+	entry.Message = message
+	entry.Category = ClassifyErrorMessage(message)
+
+	return entry, nil
+}
+
+// ParseApacheErrorLine ... parse a line of the apache error.log, e.g.
+//
+// [Sat Oct 17 08:30:00.123456 2026] [core:error] [pid 1234] [client
+// 1.2.3.4:5678] AH00128: File does not exist: /var/www/html/x
+/*
+ * @param     string        line data
+ *
+ * @return    ErrorEntry    parsed entry
+ * @return    error         error message, if any
+ */
+func ParseApacheErrorLine(line string) (ErrorEntry, error) {
+
+	// input validation
+	line = strings.TrimRight(line, "\r\n")
+	end := strings.IndexByte(line, ']')
+	if !strings.HasPrefix(line, "[") || end < 0 {
+		return ErrorEntry{}, fmt.Errorf("ParseApacheErrorLine() --> " +
+			"invalid input")
+	}
+
+	// apache logs the local time, without a zone
+	entry := ErrorEntry{Raw: line}
+	err := fmt.Errorf("no layout matched")
+	for _, layout := range ApacheErrorTimeLayouts {
+		entry.Time, err = time.ParseInLocation(layout, line[1:end],
+			time.Local)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return ErrorEntry{}, fmt.Errorf("ParseApacheErrorLine() --> "+
+			"unable to parse time: %s", line)
+	}
+
+	// the remaining [bracketed] fields hold the severity, pid and client
+	rest := strings.TrimSpace(line[end+1:])
+	for strings.HasPrefix(rest, "[") {
+
+		end = strings.IndexByte(rest, ']')
+		if end < 0 {
+			break
+		}
+		field := rest[1:end]
+
+		switch {
+
+		// 2.4 logs [module:severity], whereas 2.2 logs [severity]
+		case entry.Severity == "" && !strings.Contains(field, " "):
+			pieces := strings.Split(field, ":")
+			entry.Severity = pieces[len(pieces)-1]
+
+		case apacheClientRegex.MatchString("[" + field + "]"):
+			address := apacheClientRegex.FindStringSubmatch(
+				"[" + field + "]")[1]
+			entry.ClientIP = stripPort(address)
+		}
+
+		rest = strings.TrimSpace(rest[end+1:])
+	}
+
+	entry.Message = rest
+	entry.Category = ClassifyErrorMessage(rest)
+
+	return entry, nil
+}
+
+// ClassifyErrorMessage ... determine the category of an error message
+/*
+ * @param     string    message
+ *
+ * @return    string    category, as per ErrorCategories, or "other"
+ */
+func ClassifyErrorMessage(message string) string {
+
+	lower := strings.ToLower(message)
+	for _, category := range ErrorCategories {
+		for _, fragment := range category.Fragments {
+			if strings.Contains(lower, fragment) {
+				return category.Name
+			}
+		}
+	}
+
+	return "other"
+}
+
+//! Remove the port from an "address:port" pair, if there is one.
+/*
+ * @param     string    e.g. "1.2.3.4:5678" or "2001:db8::1:5678"
+ *
+ * @return    string    address
+ */
+func stripPort(address string) string {
+
+	// a plain address, with no port at all; an IPv6 address followed by a
+	// port can look just like one, in which case it is kept as is
+	if net.ParseIP(address) != nil && strings.Count(address, ":") != 1 {
+		return address
+	}
+
+	// e.g. [2001:db8::1]:5678 or 1.2.3.4:5678
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+
+	// apache appends the port to IPv6 addresses without brackets
+	if i := strings.LastIndexByte(address, ':'); i > 0 &&
+		net.ParseIP(address[:i]) != nil {
+		return address[:i]
+	}
+
+	return address
+}
@@ -0,0 +1,645 @@
+//
+// User-definable log format templates for ndefence
+//
+
+package ndefenceLog
+
+//
+// Imports
+//
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+// Globals
+//
+var (
+
+	// Default nginx "combined" log_format
+	NginxCombinedFormat = "$remote_addr - $remote_user [$time_local] " +
+		"\"$request\" $status $body_bytes_sent \"$http_referer\" " +
+		"\"$http_user_agent\""
+
+	// Default apache "combined" LogFormat
+	ApacheCombinedFormat = "%h %l %u %t \"%r\" %>s %b \"%{Referer}i\" " +
+		"\"%{User-agent}i\""
+
+	// Apache format directives and the nginx variable they correspond to
+	apacheDirectives = map[byte]string{
+		'a': "remote_addr",
+		'A': "server_addr",
+		'b': "body_bytes_sent",
+		'B': "body_bytes_sent",
+		'D': "request_time_us",
+		'f': "request_filename",
+		'h': "remote_addr",
+		'H': "server_protocol",
+		'l': "remote_ident",
+		'm': "request_method",
+		'O': "bytes_sent",
+		'p': "server_port",
+		'q': "args",
+		'r': "request",
+		's': "status",
+		't': "time_local",
+		'T': "request_time",
+		'u': "remote_user",
+		'U': "uri",
+		'v': "server_name",
+		'V': "host",
+	}
+)
+
+// Parser ... anything capable of turning a log line into a LogEntry
+type Parser interface {
+	Parse(line string) (LogEntry, error)
+}
+
+// ParserFunc ... adapter to allow ordinary functions to be used as a Parser
+type ParserFunc func(line string) (LogEntry, error)
+
+// Parse ... call the underlying function
+func (f ParserFunc) Parse(line string) (LogEntry, error) {
+	return f(line)
+}
+
+//
+// Format object definition
+//
+type Format struct {
+
+	// Original template string, kept for error messages
+	Template string
+
+	// Alternating sequence of literals and variables
+	tokens []formatToken
+}
+
+//
+// Single piece of a compiled format; either a literal or a variable
+//
+type formatToken struct {
+	literal  string
+	variable string
+}
+
+// NewParser ... select a parser for the given server type and format; an
+// empty format falls back to the built-in combined / common parser, or to
+// JSON for lines that look like a JSON object
+/*
+ * @param     string    server type (nginx, apache)
+ * @param     string    log_format or LogFormat string, "json", or empty
+ * @param     map       map[nginx variable name] = JSON key, if any
+ *
+ * @return    Parser    parser to use for every line of the access log
+ * @return    error     error message, if any
+ */
+func NewParser(serverType string, format string,
+	jsonKeys map[string]string) (Parser, error) {
+
+	jsonParser := NewJSONParser(jsonKeys)
+
+	// no format given, so use the built-in parsers
+	if strings.TrimSpace(format) == "" {
+		return ParserFunc(func(line string) (LogEntry, error) {
+			if IsJSONLine(line) {
+				return jsonParser.Parse(line)
+			}
+			return ParseLine(line)
+		}), nil
+	}
+
+	// one JSON object per line
+	if strings.TrimSpace(format) == JSONFormatName {
+		return jsonParser, nil
+	}
+
+	switch serverType {
+	case "nginx":
+		return CompileNginxFormat(format)
+	case "apache":
+		return CompileApacheFormat(format)
+	}
+
+	return nil, fmt.Errorf("NewParser() --> unknown server type: %s",
+		serverType)
+}
+
+// CompileNginxFormat ... compile an nginx log_format string into a parser
+/*
+ * @param     string    format, e.g. "$remote_addr [$time_local] ..."; a
+ *                      complete "log_format name '...' '...';" directive is
+ *                      also accepted
+ *
+ * @return    *Format   compiled format
+ * @return    error     error message, if any
+ */
+func CompileNginxFormat(format string) (*Format, error) {
+
+	// input validation
+	template := unwrapNginxDirective(format)
+	if len(template) < 1 {
+		return nil, fmt.Errorf("CompileNginxFormat() --> invalid input")
+	}
+
+	// variable declaration
+	tokens := make([]formatToken, 0)
+	literal := ""
+
+	for i := 0; i < len(template); i++ {
+
+		// anything other than a $ is part of a literal
+		if template[i] != '$' {
+			literal += string(template[i])
+			continue
+		}
+
+		// variables are either $name or ${name}
+		name := ""
+		if i+1 < len(template) && template[i+1] == '{' {
+			end := strings.IndexByte(template[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("CompileNginxFormat() --> "+
+					"unterminated variable in: %s", template)
+			}
+			name = template[i+2 : i+end]
+			i += end
+		} else {
+			j := i + 1
+			for j < len(template) && isVariableChar(template[j]) {
+				j++
+			}
+			name = template[i+1 : j]
+			i = j - 1
+		}
+
+		// a lone $ is treated as a literal
+		if name == "" {
+			literal += "$"
+			continue
+		}
+
+		tokens, literal = appendVariable(tokens, literal, name)
+	}
+
+	if literal != "" {
+		tokens = append(tokens, formatToken{literal: literal})
+	}
+
+	return newFormat(template, tokens)
+}
+
+// CompileApacheFormat ... compile an apache LogFormat string into a parser
+/*
+ * @param     string    format, e.g. "%h %l %u %t \"%r\" %>s %b"; a
+ *                      complete `LogFormat "..." nickname` directive is also
+ *                      accepted
+ *
+ * @return    *Format   compiled format
+ * @return    error     error message, if any
+ */
+func CompileApacheFormat(format string) (*Format, error) {
+
+	// input validation
+	template := unwrapApacheDirective(format)
+	if len(template) < 1 {
+		return nil, fmt.Errorf("CompileApacheFormat() --> invalid input")
+	}
+
+	// variable declaration
+	tokens := make([]formatToken, 0)
+	literal := ""
+
+	for i := 0; i < len(template); i++ {
+
+		// anything other than a % is part of a literal
+		if template[i] != '%' {
+			literal += string(template[i])
+			continue
+		}
+
+		// skip past the status code conditions and the < > modifiers,
+		// e.g. %!200,304h or %>s
+		j := i + 1
+		for j < len(template) &&
+			strings.IndexByte("<>!,0123456789", template[j]) >= 0 {
+			j++
+		}
+		if j >= len(template) {
+			return nil, fmt.Errorf("CompileApacheFormat() --> trailing "+
+				"%% in: %s", template)
+		}
+
+		// a literal percent sign
+		if template[j] == '%' {
+			literal += "%"
+			i = j
+			continue
+		}
+
+		// directives with an argument, e.g. %{User-agent}i
+		argument := ""
+		if template[j] == '{' {
+			end := strings.IndexByte(template[j:], '}')
+			if end < 0 || j+end+1 >= len(template) {
+				return nil, fmt.Errorf("CompileApacheFormat() --> "+
+					"unterminated directive in: %s", template)
+			}
+			argument = template[j+1 : j+end]
+			j += end + 1
+		}
+
+		name, err := apacheVariableName(template[j], argument)
+		if err != nil {
+			return nil, err
+		}
+		i = j
+
+		tokens, literal = appendVariable(tokens, literal, name)
+	}
+
+	if literal != "" {
+		tokens = append(tokens, formatToken{literal: literal})
+	}
+
+	return newFormat(template, tokens)
+}
+
+// Parse ... parse a single line using the compiled format
+/*
+ * @param     string      line data
+ *
+ * @return    LogEntry    parsed entry
+ * @return    error       error message, if any
+ */
+func (f *Format) Parse(line string) (LogEntry, error) {
+
+	// input validation
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) < 1 {
+		return LogEntry{}, fmt.Errorf("Parse() --> invalid input")
+	}
+
+	// variable declaration
+	variables := make(map[string]string, len(f.tokens))
+	pos := 0
+
+	for i, token := range f.tokens {
+
+		// literals must appear exactly where the template says so
+		if token.variable == "" {
+			if !strings.HasPrefix(line[pos:], token.literal) {
+				return LogEntry{}, fmt.Errorf("Parse() --> line does "+
+					"not match the format near: %.32q", line[pos:])
+			}
+			pos += len(token.literal)
+			continue
+		}
+
+		// the last variable takes the remainder of the line
+		if i+1 >= len(f.tokens) {
+			variables[token.variable] = line[pos:]
+			pos = len(line)
+			break
+		}
+
+		// otherwise the variable runs until the following literal; quoted
+		// values may contain escaped quotes, so skip over those
+		next := f.tokens[i+1].literal
+		quoted := i > 0 && strings.HasSuffix(f.tokens[i-1].literal, "\"")
+		end := indexLiteral(line[pos:], next, quoted)
+		if end < 0 {
+			return LogEntry{}, fmt.Errorf("Parse() --> unable to find the "+
+				"end of $%s", token.variable)
+		}
+
+		value := line[pos : pos+end]
+		if quoted {
+			value = unescape(value)
+		}
+
+		variables[token.variable] = value
+		pos += end
+	}
+
+	return EntryFromVariables(line, variables)
+}
+
+// EntryFromVariables ... assemble a LogEntry from a set of nginx variables
+/*
+ * @param     string      line data
+ * @param     map         map[variable name] = value
+ *
+ * @return    LogEntry    parsed entry
+ * @return    error       error message, if any
+ */
+func EntryFromVariables(line string,
+	variables map[string]string) (LogEntry, error) {
+
+	// variable declaration
+	var err error
+	entry := LogEntry{
+		ClientIP:  variables["remote_addr"],
+		Ident:     valueOrDash(variables["remote_ident"]),
+		User:      valueOrDash(variables["remote_user"]),
+		Referer:   variables["http_referer"],
+		UserAgent: variables["http_user_agent"],
+		Location:  variables["sent_http_location"],
+		Fields:    variables,
+		Raw:       line,
+	}
+
+	// the client address is what everything else is keyed on
+	if entry.ClientIP == "" {
+		return LogEntry{}, fmt.Errorf("EntryFromVariables() --> no " +
+			"client address present")
+	}
+
+	// time of the request, in whichever form the format provided
+	switch {
+	case variables["time_local"] != "":
+		entry.Time, err = ParseTimeLocal(variables["time_local"])
+	case variables["time_iso8601"] != "":
+		entry.Time, err = time.Parse(time.RFC3339,
+			variables["time_iso8601"])
+	case variables["msec"] != "":
+		var msec float64
+		msec, err = strconv.ParseFloat(variables["msec"], 64)
+		entry.Time = time.Unix(0, int64(msec*float64(time.Second)))
+	default:
+		err = fmt.Errorf("no time variable present")
+	}
+	if err != nil {
+		return LogEntry{}, fmt.Errorf("EntryFromVariables() --> %s", err)
+	}
+
+	// request line, or the individual pieces of it
+	if variables["request"] != "" {
+		entry.Method, entry.Path, entry.Protocol =
+			ParseRequestLine(variables["request"])
+	} else {
+		entry.Method = variables["request_method"]
+		entry.Path = variables["request_uri"]
+		entry.Protocol = variables["server_protocol"]
+		if entry.Path == "" {
+			entry.Path = variables["uri"]
+			if variables["args"] != "" {
+				entry.Path += "?" + strings.TrimPrefix(variables["args"],
+					"?")
+			}
+		}
+	}
+
+	entry.Status, err = strconv.Atoi(variables["status"])
+	if err != nil {
+		return LogEntry{}, fmt.Errorf("EntryFromVariables() --> invalid "+
+			"status code: %q", variables["status"])
+	}
+
+	// a "-" or missing value means no body was sent
+	bytesSent := variables["body_bytes_sent"]
+	if bytesSent == "" {
+		bytesSent = variables["bytes_sent"]
+	}
+	if bytesSent != "" && bytesSent != "-" {
+		entry.Bytes, err = strconv.ParseInt(bytesSent, 10, 64)
+		if err != nil {
+			return LogEntry{}, fmt.Errorf("EntryFromVariables() --> "+
+				"invalid byte count: %s", bytesSent)
+		}
+	}
+
+	// nginx and apache both log "-" for absent headers
+	if entry.Referer == "-" {
+		entry.Referer = ""
+	}
+	if entry.UserAgent == "-" {
+		entry.UserAgent = ""
+	}
+
+	return entry, nil
+}
+
+//! Validate a freshly compiled list of tokens.
+/*
+ * @param     string           original template
+ * @param     formatToken[]    compiled tokens
+ *
+ * @return    *Format          compiled format
+ * @return    error            error message, if any
+ */
+func newFormat(template string, tokens []formatToken) (*Format, error) {
+
+	hasAddress := false
+	for i, token := range tokens {
+
+		// two variables in a row cannot be told apart
+		if i > 0 && token.variable != "" && tokens[i-1].variable != "" {
+			return nil, fmt.Errorf("newFormat() --> $%s and $%s must be "+
+				"separated by at least one character",
+				tokens[i-1].variable, token.variable)
+		}
+
+		if token.variable == "remote_addr" {
+			hasAddress = true
+		}
+	}
+
+	if !hasAddress {
+		return nil, fmt.Errorf("newFormat() --> the format does not " +
+			"contain the client address")
+	}
+
+	return &Format{Template: template, tokens: tokens}, nil
+}
+
+//! Append a variable to a list of tokens, flushing the pending literal.
+/*
+ * @param     formatToken[]    tokens gathered so far
+ * @param     string           pending literal
+ * @param     string           variable name
+ *
+ * @return    formatToken[]    updated tokens
+ * @return    string           new, empty, pending literal
+ */
+func appendVariable(tokens []formatToken, literal string,
+	name string) ([]formatToken, string) {
+
+	if literal != "" {
+		tokens = append(tokens, formatToken{literal: literal})
+	}
+
+	return append(tokens, formatToken{variable: name}), ""
+}
+
+//! Translate an apache format directive into an nginx variable name.
+/*
+ * @param     byte      directive letter, e.g. 'h'
+ * @param     string    directive argument, e.g. "User-agent", if any
+ *
+ * @return    string    nginx variable name
+ * @return    error     error message, if any
+ */
+func apacheVariableName(directive byte, argument string) (string, error) {
+
+	header := strings.ToLower(strings.Replace(argument, "-", "_", -1))
+
+	switch {
+
+	// request headers, e.g. %{Referer}i --> http_referer
+	case directive == 'i' && argument != "":
+		return "http_" + header, nil
+
+	// response headers, e.g. %{Location}o --> sent_http_location
+	case directive == 'o' && argument != "":
+		return "sent_http_" + header, nil
+
+	// cookies, environment and notes are kept under their own prefix
+	case directive == 'C' && argument != "":
+		return "cookie_" + header, nil
+	case directive == 'e' && argument != "":
+		return "env_" + header, nil
+	case directive == 'n' && argument != "":
+		return "note_" + header, nil
+
+	// the client address may be qualified, e.g. %{c}a
+	case directive == 'a':
+		return "remote_addr", nil
+
+	// only the default time layout is understood
+	case directive == 't' && argument != "":
+		return "", fmt.Errorf("apacheVariableName() --> custom time "+
+			"layouts are not supported: %%{%s}t", argument)
+	}
+
+	name, ok := apacheDirectives[directive]
+	if !ok {
+		return "", fmt.Errorf("apacheVariableName() --> unsupported "+
+			"directive: %%%c", directive)
+	}
+
+	return name, nil
+}
+
+//! Find the next occurrence of a literal, optionally skipping over
+//! backslash-escaped characters.
+/*
+ * @param     string    remaining line data
+ * @param     string    literal to search for
+ * @param     bool      whether the value is quoted
+ *
+ * @return    int       index of the literal, or -1 if not found
+ */
+func indexLiteral(data string, literal string, quoted bool) int {
+
+	if !quoted {
+		return strings.Index(data, literal)
+	}
+
+	for i := 0; i < len(data); i++ {
+		if data[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.HasPrefix(data[i:], literal) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+//! Remove the escaping nginx and apache apply to quoted values.
+/*
+ * @param     string    escaped value
+ *
+ * @return    string    unescaped value
+ */
+func unescape(value string) string {
+
+	if strings.IndexByte(value, '\\') < 0 {
+		return value
+	}
+
+	// readQuoted() already understands every escape sequence, so reuse it
+	unescaped, _, err := readQuoted(value+"\"", 0)
+	if err != nil {
+		return value
+	}
+
+	return unescaped
+}
+
+//! Strip an optional "log_format name '...' '...';" wrapper.
+/*
+ * @param     string    format string or directive
+ *
+ * @return    string    format string
+ */
+func unwrapNginxDirective(format string) string {
+
+	format = strings.TrimSpace(format)
+	if !strings.HasPrefix(format, "log_format") {
+		return format
+	}
+
+	// the format itself is the concatenation of every quoted piece
+	template := ""
+	for i := 0; i < len(format); i++ {
+		if format[i] != '\'' && format[i] != '"' {
+			continue
+		}
+		end := strings.IndexByte(format[i+1:], format[i])
+		if end < 0 {
+			break
+		}
+		template += format[i+1 : i+1+end]
+		i += end + 1
+	}
+
+	return template
+}
+
+//! Strip an optional `LogFormat "..." nickname` wrapper.
+/*
+ * @param     string    format string or directive
+ *
+ * @return    string    format string
+ */
+func unwrapApacheDirective(format string) string {
+
+	format = strings.TrimSpace(format)
+	if !strings.HasPrefix(format, "LogFormat") {
+		return format
+	}
+
+	start := strings.IndexByte(format, '"')
+	if start < 0 {
+		return ""
+	}
+
+	template, _, err := readQuoted(format, start+1)
+	if err != nil {
+		return ""
+	}
+
+	return template
+}
+
+//! Whether a character may be part of an nginx variable name.
+func isVariableChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+		(c >= '0' && c <= '9')
+}
+
+//! Default empty values to "-", as nginx and apache would.
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,190 @@
+//
+// JSON-lines access log parsing for ndefence
+//
+
+package ndefenceLog
+
+//
+// Imports
+//
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//
+// Globals
+//
+var (
+
+	// Name of the --log-format value that selects JSON-lines parsing
+	JSONFormatName = "json"
+
+	// Default nginx variable --> JSON key mapping; this matches the usual
+	// `log_format ... escape=json '{"remote_addr":"$remote_addr",...}'`
+	DefaultJSONKeys = map[string]string{
+		"remote_addr":     "remote_addr",
+		"remote_user":     "remote_user",
+		"time_iso8601":    "time_iso8601",
+		"time_local":      "time_local",
+		"msec":            "msec",
+		"request":         "request",
+		"request_method":  "request_method",
+		"request_uri":     "request_uri",
+		"server_protocol": "server_protocol",
+		"status":          "status",
+		"body_bytes_sent": "body_bytes_sent",
+		"http_referer":    "http_referer",
+		"http_user_agent": "http_user_agent",
+		"http_host":       "http_host",
+
+		"sent_http_location": "sent_http_location",
+	}
+)
+
+//
+// JSONParser object definition
+//
+type JSONParser struct {
+
+	// map[nginx variable name] = JSON key holding its value
+	Keys map[string]string
+}
+
+// NewJSONParser ... assemble a JSON-lines parser, overriding the default
+// keys with the given ones
+/*
+ * @param     map            map[nginx variable name] = JSON key, if any
+ *
+ * @return    *JSONParser    parser
+ */
+func NewJSONParser(keys map[string]string) *JSONParser {
+
+	merged := make(map[string]string, len(DefaultJSONKeys)+len(keys))
+	for variable, key := range DefaultJSONKeys {
+		merged[variable] = key
+	}
+	for variable, key := range keys {
+		merged[variable] = key
+	}
+
+	return &JSONParser{Keys: merged}
+}
+
+// Parse ... parse a single JSON object into a LogEntry
+/*
+ * @param     string      line data
+ *
+ * @return    LogEntry    parsed entry
+ * @return    error       error message, if any
+ */
+func (p *JSONParser) Parse(line string) (LogEntry, error) {
+
+	// input validation
+	line = strings.TrimSpace(line)
+	if !IsJSONLine(line) {
+		return LogEntry{}, fmt.Errorf("Parse() --> not a JSON object")
+	}
+
+	// numbers are kept as-is, so that e.g. "msec" keeps its precision
+	var object map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(line))
+	decoder.UseNumber()
+	if err := decoder.Decode(&object); err != nil {
+		return LogEntry{}, fmt.Errorf("Parse() --> %s", err)
+	}
+
+	// every key is kept, so that custom values remain available
+	values := make(map[string]string, len(object))
+	for key, value := range object {
+		values[key] = jsonValueToString(value)
+	}
+
+	// then map the configured keys onto the nginx variable names
+	variables := make(map[string]string, len(values))
+	for key, value := range values {
+		variables[key] = value
+	}
+	for variable, key := range p.Keys {
+		if value, ok := values[key]; ok {
+			variables[variable] = value
+		}
+	}
+
+	return EntryFromVariables(line, variables)
+}
+
+// IsJSONLine ... whether a line looks like a JSON object
+/*
+ * @param     string    line data
+ *
+ * @return    bool      whether or not this is true
+ */
+func IsJSONLine(line string) bool {
+	line = strings.TrimSpace(line)
+	return strings.HasPrefix(line, "{") && strings.HasSuffix(line, "}")
+}
+
+// ParseJSONKeys ... convert "variable=key,variable=key" into a map
+/*
+ * @param     string    e.g. "remote_addr=client,time_iso8601=@timestamp"
+ *
+ * @return    map       map[nginx variable name] = JSON key
+ * @return    error     error message, if any
+ */
+func ParseJSONKeys(spec string) (map[string]string, error) {
+
+	keys := make(map[string]string)
+
+	for _, pair := range strings.Split(spec, ",") {
+
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		pieces := strings.SplitN(pair, "=", 2)
+		if len(pieces) != 2 || strings.TrimSpace(pieces[0]) == "" ||
+			strings.TrimSpace(pieces[1]) == "" {
+			return nil, fmt.Errorf("ParseJSONKeys() --> expected "+
+				"variable=key, found: %s", pair)
+		}
+
+		keys[strings.TrimSpace(pieces[0])] = strings.TrimSpace(pieces[1])
+	}
+
+	return keys, nil
+}
+
+//! Convert a decoded JSON value into the string nginx would have logged.
+/*
+ * @param     interface{}    decoded value
+ *
+ * @return    string         value as a string
+ */
+func jsonValueToString(value interface{}) string {
+
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+
+	// nested arrays / objects are kept in their JSON form
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(buffer.String())
+}
@@ -0,0 +1,320 @@
+//
+// Access log parsing functions for ndefence
+//
+
+package ndefenceLog
+
+//
+// Imports
+//
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+// Globals
+//
+var (
+
+	// Layout of the [time_local] / %t field used by nginx and apache
+	TimeLocalLayout = "02/Jan/2006:15:04:05 -0700"
+
+	// Number of fields present in a "common" and "combined" log line
+	commonFieldCount   = 7
+	combinedFieldCount = 9
+
+	// The default "main" log_format of nginx appends the X-Forwarded-For
+	// header to the "combined" fields
+	mainFieldCount = 10
+)
+
+// ParseLine ... parse a line in either the "combined" or "common" format,
+// depending on how many fields are present.
+/*
+ * @param     string      line data
+ *
+ * @return    LogEntry    parsed entry
+ * @return    error       error message, if any
+ */
+func ParseLine(line string) (LogEntry, error) {
+
+	// break the line up into fields
+	fields, err := SplitFields(line)
+	if err != nil {
+		return LogEntry{}, err
+	}
+
+	// anything with the referer and user agent is "combined"
+	if len(fields) >= combinedFieldCount {
+		return entryFromFields(line, fields, true)
+	}
+
+	return entryFromFields(line, fields, false)
+}
+
+// ParseCombinedLine ... parse a line in the nginx / apache "combined" format
+/*
+ * @param     string      line data
+ *
+ * @return    LogEntry    parsed entry
+ * @return    error       error message, if any
+ */
+func ParseCombinedLine(line string) (LogEntry, error) {
+
+	fields, err := SplitFields(line)
+	if err != nil {
+		return LogEntry{}, err
+	}
+
+	if len(fields) < combinedFieldCount {
+		return LogEntry{}, fmt.Errorf("ParseCombinedLine() --> expected "+
+			"%d fields, found %d", combinedFieldCount, len(fields))
+	}
+
+	return entryFromFields(line, fields, true)
+}
+
+// ParseCommonLine ... parse a line in the nginx / apache "common" format
+/*
+ * @param     string      line data
+ *
+ * @return    LogEntry    parsed entry
+ * @return    error       error message, if any
+ */
+func ParseCommonLine(line string) (LogEntry, error) {
+
+	fields, err := SplitFields(line)
+	if err != nil {
+		return LogEntry{}, err
+	}
+
+	return entryFromFields(line, fields, false)
+}
+
+// SplitFields ... break a log line into space separated fields, treating
+// "quoted" and [bracketed] sections as a single field
+/*
+ * @param     string      line data
+ *
+ * @return    string[]    fields, with quotes and brackets removed
+ * @return    error       error message, if any
+ */
+func SplitFields(line string) ([]string, error) {
+
+	// input validation
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) < 1 {
+		return nil, fmt.Errorf("SplitFields() --> invalid input")
+	}
+
+	// variable declaration
+	fields := make([]string, 0, combinedFieldCount)
+	i := 0
+
+	for i < len(line) {
+
+		// skip the spaces between fields
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+
+		switch line[i] {
+
+		// quoted field, which may contain escaped quotes
+		case '"':
+			value, next, err := readQuoted(line, i+1)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, value)
+			i = next
+
+		// bracketed field, such as the [time_local]
+		case '[':
+			end := strings.IndexByte(line[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("SplitFields() --> unterminated " +
+					"bracket")
+			}
+			fields = append(fields, line[i+1:i+end])
+			i += end + 1
+
+		// plain field, which ends at the next space
+		default:
+			end := strings.IndexAny(line[i:], " \t")
+			if end < 0 {
+				end = len(line) - i
+			}
+			fields = append(fields, line[i:i+end])
+			i += end
+		}
+	}
+
+	return fields, nil
+}
+
+//! Read a quoted value, handling both \" and \xHH escape sequences.
+/*
+ * @param     string    line data
+ * @param     int       index of the first character after the quote
+ *
+ * @return    string    unescaped value
+ * @return    int       index of the first character after the closing quote
+ * @return    error     error message, if any
+ */
+func readQuoted(line string, start int) (string, int, error) {
+
+	var value strings.Builder
+
+	for i := start; i < len(line); i++ {
+
+		c := line[i]
+
+		// closing quote, so the value is complete
+		if c == '"' {
+			return value.String(), i + 1, nil
+		}
+
+		// ordinary character
+		if c != '\\' || i+1 >= len(line) {
+			value.WriteByte(c)
+			continue
+		}
+
+		// escaped character; nginx uses \xHH while apache uses \"
+		i++
+		switch line[i] {
+		case 'x':
+			if i+2 < len(line) {
+				b, err := strconv.ParseUint(line[i+1:i+3], 16, 8)
+				if err == nil {
+					value.WriteByte(byte(b))
+					i += 2
+					continue
+				}
+			}
+			value.WriteString("\\x")
+		case 'n':
+			value.WriteByte('\n')
+		case 't':
+			value.WriteByte('\t')
+		default:
+			value.WriteByte(line[i])
+		}
+	}
+
+	return "", 0, fmt.Errorf("readQuoted() --> unterminated quote")
+}
+
+// ParseRequestLine ... split a request line into method, path and protocol
+/*
+ * @param     string    request line, e.g. "GET /index.html HTTP/1.1"
+ *
+ * @return    string    method
+ * @return    string    path
+ * @return    string    protocol
+ */
+func ParseRequestLine(request string) (string, string, string) {
+
+	pieces := strings.Split(request, " ")
+
+	// malformed requests are kept whole, so they still show up in reports
+	if len(pieces) < 2 || len(pieces) > 3 || pieces[0] == "" {
+		return "", request, ""
+	}
+
+	if len(pieces) == 2 {
+		return pieces[0], pieces[1], ""
+	}
+
+	return pieces[0], pieces[1], pieces[2]
+}
+
+// ParseTimeLocal ... parse a [time_local] / %t value
+/*
+ * @param     string       time, e.g. "17/Oct/2026:08:30:00 +0000"
+ *
+ * @return    time.Time    parsed time, with the zone of the log
+ * @return    error        error message, if any
+ */
+func ParseTimeLocal(value string) (time.Time, error) {
+
+	t, err := time.Parse(TimeLocalLayout, strings.Trim(value, "[]"))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("ParseTimeLocal() --> unable to "+
+			"parse time: %s", value)
+	}
+
+	return t, nil
+}
+
+//! Assemble a LogEntry from the fields of a common or combined line.
+/*
+ * @param     string      line data
+ * @param     string[]    fields, as per SplitFields()
+ * @param     bool        whether to read the referer and user agent
+ *
+ * @return    LogEntry    parsed entry
+ * @return    error       error message, if any
+ */
+func entryFromFields(line string, fields []string,
+	combined bool) (LogEntry, error) {
+
+	// safety check, ensure the common fields are all present
+	if len(fields) < commonFieldCount {
+		return LogEntry{}, fmt.Errorf("entryFromFields() --> expected "+
+			"%d fields, found %d", commonFieldCount, len(fields))
+	}
+
+	entry := LogEntry{
+		ClientIP: fields[0],
+		Ident:    fields[1],
+		User:     fields[2],
+		Raw:      line,
+	}
+
+	t, err := ParseTimeLocal(fields[3])
+	if err != nil {
+		return LogEntry{}, err
+	}
+	entry.Time = t
+
+	entry.Method, entry.Path, entry.Protocol = ParseRequestLine(fields[4])
+
+	entry.Status, err = strconv.Atoi(fields[5])
+	if err != nil {
+		return LogEntry{}, fmt.Errorf("entryFromFields() --> invalid "+
+			"status code: %s", fields[5])
+	}
+
+	// a "-" means no body was sent
+	if fields[6] != "-" {
+		entry.Bytes, err = strconv.ParseInt(fields[6], 10, 64)
+		if err != nil {
+			return LogEntry{}, fmt.Errorf("entryFromFields() --> invalid "+
+				"byte count: %s", fields[6])
+		}
+	}
+
+	// nginx and apache both log "-" for absent headers
+	if combined && len(fields) >= combinedFieldCount {
+		if fields[7] != "-" {
+			entry.Referer = fields[7]
+		}
+		if fields[8] != "-" {
+			entry.UserAgent = fields[8]
+		}
+	}
+
+	// keep the X-Forwarded-For header, so the client behind a proxy can
+	// be determined
+	if combined && len(fields) >= mainFieldCount && fields[9] != "-" {
+		entry.Fields = map[string]string{"http_x_forwarded_for": fields[9]}
+	}
+
+	return entry, nil
+}
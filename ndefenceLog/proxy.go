@@ -0,0 +1,251 @@
+//
+// Trusted proxy handling for ndefence
+//
+
+package ndefenceLog
+
+//
+// Imports
+//
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/rbisewski/ndefence/ndefenceUtils"
+)
+
+//
+// Globals
+//
+var (
+
+	// Field holding the forwarding chain, unless another one is given
+	DefaultClientIPField = "http_x_forwarded_for"
+)
+
+//
+// ProxyResolver object definition
+//
+type ProxyResolver struct {
+
+	// Networks of the proxies whose forwarding headers are believed
+	Trusted []netip.Prefix
+
+	// Log variable holding the forwarding chain, e.g.
+	// "http_x_forwarded_for" or "http_x_real_ip"
+	Field string
+}
+
+// NewProxyResolver ... assemble a resolver of the clients behind the given
+// trusted proxies
+/*
+ * @param     string            comma separated addresses or networks of
+ *                              the trusted proxies, e.g. "10.0.0.0/8,::1"
+ * @param     string            header or log variable holding the
+ *                              forwarding chain, e.g. "X-Forwarded-For",
+ *                              "X-Real-IP" or "$http_cf_connecting_ip";
+ *                              empty selects X-Forwarded-For
+ *
+ * @return    *ProxyResolver    resolver, or nil if no proxy is trusted
+ * @return    error             error message, if any
+ */
+func NewProxyResolver(trusted string, field string) (*ProxyResolver,
+	error) {
+
+	prefixes, err := ParseTrustedProxies(trusted)
+	if err != nil {
+		return nil, err
+	}
+	if len(prefixes) == 0 {
+		return nil, nil
+	}
+
+	return &ProxyResolver{Trusted: prefixes,
+		Field: ClientIPFieldName(field)}, nil
+}
+
+// ParseTrustedProxies ... parse a comma separated list of addresses and
+// networks, e.g. "127.0.0.1,10.0.0.0/8,2001:db8::/32"
+/*
+ * @param     string          list of addresses and networks
+ *
+ * @return    netip.Prefix[]  networks; single addresses become a /32 or
+ *                            a /128
+ * @return    error           error message, if any
+ */
+func ParseTrustedProxies(list string) ([]netip.Prefix, error) {
+
+	// variable declaration
+	prefixes := make([]netip.Prefix, 0)
+
+	for _, item := range strings.Split(list, ",") {
+
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if prefix, err := netip.ParsePrefix(item); err == nil {
+			if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+				prefix = netip.PrefixFrom(prefix.Addr().Unmap(),
+					prefix.Bits()-96)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := ndefenceUtils.ParseIPAddress(item)
+		if err != nil {
+			return nil, fmt.Errorf("ParseTrustedProxies() --> invalid "+
+				"address or network: %s", item)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	return prefixes, nil
+}
+
+// ClientIPFieldName ... convert a header name, e.g. "X-Forwarded-For", or a
+// log variable, e.g. "$http_x_forwarded_for", to the name of the variable
+/*
+ * @param     string    header name or log variable
+ *
+ * @return    string    variable name, e.g. "http_x_forwarded_for"
+ */
+func ClientIPFieldName(field string) string {
+
+	field = strings.TrimSpace(field)
+	if field == "" {
+		return DefaultClientIPField
+	}
+
+	// a log variable, e.g. $http_x_real_ip or ${realip_remote_addr}
+	if strings.HasPrefix(field, "$") {
+		return strings.Trim(field[1:], "{}")
+	}
+
+	// a header, e.g. X-Real-IP
+	if strings.Contains(field, "-") || strings.ToLower(field) != field {
+		return "http_" + strings.Replace(strings.ToLower(field), "-", "_",
+			-1)
+	}
+
+	return field
+}
+
+// IsTrusted ... whether an address belongs to one of the trusted proxies
+/*
+ * @param     netip.Addr    address
+ *
+ * @return    bool          whether or not this is true
+ */
+func (r *ProxyResolver) IsTrusted(addr netip.Addr) bool {
+
+	for _, prefix := range r.Trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsTrustedAddress ... whether an address, given as a string, belongs to
+// one of the trusted proxies
+/*
+ * @param     string    IP address
+ *
+ * @return    bool      whether or not this is true
+ */
+func (r *ProxyResolver) IsTrustedAddress(ip string) bool {
+
+	if r == nil {
+		return false
+	}
+
+	addr, err := ndefenceUtils.ParseIPAddress(ip)
+	return err == nil && r.IsTrusted(addr)
+}
+
+// Wrap ... wrap a parser so that every entry it returns names the actual
+// client; requests made by the trusted proxies themselves, e.g. health
+// checks, are rejected so that they are never counted
+/*
+ * @param     Parser    parser of the access log lines
+ *
+ * @return    Parser    wrapped parser
+ */
+func (r *ProxyResolver) Wrap(parser Parser) Parser {
+
+	if r == nil {
+		return parser
+	}
+
+	return ParserFunc(func(line string) (LogEntry, error) {
+
+		entry, err := parser.Parse(line)
+		if err != nil {
+			return entry, err
+		}
+
+		r.Resolve(&entry)
+		if r.IsTrustedAddress(entry.ClientIP) {
+			return LogEntry{}, fmt.Errorf("Wrap() --> request made by "+
+				"a trusted proxy: %s", entry.ClientIP)
+		}
+
+		return entry, nil
+	})
+}
+
+// Resolve ... replace the client address of an entry forwarded by a trusted
+// proxy with the address of the actual client, found by walking the
+// forwarding chain from right to left past every trusted hop; headers sent
+// directly by untrusted clients are ignored, since anyone can forge them
+/*
+ * @param     *LogEntry    entry, updated in place
+ */
+func (r *ProxyResolver) Resolve(entry *LogEntry) {
+
+	// input validation
+	if r == nil || entry == nil {
+		return
+	}
+
+	peer, err := ndefenceUtils.ParseIPAddress(entry.ClientIP)
+	if err != nil || !r.IsTrusted(peer) {
+		return
+	}
+
+	chain := strings.Split(entry.Fields[r.Field], ",")
+	client := netip.Addr{}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+
+		hop := strings.TrimSpace(chain[i])
+		if hop == "" || hop == "-" {
+			continue
+		}
+
+		// stop at anything malformed, keeping the last good hop
+		addr, err := ndefenceUtils.ParseIPAddress(stripPort(
+			strings.Trim(hop, "\"")))
+		if err != nil {
+			break
+		}
+
+		client = addr
+		if !r.IsTrusted(addr) {
+			break
+		}
+	}
+
+	// nothing usable was forwarded, so the proxy is all there is
+	if !client.IsValid() {
+		return
+	}
+
+	entry.ProxyIP = entry.ClientIP
+	entry.ClientIP = client.String()
+}
@@ -0,0 +1,251 @@
+//
+// Redirect classification for ndefence
+//
+
+package ndefenceLog
+
+//
+// Imports
+//
+import (
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//
+// Globals
+//
+var (
+
+	// Classes of redirect; a redirect is internal if it stays on the site,
+	// external if it leaves it, open if it leaves it for a target the
+	// client asked for in the query string, and a loop if it sends the
+	// client back to where an earlier redirect came from
+	RedirectInternal = "internal"
+	RedirectExternal = "external"
+	RedirectOpen     = "open"
+	RedirectLoop     = "loop"
+
+	// Class of the redirects whose target was not logged, e.g. because the
+	// log format lacks $sent_http_location
+	RedirectUnknown = "unknown"
+
+	// Status codes of the redirects
+	RedirectStatuses = []int{301, 302, 303, 307, 308}
+
+	// Span of time within which redirects back to an earlier source count
+	// as a loop, the number of times the loop must be followed, and the
+	// number of redirects remembered per client
+	redirectLoopWindow  = time.Minute
+	redirectLoopRepeats = 3
+	redirectHistory     = 8
+)
+
+//
+// RedirectClassifier object definition
+//
+type RedirectClassifier struct {
+
+	// Hosts counted as the site itself, besides the host requested
+	internal map[string]bool
+
+	// Latest redirects of every client, oldest first
+	recent map[string][]redirectHop
+}
+
+//
+// Single redirect of a client, from one URL to another, each given as
+// host and request URI
+//
+type redirectHop struct {
+	from string
+	to   string
+	time time.Time
+}
+
+// NewRedirectClassifier ... assemble a classifier of redirects
+/*
+ * @param     string                 comma separated hosts counted as the
+ *                                   site itself, e.g. "example.org,cdn.
+ *                                   example.org"; may be empty
+ *
+ * @return    *RedirectClassifier    classifier
+ */
+func NewRedirectClassifier(internalHosts string) *RedirectClassifier {
+
+	internal := make(map[string]bool)
+	for _, host := range strings.Split(internalHosts, ",") {
+		if host = normalizeHost(host); host != "" {
+			internal[host] = true
+		}
+	}
+
+	return &RedirectClassifier{internal: internal,
+		recent: make(map[string][]redirectHop)}
+}
+
+// IsRedirect ... whether a status code is that of a redirect
+/*
+ * @param     int     HTTP status code
+ *
+ * @return    bool    whether or not this is true
+ */
+func IsRedirect(status int) bool {
+
+	for _, candidate := range RedirectStatuses {
+		if status == candidate {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Classify ... class of the redirect an entry received, if any; loops are
+// told apart by remembering the latest redirects of every client
+/*
+ * @param     LogEntry    entry, with its client address in canonical form
+ *
+ * @return    string      class, e.g. "internal" or "open", or an empty
+ *                        string if the entry is not a redirect
+ */
+func (c *RedirectClassifier) Classify(entry LogEntry) string {
+
+	if !IsRedirect(entry.Status) {
+		return ""
+	}
+
+	location, err := url.Parse(strings.TrimSpace(entry.Location))
+	if entry.Location == "" || entry.Location == "-" || err != nil {
+		return RedirectUnknown
+	}
+
+	// relative targets are resolved against the URL requested
+	host := requestHost(entry)
+	requested, err := url.Parse(entry.Path)
+	if err != nil {
+		return RedirectUnknown
+	}
+	requested.Host = host
+	target := requested.ResolveReference(location)
+	targetHost := normalizeHost(target.Host)
+
+	// without the host requested, a redirect to the very same path is
+	// taken to be the site moving the client to https
+	sameSite := targetHost == host || c.internal[targetHost] ||
+		(host == "" && target.RequestURI() == requested.RequestURI())
+
+	if !sameSite {
+
+		// a target the client supplied itself makes the site an open
+		// redirect, which phishing links abuse
+		query, err := url.QueryUnescape(requested.RawQuery)
+		if err != nil {
+			query = requested.RawQuery
+		}
+		if targetHost != "" &&
+			strings.Contains(strings.ToLower(query), targetHost) {
+			return RedirectOpen
+		}
+
+		return RedirectExternal
+	}
+
+	// the scheme is rarely logged, so a redirect to the same URL is most
+	// likely one to https; only a cycle the client keeps following, as
+	// browsers do until they give up, is taken to be a loop
+	hop := redirectHop{from: host + requested.RequestURI(),
+		to: targetHost + target.RequestURI(), time: entry.Time}
+	cycle := hop.from == hop.to
+	repeats := 1
+
+	history := c.recent[entry.ClientIP]
+	for _, earlier := range history {
+		if earlier.time.Before(entry.Time.Add(-redirectLoopWindow)) {
+			continue
+		}
+		if earlier.from == hop.to {
+			cycle = true
+		}
+		if earlier.from == hop.from && earlier.to == hop.to {
+			repeats++
+		}
+	}
+	loop := cycle && repeats >= redirectLoopRepeats
+
+	history = append(history, hop)
+	if len(history) > redirectHistory {
+		history = history[len(history)-redirectHistory:]
+	}
+	c.recent[entry.ClientIP] = history
+
+	if loop {
+		return RedirectLoop
+	}
+
+	return RedirectInternal
+}
+
+// Prune ... forget the redirects too old to be part of a loop
+/*
+ * @param     time.Time    time of the newest entry
+ */
+func (c *RedirectClassifier) Prune(now time.Time) {
+
+	since := now.Add(-redirectLoopWindow)
+	for ip, history := range c.recent {
+
+		kept := history[:0]
+		for _, hop := range history {
+			if !hop.time.Before(since) {
+				kept = append(kept, hop)
+			}
+		}
+
+		if len(kept) == 0 {
+			delete(c.recent, ip)
+		} else {
+			c.recent[ip] = kept
+		}
+	}
+}
+
+//! Host a request was made to, as logged, if it was.
+/*
+ * @param     LogEntry    entry
+ *
+ * @return    string      host, lower case and without a port
+ */
+func requestHost(entry LogEntry) string {
+
+	for _, field := range []string{"host", "http_host", "server_name"} {
+		if host := normalizeHost(entry.Fields[field]); host != "" {
+			return host
+		}
+	}
+
+	return ""
+}
+
+//! Host in lower case, without a port or a trailing dot.
+/*
+ * @param     string    host, e.g. "Example.org:443"
+ *
+ * @return    string    normalized host, e.g. "example.org"
+ */
+func normalizeHost(host string) string {
+
+	host = strings.ToLower(strings.TrimSpace(host))
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	host = strings.Trim(host, "[]")
+
+	if host == "-" {
+		return ""
+	}
+
+	return strings.TrimSuffix(host, ".")
+}
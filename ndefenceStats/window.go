@@ -0,0 +1,207 @@
+//
+// Sliding time window counters for ndefence
+//
+
+package ndefenceStats
+
+//
+// Imports
+//
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+//
+// Globals
+//
+var (
+
+	// Default size of a single bucket of a sliding counter
+	DefaultResolution = time.Minute
+)
+
+//
+// SlidingCounter object definition
+//
+type SlidingCounter struct {
+
+	// Span of time over which events are counted
+	Window time.Duration
+
+	// Events are grouped into buckets of this size; a smaller resolution
+	// gives more precise counts at the cost of more memory
+	Resolution time.Duration
+
+	// Non-empty buckets, oldest first
+	buckets []bucket
+
+	// Sum of every bucket
+	total int
+}
+
+//
+// Single bucket of a sliding counter
+//
+type bucket struct {
+	Start int64 `json:"start"`
+	Count int   `json:"count"`
+}
+
+// NewSlidingCounter ... assemble an empty sliding counter
+/*
+ * @param     duration           span of time over which events are counted
+ * @param     duration           size of a single bucket; zero or less
+ *                               selects the default
+ *
+ * @return    *SlidingCounter    counter
+ */
+func NewSlidingCounter(window time.Duration,
+	resolution time.Duration) *SlidingCounter {
+
+	if resolution <= 0 {
+		resolution = DefaultResolution
+	}
+	if resolution > window && window > 0 {
+		resolution = window
+	}
+
+	return &SlidingCounter{Window: window, Resolution: resolution}
+}
+
+// Add ... count a number of events that happened at the given time
+/*
+ * @param     time.Time    time of the events
+ * @param     int          number of events
+ */
+func (c *SlidingCounter) Add(t time.Time, n int) {
+
+	start := t.UnixNano() - t.UnixNano()%int64(c.Resolution)
+
+	// events usually arrive in order, so the last bucket is the likeliest
+	last := len(c.buckets) - 1
+	switch {
+	case last >= 0 && c.buckets[last].Start == start:
+		c.buckets[last].Count += n
+
+	case last < 0 || c.buckets[last].Start < start:
+		c.buckets = append(c.buckets, bucket{Start: start, Count: n})
+
+	// out of order, so find the right place for it
+	default:
+		i := last
+		for i >= 0 && c.buckets[i].Start > start {
+			i--
+		}
+		if i >= 0 && c.buckets[i].Start == start {
+			c.buckets[i].Count += n
+		} else {
+			c.buckets = append(c.buckets, bucket{})
+			copy(c.buckets[i+2:], c.buckets[i+1:])
+			c.buckets[i+1] = bucket{Start: start, Count: n}
+		}
+	}
+
+	c.total += n
+}
+
+// Count ... number of events within the window ending at the given time
+/*
+ * @param     time.Time    end of the window
+ *
+ * @return    int          number of events
+ */
+func (c *SlidingCounter) Count(now time.Time) int {
+	c.Prune(now)
+	return c.total
+}
+
+// CountWithin ... number of events within a shorter span of time ending at
+// the given time; the span is rounded to the resolution of the counter
+/*
+ * @param     time.Time    end of the span
+ * @param     duration     length of the span
+ *
+ * @return    int          number of events
+ */
+func (c *SlidingCounter) CountWithin(now time.Time,
+	span time.Duration) int {
+
+	since := now.Add(-span).UnixNano()
+	count := 0
+
+	for i := len(c.buckets) - 1; i >= 0; i-- {
+		if c.buckets[i].Start+int64(c.Resolution) <= since {
+			break
+		}
+		if c.buckets[i].Start <= now.UnixNano() {
+			count += c.buckets[i].Count
+		}
+	}
+
+	return count
+}
+
+// Prune ... discard the buckets that fell out of the window ending at the
+// given time
+/*
+ * @param     time.Time    end of the window
+ */
+func (c *SlidingCounter) Prune(now time.Time) {
+
+	since := now.Add(-c.Window).UnixNano()
+
+	expired := 0
+	for expired < len(c.buckets) &&
+		c.buckets[expired].Start+int64(c.Resolution) <= since {
+		c.total -= c.buckets[expired].Count
+		expired++
+	}
+
+	if expired > 0 {
+		c.buckets = append(c.buckets[:0], c.buckets[expired:]...)
+	}
+}
+
+// Empty ... whether the counter holds no events at all
+/*
+ * @return    bool    whether or not this is true
+ */
+func (c *SlidingCounter) Empty() bool {
+	return len(c.buckets) == 0
+}
+
+// MarshalJSON ... encode the counter, so that it can be persisted
+func (c *SlidingCounter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Window     time.Duration `json:"window"`
+		Resolution time.Duration `json:"resolution"`
+		Buckets    []bucket      `json:"buckets"`
+	}{c.Window, c.Resolution, c.buckets})
+}
+
+// UnmarshalJSON ... decode a previously persisted counter
+func (c *SlidingCounter) UnmarshalJSON(data []byte) error {
+
+	var decoded struct {
+		Window     time.Duration `json:"window"`
+		Resolution time.Duration `json:"resolution"`
+		Buckets    []bucket      `json:"buckets"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	if decoded.Resolution <= 0 {
+		return fmt.Errorf("UnmarshalJSON() --> invalid resolution")
+	}
+
+	c.Window, c.Resolution, c.buckets, c.total = decoded.Window,
+		decoded.Resolution, decoded.Buckets, 0
+	for _, b := range c.buckets {
+		c.total += b.Count
+	}
+
+	return nil
+}
@@ -0,0 +1,298 @@
+//
+// CIDR utility functions for ndefence
+//
+
+package ndefenceUtils
+
+//
+// Imports
+//
+import (
+	"math"
+	"net/netip"
+	"sort"
+	"time"
+)
+
+//
+// Blocked network along with the time its block ends, in seconds since the
+// epoch, so that only entries ending at the same time are merged
+//
+type blockedPrefix struct {
+	prefix netip.Prefix
+	entry  BlockedIP
+	end    int64
+}
+
+// MinimizeBlockedIPs ... merge the entries of the blocked IP config into
+// the fewest networks covering the same addresses for the same time; an
+// entry within another that ends no sooner is dropped, and two halves of a
+// network that end at the same time become the network itself
+/*
+ * @param    map         map[IP address or network] = blocked entry
+ * @param    int64       current time, in seconds since the epoch
+ * @param    duration    how long an address stays blocked, unless the
+ *                       entry says otherwise; zero for ever
+ *
+ * @return   string[]    addresses and networks, sorted
+ * @return   map         map[IP address or network] = blocked entry
+ */
+func MinimizeBlockedIPs(ips map[string]BlockedIP, now int64,
+	expiry time.Duration) ([]string, map[string]BlockedIP) {
+
+	// variable declaration
+	minimized := make(map[string]BlockedIP, len(ips))
+	prefixes := make([]blockedPrefix, 0, len(ips))
+
+	for ip, entry := range ips {
+
+		// entries blocked as of the time the config is written
+		if entry.Since == 0 {
+			entry.Since = now
+		}
+
+		prefix, err := parseBlockedPrefix(ip)
+		if err != nil {
+			// anything that is not an address is kept as it is
+			minimized[ip] = entry
+			continue
+		}
+
+		prefixes = append(prefixes, blockedPrefix{prefix: prefix,
+			entry: entry, end: blockEnd(entry, expiry)})
+	}
+
+	prefixes = dropCoveredPrefixes(prefixes)
+	prefixes = mergeSiblingPrefixes(prefixes)
+	prefixes = dropCoveredPrefixes(prefixes)
+
+	for _, blocked := range prefixes {
+		minimized[formatBlockedPrefix(blocked.prefix)] = blocked.entry
+	}
+
+	keys := make([]string, 0, len(minimized))
+	for key := range minimized {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	SortIPAddresses(keys)
+
+	return keys, minimized
+}
+
+//! Parse an address or network of the blocked IP config; an address is
+//! the network of a single address.
+/*
+ * @param    string          address or network, e.g. 10.0.0.0/24
+ *
+ * @return   netip.Prefix    network
+ * @return   error           error message, if any
+ */
+func parseBlockedPrefix(entry string) (netip.Prefix, error) {
+
+	if prefix, err := netip.ParsePrefix(entry); err == nil {
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			return netip.PrefixFrom(prefix.Addr().Unmap(),
+				prefix.Bits()-96).Masked(), nil
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := ParseIPAddress(entry)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+//! Format a network of the blocked IP config, as a plain address if it
+//! holds only one.
+/*
+ * @param    netip.Prefix    network
+ *
+ * @return   string          address or network
+ */
+func formatBlockedPrefix(prefix netip.Prefix) string {
+
+	if prefix.IsSingleIP() {
+		return prefix.Addr().String()
+	}
+
+	return prefix.String()
+}
+
+//! Time a block ends, in seconds since the epoch.
+/*
+ * @param    BlockedIP    blocked entry
+ * @param    duration     general expiry; zero for ever
+ *
+ * @return   int64        end of the block; math.MaxInt64 for ever
+ */
+func blockEnd(entry BlockedIP, expiry time.Duration) int64 {
+
+	switch {
+	case entry.Since == -1:
+		return math.MaxInt64
+	case entry.Until > 0:
+		return entry.Until
+	case expiry == 0:
+		return math.MaxInt64
+	}
+
+	return entry.Since + int64(expiry.Seconds())
+}
+
+//! Drop every network within another network that is blocked no shorter.
+/*
+ * @param    blockedPrefix[]    networks
+ *
+ * @return   blockedPrefix[]    networks left, sorted by first address,
+ *                              then by increasing prefix length
+ */
+func dropCoveredPrefixes(prefixes []blockedPrefix) []blockedPrefix {
+
+	sort.Slice(prefixes, func(i, j int) bool {
+		a, b := prefixes[i].prefix, prefixes[j].prefix
+		if a.Addr() != b.Addr() {
+			return a.Addr().Less(b.Addr())
+		}
+		return a.Bits() < b.Bits()
+	})
+
+	// the networks containing the current one, outermost first; networks
+	// sorted this way are nested like parentheses
+	kept := make([]blockedPrefix, 0, len(prefixes))
+	enclosing := make([]blockedPrefix, 0)
+
+	for _, blocked := range prefixes {
+
+		for len(enclosing) > 0 && !enclosing[len(enclosing)-1].prefix.
+			Contains(blocked.prefix.Addr()) {
+			enclosing = enclosing[:len(enclosing)-1]
+		}
+
+		covered := false
+		for _, outer := range enclosing {
+			if outer.end >= blocked.end {
+				covered = true
+				break
+			}
+		}
+		if covered {
+			continue
+		}
+
+		kept = append(kept, blocked)
+		enclosing = append(enclosing, blocked)
+	}
+
+	return kept
+}
+
+//! Merge both halves of a network, where they end at the same time, into
+//! the network itself, for as long as any are left.
+/*
+ * @param    blockedPrefix[]    networks, none within another
+ *
+ * @return   blockedPrefix[]    merged networks
+ */
+func mergeSiblingPrefixes(prefixes []blockedPrefix) []blockedPrefix {
+
+	set := make(map[netip.Prefix]blockedPrefix, len(prefixes))
+	for _, blocked := range prefixes {
+		set[blocked.prefix] = blocked
+	}
+
+	// the longest prefixes are merged first, so that their parents may in
+	// turn be merged with theirs
+	for bits := 128; bits > 0; bits-- {
+
+		candidates := make([]netip.Prefix, 0)
+		for prefix := range set {
+			if prefix.Bits() == bits {
+				candidates = append(candidates, prefix)
+			}
+		}
+
+		for _, prefix := range candidates {
+
+			blocked, ok := set[prefix]
+			if !ok {
+				continue
+			}
+
+			sibling, ok := set[siblingPrefix(prefix)]
+			if !ok || sibling.end != blocked.end {
+				continue
+			}
+
+			parent := netip.PrefixFrom(prefix.Addr(), bits-1).Masked()
+			delete(set, prefix)
+			delete(set, sibling.prefix)
+
+			// a parent already there ending sooner is superseded, as both
+			// of its halves outlast it
+			if existing, ok := set[parent]; ok &&
+				existing.end >= blocked.end {
+				continue
+			}
+
+			set[parent] = blockedPrefix{prefix: parent,
+				entry: mergeBlockedEntries(blocked.entry, sibling.entry,
+					blocked.end),
+				end: blocked.end}
+		}
+	}
+
+	merged := make([]blockedPrefix, 0, len(set))
+	for _, blocked := range set {
+		merged = append(merged, blocked)
+	}
+
+	return merged
+}
+
+//! The other half of the network a network is half of.
+/*
+ * @param    netip.Prefix    network, of a prefix length of at least 1
+ *
+ * @return   netip.Prefix    sibling network
+ */
+func siblingPrefix(prefix netip.Prefix) netip.Prefix {
+
+	bit := prefix.Bits() - 1
+	bytes := prefix.Addr().AsSlice()
+	bytes[bit/8] ^= 0x80 >> uint(bit%8)
+
+	addr, _ := netip.AddrFromSlice(bytes)
+	return netip.PrefixFrom(addr, prefix.Bits())
+}
+
+//! Combine the entries of two halves of a network ending at the same time
+//! into the entry of the network.
+/*
+ * @param    BlockedIP    entry of the first half
+ * @param    BlockedIP    entry of the second half
+ * @param    int64        end of both blocks; math.MaxInt64 for ever
+ *
+ * @return   BlockedIP    entry of the network
+ */
+func mergeBlockedEntries(a BlockedIP, b BlockedIP, end int64) BlockedIP {
+
+	if a == b {
+		return a
+	}
+
+	// the earlier start is kept, along with an explicit end, since the end
+	// would otherwise follow from the start
+	merged := BlockedIP{Since: a.Since}
+	if b.Since < merged.Since {
+		merged.Since = b.Since
+	}
+	if merged.Since != -1 && end != math.MaxInt64 {
+		merged.Until = end
+	}
+
+	return merged
+}
@@ -0,0 +1,311 @@
+//
+// Tests of the CIDR utility functions of ndefence
+//
+
+package ndefenceUtils
+
+//
+// Imports
+//
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestMinimizeBlockedIPs ... entries are merged into the fewest networks
+// blocking the same addresses for no longer than before
+func TestMinimizeBlockedIPs(t *testing.T) {
+
+	// variable declaration
+	now := int64(1800000000)
+	later := now + 60
+
+	tests := []struct {
+		name     string
+		expiry   time.Duration
+		ips      map[string]BlockedIP
+		keys     []string
+		expected map[string]BlockedIP
+	}{
+		// halves of a network ending at the same time
+		{
+			name:   "sibling addresses ending together",
+			expiry: time.Hour,
+			ips: map[string]BlockedIP{
+				"10.0.0.0": {Since: now},
+				"10.0.0.1": {Since: now}},
+			keys: []string{"10.0.0.0/31"},
+			expected: map[string]BlockedIP{
+				"10.0.0.0/31": {Since: now}},
+		},
+		{
+			name:   "siblings merged into their parents in turn",
+			expiry: time.Hour,
+			ips: map[string]BlockedIP{
+				"10.0.0.0": {Since: now},
+				"10.0.0.1": {Since: now},
+				"10.0.0.2": {Since: now},
+				"10.0.0.3": {Since: now}},
+			keys: []string{"10.0.0.0/30"},
+			expected: map[string]BlockedIP{
+				"10.0.0.0/30": {Since: now}},
+		},
+		{
+			name:   "siblings of different starts ending together",
+			expiry: time.Hour,
+			ips: map[string]BlockedIP{
+				"10.0.0.0": {Since: now - 600, Until: later},
+				"10.0.0.1": {Since: now, Until: later}},
+			keys: []string{"10.0.0.0/31"},
+			expected: map[string]BlockedIP{
+				"10.0.0.0/31": {Since: now - 600, Until: later}},
+		},
+		{
+			name:   "parent ending sooner superseded by its halves",
+			expiry: time.Hour,
+			ips: map[string]BlockedIP{
+				"10.0.0.0/31": {Since: now - 600},
+				"10.0.0.0":    {Since: now},
+				"10.0.0.1":    {Since: now}},
+			keys: []string{"10.0.0.0/31"},
+			expected: map[string]BlockedIP{
+				"10.0.0.0/31": {Since: now}},
+		},
+
+		// no merge unless both halves end at the same time
+		{
+			name:   "sibling addresses ending apart",
+			expiry: time.Hour,
+			ips: map[string]BlockedIP{
+				"10.0.0.0": {Since: now},
+				"10.0.0.1": {Since: later}},
+			keys: []string{"10.0.0.0", "10.0.0.1"},
+			expected: map[string]BlockedIP{
+				"10.0.0.0": {Since: now},
+				"10.0.0.1": {Since: later}},
+		},
+		{
+			name:   "sibling addresses of different explicit ends",
+			expiry: time.Hour,
+			ips: map[string]BlockedIP{
+				"10.0.0.0": {Since: now, Until: later},
+				"10.0.0.1": {Since: now, Until: later + 1}},
+			keys: []string{"10.0.0.0", "10.0.0.1"},
+			expected: map[string]BlockedIP{
+				"10.0.0.0": {Since: now, Until: later},
+				"10.0.0.1": {Since: now, Until: later + 1}},
+		},
+		{
+			name:   "adjacent addresses that are not siblings",
+			expiry: time.Hour,
+			ips: map[string]BlockedIP{
+				"10.0.0.1": {Since: now},
+				"10.0.0.2": {Since: now}},
+			keys: []string{"10.0.0.1", "10.0.0.2"},
+			expected: map[string]BlockedIP{
+				"10.0.0.1": {Since: now},
+				"10.0.0.2": {Since: now}},
+		},
+
+		// entries within a network
+		{
+			name:   "covered entry ending at the same time",
+			expiry: time.Hour,
+			ips: map[string]BlockedIP{
+				"10.0.0.0/24": {Since: 0},
+				"10.0.0.5":    {Since: now}},
+			keys: []string{"10.0.0.0/24"},
+			expected: map[string]BlockedIP{
+				"10.0.0.0/24": {Since: now}},
+		},
+		{
+			name:   "covered entry ending sooner",
+			expiry: time.Hour,
+			ips: map[string]BlockedIP{
+				"10.0.0.0/24": {Since: now},
+				"10.0.0.128":  {Since: now - 600},
+				"10.0.0.5":    {Since: now, Until: now + 60}},
+			keys: []string{"10.0.0.0/24"},
+			expected: map[string]BlockedIP{
+				"10.0.0.0/24": {Since: now}},
+		},
+		{
+			name:   "covered entry ending later",
+			expiry: time.Hour,
+			ips: map[string]BlockedIP{
+				"10.0.0.0/24": {Since: now},
+				"10.0.0.5":    {Since: later}},
+			keys: []string{"10.0.0.0/24", "10.0.0.5"},
+			expected: map[string]BlockedIP{
+				"10.0.0.0/24": {Since: now},
+				"10.0.0.5":    {Since: later}},
+		},
+
+		// IPv4-mapped input is written as IPv4
+		{
+			name:   "mapped address merged with its IPv4 sibling",
+			expiry: time.Hour,
+			ips: map[string]BlockedIP{
+				"::ffff:10.0.0.1": {Since: now},
+				"10.0.0.0":        {Since: now}},
+			keys: []string{"10.0.0.0/31"},
+			expected: map[string]BlockedIP{
+				"10.0.0.0/31": {Since: now}},
+		},
+		{
+			name:   "mapped network covering an IPv4 address",
+			expiry: time.Hour,
+			ips: map[string]BlockedIP{
+				"::ffff:10.0.0.0/120": {Since: now},
+				"10.0.0.9":            {Since: now}},
+			keys: []string{"10.0.0.0/24"},
+			expected: map[string]BlockedIP{
+				"10.0.0.0/24": {Since: now}},
+		},
+
+		// IPv6 input
+		{
+			name:   "sibling IPv6 addresses ending together",
+			expiry: time.Hour,
+			ips: map[string]BlockedIP{
+				"2001:db8::":  {Since: now},
+				"2001:db8::1": {Since: now}},
+			keys: []string{"2001:db8::/127"},
+			expected: map[string]BlockedIP{
+				"2001:db8::/127": {Since: now}},
+		},
+		{
+			name:   "sibling IPv6 networks ending together",
+			expiry: time.Hour,
+			ips: map[string]BlockedIP{
+				"2001:db8::/65":          {Since: now},
+				"2001:db8:0:0:8000::/65": {Since: now},
+				"2001:db8::42":           {Since: now}},
+			keys: []string{"2001:db8::/64"},
+			expected: map[string]BlockedIP{
+				"2001:db8::/64": {Since: now}},
+		},
+		{
+			name:   "sibling IPv6 networks ending apart",
+			expiry: time.Hour,
+			ips: map[string]BlockedIP{
+				"2001:db8::/65":          {Since: now},
+				"2001:db8:0:0:8000::/65": {Since: later}},
+			keys: []string{"2001:db8::/65", "2001:db8:0:0:8000::/65"},
+			expected: map[string]BlockedIP{
+				"2001:db8::/65":          {Since: now},
+				"2001:db8:0:0:8000::/65": {Since: later}},
+		},
+
+		// entries blocked for ever
+		{
+			name:   "perma network covering a timed address",
+			expiry: time.Hour,
+			ips: map[string]BlockedIP{
+				"10.0.0.0/24": {Since: -1},
+				"10.0.0.5":    {Since: now, Until: now + 86400}},
+			keys: []string{"10.0.0.0/24"},
+			expected: map[string]BlockedIP{
+				"10.0.0.0/24": {Since: -1}},
+		},
+		{
+			name:   "perma siblings",
+			expiry: time.Hour,
+			ips: map[string]BlockedIP{
+				"10.0.0.0": {Since: -1},
+				"10.0.0.1": {Since: -1}},
+			keys: []string{"10.0.0.0/31"},
+			expected: map[string]BlockedIP{
+				"10.0.0.0/31": {Since: -1}},
+		},
+		{
+			name:   "perma address and a timed sibling",
+			expiry: time.Hour,
+			ips: map[string]BlockedIP{
+				"10.0.0.0": {Since: -1},
+				"10.0.0.1": {Since: now}},
+			keys: []string{"10.0.0.0", "10.0.0.1"},
+			expected: map[string]BlockedIP{
+				"10.0.0.0": {Since: -1},
+				"10.0.0.1": {Since: now}},
+		},
+		{
+			name:   "perma address and a sibling that never expires",
+			expiry: 0,
+			ips: map[string]BlockedIP{
+				"10.0.0.0": {Since: -1},
+				"10.0.0.1": {Since: now}},
+			keys: []string{"10.0.0.0/31"},
+			expected: map[string]BlockedIP{
+				"10.0.0.0/31": {Since: -1}},
+		},
+		{
+			name:   "timed address within a timed network never expiring",
+			expiry: 0,
+			ips: map[string]BlockedIP{
+				"10.0.0.0/24": {Since: now},
+				"10.0.0.5":    {Since: now, Until: now + 86400}},
+			keys: []string{"10.0.0.0/24"},
+			expected: map[string]BlockedIP{
+				"10.0.0.0/24": {Since: now}},
+		},
+
+		// anything else
+		{
+			name:   "entries that are not addresses kept as they are",
+			expiry: time.Hour,
+			ips: map[string]BlockedIP{
+				"not-an-address": {Since: 0},
+				"10.0.0.1":       {Since: now}},
+			keys: []string{"10.0.0.1", "not-an-address"},
+			expected: map[string]BlockedIP{
+				"not-an-address": {Since: now},
+				"10.0.0.1":       {Since: now}},
+		},
+	}
+
+	for _, test := range tests {
+
+		keys, minimized := MinimizeBlockedIPs(test.ips, now, test.expiry)
+
+		if !reflect.DeepEqual(keys, test.keys) {
+			t.Errorf("%s: expected the keys %v, got: %v", test.name,
+				test.keys, keys)
+		}
+		if !reflect.DeepEqual(minimized, test.expected) {
+			t.Errorf("%s: expected %v, got: %v", test.name, test.expected,
+				minimized)
+		}
+	}
+}
+
+// TestSiblingPrefix ... the sibling of a network differs in the last bit
+// of its prefix alone
+func TestSiblingPrefix(t *testing.T) {
+
+	tests := []struct {
+		prefix   string
+		expected string
+	}{
+		{"10.0.0.0/32", "10.0.0.1/32"},
+		{"10.0.0.1/32", "10.0.0.0/32"},
+		{"10.0.0.0/31", "10.0.0.2/31"},
+		{"10.0.0.0/24", "10.0.1.0/24"},
+		{"10.0.0.0/8", "11.0.0.0/8"},
+		{"128.0.0.0/1", "0.0.0.0/1"},
+		{"2001:db8::/32", "2001:db9::/32"},
+		{"2001:db8::/65", "2001:db8:0:0:8000::/65"},
+		{"2001:db8::1/128", "2001:db8::/128"},
+	}
+
+	for _, test := range tests {
+
+		sibling := siblingPrefix(netip.MustParsePrefix(test.prefix))
+		if sibling.String() != test.expected {
+			t.Errorf("%s: expected %s, got: %s", test.prefix,
+				test.expected, sibling)
+		}
+	}
+}
@@ -12,28 +12,47 @@ import (
 	"io/ioutil"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/rbisewski/ndefence/ndefenceIO"
 	"github.com/rbisewski/ndefence/ndefenceServer"
 )
 
+//
+// BlockedIP object definition
+//
+type BlockedIP struct {
+
+	// When the address was blocked, in seconds since the epoch; -1 for
+	// addresses blocked for ever, 0 for the time the config is written
+	Since int64
+
+	// When the block ends, in seconds since the epoch; 0 to end it once
+	// the general expiry has passed
+	Until int64
+}
+
 // GenerateBlockedCfg ... updates the blocked IP address config file with
-//                        new entries, if any
+//                        new entries, if any; the entries are merged into
+//                        the fewest networks and sorted, so that the file
+//                        only changes along with the entries
 /*
  * @param    string      /path/to/blockedips.cfg
  * @param    string      server type (nginx, apache2, etc)
- * @param    string[]    list of IPv4 addressess to consider blocking
+ * @param    map         map[IP address or network] = blocked entry
  * @param    string      Datetime, as a string
+ * @param    duration    how long an address stays blocked, unless the
+ *                       entry says otherwise; zero for ever
  *
  * @return   error       error message, if any
- *
- * TODO: test this to ensure it works
  */
-func GenerateBlockedCfg(path string, serverType string, ips map[string]int,
-	datetime string) error {
+func GenerateBlockedCfg(path string, serverType string,
+	ips map[string]BlockedIP, datetime string,
+	expiry time.Duration) error {
 
-	// input validation
-	if path == "" || serverType == "" || len(ips) < 1 || datetime == "" {
+	// input validation; an empty map is fine, since every block may have
+	// expired
+	if path == "" || serverType == "" || datetime == "" {
 		return nil
 	}
 
@@ -42,26 +61,52 @@ func GenerateBlockedCfg(path string, serverType string, ips map[string]int,
 		return nil
 	}
 
-	lines := ""
-	for ip, time := range ips {
+	now, err := strconv.ParseInt(datetime, 10, 64)
+	if err != nil {
+		return fmt.Errorf("GenerateBlockedCfg() --> invalid timestamp: %s",
+			datetime)
+	}
 
-		timeStr := strconv.Itoa(time)
+	keys, entries := MinimizeBlockedIPs(ips, now, expiry)
 
-		if time == -1 {
-			timeStr = "perma"
+	lines := "# Generated by ndefence; entries are merged and sorted on " +
+		"every run.\n"
+	for _, ip := range keys {
+		lines += "deny " + ip + "; # " + describeBlockedIP(entries[ip]) +
+			"\n"
+	}
 
-		} else if time == 0 {
-			timeStr = datetime
-		}
+	// if the file wrote correctly, this will return nil, else this
+	// function will return the error message
+	return ioutil.WriteFile(path, []byte(lines), 0644)
+}
 
-		lines += "deny " + ip + " # " + timeStr + "\n"
+//! Describe a blocked entry for the comment of its line, e.g.
+//! "1516569627 1516656027 (2018-01-21 21:20 UTC until 2018-01-22 21:20
+//! UTC)"; the readable part is ignored when the config is read back.
+/*
+ * @param    BlockedIP    blocked entry
+ *
+ * @return   string       description
+ */
+func describeBlockedIP(entry BlockedIP) string {
+
+	if entry.Since == -1 {
+		return "perma"
 	}
 
-	err := ioutil.WriteFile(path, []byte(lines), 0644)
+	const layout = "2006-01-02 15:04 MST"
+	since := time.Unix(entry.Since, 0).UTC()
 
-	// if the file wrote correctly, this will return nil, else this
-	// function will return the error message
-	return err
+	if entry.Until <= 0 {
+		return strconv.FormatInt(entry.Since, 10) + " (" +
+			since.Format(layout) + ")"
+	}
+
+	until := time.Unix(entry.Until, 0).UTC()
+	return strconv.FormatInt(entry.Since, 10) + " " +
+		strconv.FormatInt(entry.Until, 10) + " (" + since.Format(layout) +
+		" until " + until.Format(layout) + ")"
 }
 
 // GenerateConfig ... spawns a configuration file based on the provided data
@@ -191,7 +236,7 @@ func GenerateConfig(defaultSiteConfigPath string,
 		}
 
 		// TODO: consider implementing this if it is ever needed
-		siteConfigData = siteConfigData
+		_ = siteConfigData
 	}
 
 	// everything worked fine
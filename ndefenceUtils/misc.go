@@ -10,167 +10,136 @@ package ndefenceUtils
 import (
 	"bytes"
 	"fmt"
+	"net/netip"
+	"os"
 	"os/exec"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/rbisewski/ndefence/ndefenceIO"
 )
 
-// IsValidIPv6Address ... validate an IPv6 address
+// ParseIPAddress ... parse an IPv4 or IPv6 address; IPv4-mapped IPv6
+// addresses, e.g. ::ffff:1.2.3.4, are treated as the IPv4 address they map
 /*
- * @param     string    /path/to/file
- *
- * @return    bool      whether or not this is true
+ * @param     string        IP address
  *
- * TODO: add more logic to this function
+ * @return    netip.Addr    parsed address
+ * @return    error         error message, if any
  */
-func IsValidIPv6Address(ip string) bool {
+func ParseIPAddress(ip string) (netip.Addr, error) {
 
 	// input validation
 	if len(ip) < 1 {
-		return false
+		return netip.Addr{}, fmt.Errorf("ParseIPAddress() --> invalid " +
+			"input")
 	}
 
-	// variable declaration
-	wasEveryPieceBlank := true
-
-	// attempt to split the string into pieces via the ':' char
-	ipPieces := strings.Split(ip, ":")
-
-	// safety check, ensure there is at least one piece
-	if len(ipPieces) < 1 {
-		return false
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("ParseIPAddress() --> not an IP "+
+			"address: %s", ip)
 	}
 
-	// for every hexadecimal piece of the IPv6 address...
-	for _, hexa := range ipPieces {
-
-		// if the hexadecimal was blank, go ahead and switch over to
-		// the next element, since the IPv6 spec does allow for some
-		// elements to be blank, in certain situations...
-		if len(hexa) == 0 {
-			continue
-
-			// ensure it has a length between 1 and 4
-		} else if len(hexa) < 1 || len(hexa) > 4 {
-			return false
-		}
-
-		// convert the ip_piece string to an integer
-		hexaAsUint, err := strconv.ParseUint(hexa, 16, 64)
+	// a zone, e.g. fe80::1%eth0, only has meaning on the local host
+	if addr.Zone() != "" {
+		return netip.Addr{}, fmt.Errorf("ParseIPAddress() --> zoned "+
+			"address given: %s", ip)
+	}
 
-		// if an error occurs, go ahead and return false
-		if err != nil {
-			return false
-		}
+	return addr.Unmap(), nil
+}
 
-		// if greater than 0xFFFF pass back a false
-		if hexaAsUint > 65535 {
-			return false
-		}
+// CanonicalIPAddress ... format an IP address the same way every time, so
+// that e.g. 2001:DB8:0::1 and 2001:db8::1 are counted as one client
+/*
+ * @param     string    IP address
+ *
+ * @return    string    canonical form, as per RFC 5952 for IPv6
+ * @return    error     error message, if any
+ */
+func CanonicalIPAddress(ip string) (string, error) {
 
-		// having gotten this far, then at least 1 of the hexademical
-		// chunks was non-blank, so set the flag
-		wasEveryPieceBlank = false
+	addr, err := ParseIPAddress(ip)
+	if err != nil {
+		return "", err
 	}
 
-	// in the scenario where every case was blank, then some silly input
-	// like ":::::::" was given, so return false here
-	if wasEveryPieceBlank {
-		return false
-	}
+	return addr.String(), nil
+}
 
-	// if all the tests passed, go ahead and return true
-	return true
+// IsValidIPAddress ... validate an IPv4 or IPv6 address
+/*
+ * @param     string    IP address
+ *
+ * @return    bool      whether or not this is true
+ */
+func IsValidIPAddress(ip string) bool {
+	_, err := ParseIPAddress(ip)
+	return err == nil
+}
+
+// IsValidIPv6Address ... validate an IPv6 address
+/*
+ * @param     string    IP address
+ *
+ * @return    bool      whether or not this is true
+ */
+func IsValidIPv6Address(ip string) bool {
+	addr, err := ParseIPAddress(ip)
+	return err == nil && addr.Is6()
 }
 
 // IsValidIPv4Address ... validate an IPv4 address
 /*
- * @param     string    /path/to/file
+ * @param     string    IP address
  *
  * @return    bool      whether or not this is true
  */
 func IsValidIPv4Address(ip string) bool {
-
-	// input validation
-	if len(ip) < 1 {
-		return false
-	}
-
-	// ensure that the ip address is valid length
-	//
-	// 0.0.0.0 --> 8 chars (min)
-	//
-	// 127.123.123.123 --> 15 chars (max)
-	//
-	if len(ip) < 8 || len(ip) > 15 {
-		return false
-	}
-
-	// attempt to split the string into pieces via the '.' char
-	ipPieces := strings.Split(ip, ".")
-
-	// ensure that there are at least 4 pieces
-	if len(ipPieces) != 4 {
-		return false
-	}
-
-	// for every oct piece of the IPv4 address...
-	for _, oct := range ipPieces {
-
-		// ensure it has a length of at least 1
-		if len(oct) < 1 {
-			return false
-		}
-
-		// convert the ip_piece string to an integer
-		octAsUint, err := strconv.ParseUint(oct, 0, 10)
-
-		// if an error occurred, throw back a false
-		if err != nil {
-			return false
-		}
-
-		// ensure that the integer is between 0 and 255; actually it is a
-		// unsigned int at this point, so only need check if > 255
-		if octAsUint > 255 {
-			return false
-		}
-	}
-
-	// otherwise it appears to be a proper IPv4
-	return true
+	addr, err := ParseIPAddress(ip)
+	return err == nil && addr.Is4()
 }
 
-// SpaceFormatIPv4 ... take a given IP address and space buffer it so that
-// it is always 15 characters long.
+// SpaceFormatIP ... take a given IP address and space buffer it so that
+// IPv4 addresses are always 16 characters long, and IPv6 addresses 40.
 /*
- * @param    string    IPv4 address
+ * @param    string    IP address, or a network in CIDR notation
  *
- * @param    string    space-formatted IPv4 address
+ * @param    string    space-formatted IP address
  * @param    error     error message, if any
  */
-func SpaceFormatIPv4(ip string) (string, error) {
+func SpaceFormatIP(ip string) (string, error) {
 
 	// input validation
-	if len(ip) < 1 || len(ip) > 15 {
-		return "", fmt.Errorf("spaceFormatIPv4() --> invalid input")
+	if len(ip) < 1 {
+		return "", fmt.Errorf("SpaceFormatIP() --> invalid input")
 	}
 
-	// ensure this is actually a IPv4 address
-	if !IsValidIPv4Address(ip) {
-		return "", fmt.Errorf("spaceFormatIPv4() --> given IP is not " +
-			"an IPv4 address\n")
+	// ensure this is actually an address, or a network
+	width := 16
+	if prefix, err := netip.ParsePrefix(ip); err == nil {
+		if prefix.Addr().Is6() {
+			width = 40
+		}
+	} else if addr, err := ParseIPAddress(ip); err == nil {
+		if addr.Is6() {
+			width = 40
+		}
+	} else {
+		return "", fmt.Errorf("SpaceFormatIP() --> given value is not " +
+			"an IP address")
 	}
 
-	// attempt to format the IPv4 address
+	// attempt to format the IP address
 	spaceFormattedIPAddress := ip
-	for len(spaceFormattedIPAddress) < 16 {
+	for len(spaceFormattedIPAddress) < width {
 		spaceFormattedIPAddress += " "
 	}
 
-	// return the formatted IPv4 string
+	// return the formatted IP string
 	return spaceFormattedIPAddress, nil
 }
 
@@ -184,55 +153,126 @@ func SpaceFormatIPv4(ip string) (string, error) {
  */
 func ObtainSlash24FromIpv4(ip string) (string, error) {
 
-	// input validation
-	if len(ip) < 1 {
-		return "", fmt.Errorf("obtainSlash24FromIpv4() --> invalid input")
-	}
-
-	// ensure the given value is actually an IP4 address
+	// ensure the given value is actually an IPv4 address
 	if !IsValidIPv4Address(ip) {
 		return "", fmt.Errorf("obtainSlash24FromIpv4() --> improper " +
 			"IPv4 address given")
 	}
 
-	// variable declaration
-	ipv4Slash24Cidr := ""
+	return ObtainNetwork(ip, 24)
+}
+
+// ObtainSlash64FromIpv6 ... convert a given IPv6 address to the /64 it
+// belongs to, which usually corresponds to a single subscriber or host
+/*
+ * @param    string    an IPv6 address
+ *
+ * @return   string    result as a /64, e.g. 2001:db8:1:2::/64
+ * @return   error     error message, if any
+ */
+func ObtainSlash64FromIpv6(ip string) (string, error) {
+
+	// ensure the given value is actually an IPv6 address
+	if !IsValidIPv6Address(ip) {
+		return "", fmt.Errorf("ObtainSlash64FromIpv6() --> improper " +
+			"IPv6 address given")
+	}
+
+	return ObtainNetwork(ip, 64)
+}
+
+// ObtainNetwork ... convert a given IP address to the network of the given
+// prefix length it belongs to, in CIDR notation
+/*
+ * @param    string    an IP address
+ * @param    int       prefix length, e.g. 24
+ *
+ * @return   string    network, e.g. 10.1.2.0/24
+ * @return   error     error message, if any
+ */
+func ObtainNetwork(ip string, bits int) (string, error) {
+
+	addr, err := ParseIPAddress(ip)
+	if err != nil {
+		return "", err
+	}
 
-	// separate the IPv4 address string into pieces
-	ipPieces := strings.Split(ip, ".")
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return "", fmt.Errorf("ObtainNetwork() --> invalid prefix "+
+			"length for %s: %d", ip, bits)
+	}
+
+	return prefix.String(), nil
+}
 
-	// ensure that there are at least 4 pieces
-	if len(ipPieces) != 4 {
-		return "", fmt.Errorf("obtainSlash24FromIpv4() --> " +
-			"non-standard IPv4 address")
+// SortIPAddresses ... sort IP addresses numerically, IPv4 before IPv6, with
+// networks sorted by their first address; anything that is not an address
+// is sorted last, as a string
+/*
+ * @param    string[]    IP addresses and networks, sorted in place
+ */
+func SortIPAddresses(ips []string) {
+
+	// a network sorts by its first address, ahead of that address itself
+	parse := func(ip string) (netip.Addr, int, error) {
+		if prefix, err := netip.ParsePrefix(ip); err == nil {
+			return prefix.Masked().Addr().Unmap(), prefix.Bits(), nil
+		}
+		addr, err := ParseIPAddress(ip)
+		return addr, addr.BitLen(), err
 	}
 
-	// reconstruct the IPv4 address string
-	ipv4Slash24Cidr += ipPieces[0]
-	ipv4Slash24Cidr += "."
-	ipv4Slash24Cidr += ipPieces[1]
-	ipv4Slash24Cidr += "."
-	ipv4Slash24Cidr += ipPieces[2]
-	ipv4Slash24Cidr += "."
-	ipv4Slash24Cidr += "0/24"
-
-	// having gone this far, return the adjusted result
-	return ipv4Slash24Cidr, nil
+	sort.SliceStable(ips, func(i, j int) bool {
+
+		a, bitsA, errA := parse(ips[i])
+		b, bitsB, errB := parse(ips[j])
+
+		switch {
+		case errA == nil && errB == nil && a != b:
+			return a.Less(b)
+		case errA == nil && errB == nil:
+			return bitsA < bitsB
+		case errA == nil || errB == nil:
+			return errA == nil
+		}
+
+		return ips[i] < ips[j]
+	})
+}
+
+// CanonicalBlockedEntry ... validate an address or network of the blocked
+// IP config, e.g. 10.0.0.2, 10.0.0.0/24 or 2001:db8::/64
+/*
+ * @param    string    address or network
+ *
+ * @return   string    canonical form of the entry
+ * @return   error     error message, if any
+ */
+func CanonicalBlockedEntry(entry string) (string, error) {
+
+	if prefix, err := netip.ParsePrefix(entry); err == nil {
+		return prefix.Masked().String(), nil
+	}
+
+	return CanonicalIPAddress(entry)
 }
 
 // ReadBlockedIPConfig ... read blocked ip values and return as an array
 /*
  * @param    string      /path/to/blockedips.cfg
  * @param    string      server type (nginx, apache2, etc)
- * @param    string      Datetime, as a string
+ * @param    string      current time, in seconds since the epoch
+ * @param    duration    how long an address stays blocked, unless the
+ *                       entry says otherwise; zero for ever
  *
- * @return   map         map[IPv4 Address] = timestamp, in seconds
+ * @return   map         map[IP address or network] = blocked entry
  * @return   error       error message, if any
  *
  * TODO: test this to ensure it works
  */
-func ReadBlockedIPConfig(path string, stype string,
-	datetime string) (map[string]int, error) {
+func ReadBlockedIPConfig(path string, stype string, datetime string,
+	expiry time.Duration) (map[string]BlockedIP, error) {
 
 	// input validation
 	if path == "" || stype == "" || datetime == "" {
@@ -240,7 +280,18 @@ func ReadBlockedIPConfig(path string, stype string,
 			"input")
 	}
 
-	listOfBlockedIPs := make(map[string]int, 0)
+	listOfBlockedIPs := make(map[string]BlockedIP, 0)
+
+	currentTime, err := strconv.ParseInt(datetime, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("ReadBlockedIPConfig() --> invalid "+
+			"timestamp: %s", datetime)
+	}
+
+	// nothing has been blocked yet if the config does not exist
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return listOfBlockedIPs, nil
+	}
 
 	lines, err := ndefenceIO.TokenizeFile(path, "\n")
 	if err != nil {
@@ -251,11 +302,14 @@ func ReadBlockedIPConfig(path string, stype string,
 
 		//
 		// IP addresses in the blocked IPs file should be in the
-		// form of "address # timestamp" or "address # perma" like
-		// the example below:
+		// form of "address # timestamp", "address # timestamp until"
+		// or "address # perma", optionally followed by a readable
+		// note in parentheses, like the example below:
 		//
-		// 127.0.0.1 # perma
-		// 10.0.0.2 # 1516569627
+		// deny 127.0.0.1; # perma
+		// deny 10.0.0.2; # 1516569627
+		// deny 10.0.0.3; # 1516569627 1516656027
+		// deny 2001:db8::/64; # 1516569627 (2018-01-21 21:20 UTC)
 		//
 		pieces := strings.Split(line, "#")
 
@@ -267,45 +321,57 @@ func ReadBlockedIPConfig(path string, stype string,
 			continue
 		}
 
-		// remove the "deny " at the start of the entry
+		// remove the "deny " at the start of the entry, along with the
+		// trailing semicolon, if any
 		possibleIP := strings.TrimSpace(pieces[0])
-		possibleIP = strings.Trim(possibleIP, "deny")
+		possibleIP = strings.TrimPrefix(possibleIP, "deny")
+		possibleIP = strings.TrimSuffix(possibleIP, ";")
 
-		// trim and validate the IP
-		ip := strings.TrimSpace(possibleIP)
-		if !IsValidIPv4Address(ip) {
+		// trim and validate the IP, or network of IPs
+		ip, err := CanonicalBlockedEntry(strings.TrimSpace(possibleIP))
+		if err != nil {
 			continue
 		}
 
-		// trim the timestamp string
-		timestampStr := strings.TrimSpace(pieces[1])
-		if timestampStr == "" {
+		// split the timestamp from the end of the block, if any,
+		// ignoring the readable note
+		timestamps := strings.Fields(strings.SplitN(pieces[1], "(",
+			2)[0])
+		if len(timestamps) < 1 {
 			continue
 		}
 
 		// perma entries will stay blocked forever
-		if timestampStr == "perma" {
-			listOfBlockedIPs[ip] = -1
+		if timestamps[0] == "perma" {
+			listOfBlockedIPs[ip] = BlockedIP{Since: -1}
 			continue
 		}
 
-		timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
+		timestamp, err := strconv.ParseInt(timestamps[0], 10, 64)
 		if err != nil {
 			continue
 		}
 
-		currentTime, err := strconv.ParseInt(datetime, 10, 64)
-		if err != nil {
-			continue
+		// entries blocked for a set time end at the time given, the
+		// others once the general expiry has passed
+		entry := BlockedIP{Since: timestamp}
+		if len(timestamps) > 1 {
+			entry.Until, err = strconv.ParseInt(timestamps[1], 10, 64)
+			if err != nil {
+				continue
+			}
 		}
 
-		// if the IP has been blocked for 48 hours in seconds, skip
-		// it since it has been blocked long enough
-		if currentTime-timestamp > 172800 {
+		// if the IP has been blocked for long enough, skip it
+		if entry.Until > 0 && currentTime >= entry.Until {
+			continue
+		}
+		if entry.Until == 0 && expiry > 0 &&
+			currentTime-timestamp > int64(expiry.Seconds()) {
 			continue
 		}
 
-		listOfBlockedIPs[ip] = int(timestamp)
+		listOfBlockedIPs[ip] = entry
 	}
 
 	return listOfBlockedIPs, nil
@@ -337,6 +403,50 @@ func IsStringInArray(str string, stringArray []string) bool {
 	return false
 }
 
+// FormatCounts ... describe a map of counts, ordered by key
+/*
+ * @param     map       map[key] = count
+ *
+ * @return    string    e.g. "blog: 3, shop: 12"
+ */
+func FormatCounts(counts map[string]int) string {
+
+	// variable declaration
+	keys := make([]string, 0, len(counts))
+	pieces := make([]string, 0, len(counts))
+
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		pieces = append(pieces, key+": "+strconv.Itoa(counts[key]))
+	}
+
+	return strings.Join(pieces, ", ")
+}
+
+// FormatDuration ... describe a span of time without the trailing zero
+// minutes / seconds, e.g. 24h0m0s --> 24h
+/*
+ * @param     duration    span of time
+ *
+ * @return    string      e.g. "24h" or "1h30m"
+ */
+func FormatDuration(span time.Duration) string {
+
+	text := span.String()
+	if strings.HasSuffix(text, "m0s") {
+		text = strings.TrimSuffix(text, "0s")
+	}
+	if strings.HasSuffix(text, "h0m") {
+		text = strings.TrimSuffix(text, "0m")
+	}
+
+	return text
+}
+
 //RunNginxReloadCommand ... Attempt to execute a given command.
 /*
  *  @param    none